@@ -1,7 +0,0 @@
-//go:build protogo
-
-package main
-
-import "github.com/kevpar/test/ttrpcstress/protogo"
-
-type payload = protogo.Payload
@@ -1,7 +0,0 @@
-//go:build protogogo
-
-package main
-
-import "github.com/kevpar/test/ttrpcstress/protogogo"
-
-type payload = protogogo.Payload
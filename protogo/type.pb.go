@@ -1,6 +1,6 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.28.1
+// 	protoc-gen-go v1.34.2
 // 	protoc        v3.21.12
 // source: github.com/kevpar/test/ttrpcstress/protogo/type.proto
 
@@ -26,6 +26,22 @@ type Payload struct {
 	unknownFields protoimpl.UnknownFields
 
 	Value uint32 `protobuf:"varint,1,opt,name=value,proto3" json:"value,omitempty"`
+	// data is filler used to vary the size of requests and responses.
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	// checksum is the CRC-32 (IEEE) of data, so that the receiver can detect
+	// corruption independently of the echoed data.
+	Checksum uint32 `protobuf:"varint,3,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	// worker is the client worker that sent the request, and sequence a number
+	// unique to the request among all those the client sent. Both are echoed in
+	// the response, so that a response delivered to the wrong call is caught
+	// even where the two requests carried the same value.
+	Worker   uint32 `protobuf:"varint,4,opt,name=worker,proto3" json:"worker,omitempty"`
+	Sequence uint64 `protobuf:"varint,5,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// data_hash is the FNV-1a hash of sequence, as 8 big-endian bytes, followed
+	// by data, computed by the sender of each message. It binds the data to the
+	// request, so that data spliced from another message is caught even if it
+	// happens to be intact.
+	DataHash uint64 `protobuf:"varint,6,opt,name=data_hash,json=dataHash,proto3" json:"data_hash,omitempty"`
 }
 
 func (x *Payload) Reset() {
@@ -67,19 +83,62 @@ func (x *Payload) GetValue() uint32 {
 	return 0
 }
 
+func (x *Payload) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *Payload) GetChecksum() uint32 {
+	if x != nil {
+		return x.Checksum
+	}
+	return 0
+}
+
+func (x *Payload) GetWorker() uint32 {
+	if x != nil {
+		return x.Worker
+	}
+	return 0
+}
+
+func (x *Payload) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *Payload) GetDataHash() uint64 {
+	if x != nil {
+		return x.DataHash
+	}
+	return 0
+}
+
 var File_github_com_kevpar_test_ttrpcstress_protogo_type_proto protoreflect.FileDescriptor
 
 var file_github_com_kevpar_test_ttrpcstress_protogo_type_proto_rawDesc = []byte{
 	0x0a, 0x35, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6b, 0x65, 0x76,
 	0x70, 0x61, 0x72, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x2f, 0x74, 0x74, 0x72, 0x70, 0x63, 0x73, 0x74,
 	0x72, 0x65, 0x73, 0x73, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x67, 0x6f, 0x2f, 0x74, 0x79, 0x70,
-	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x04, 0x74, 0x79, 0x70, 0x65, 0x22, 0x1f, 0x0a,
-	0x07, 0x50, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75,
-	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x42, 0x2c,
-	0x5a, 0x2a, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6b, 0x65, 0x76,
-	0x70, 0x61, 0x72, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x2f, 0x74, 0x74, 0x72, 0x70, 0x63, 0x73, 0x74,
-	0x72, 0x65, 0x73, 0x73, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x67, 0x6f, 0x62, 0x06, 0x70, 0x72,
-	0x6f, 0x74, 0x6f, 0x33,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x04, 0x74, 0x79, 0x70, 0x65, 0x22, 0xa0, 0x01,
+	0x0a, 0x07, 0x50, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12,
+	0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64,
+	0x61, 0x74, 0x61, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x12,
+	0x16, 0x0a, 0x06, 0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x06, 0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65,
+	0x6e, 0x63, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65,
+	0x6e, 0x63, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x64, 0x61, 0x74, 0x61, 0x5f, 0x68, 0x61, 0x73, 0x68,
+	0x18, 0x06, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x64, 0x61, 0x74, 0x61, 0x48, 0x61, 0x73, 0x68,
+	0x42, 0x2c, 0x5a, 0x2a, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6b,
+	0x65, 0x76, 0x70, 0x61, 0x72, 0x2f, 0x74, 0x65, 0x73, 0x74, 0x2f, 0x74, 0x74, 0x72, 0x70, 0x63,
+	0x73, 0x74, 0x72, 0x65, 0x73, 0x73, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x67, 0x6f, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -95,7 +154,7 @@ func file_github_com_kevpar_test_ttrpcstress_protogo_type_proto_rawDescGZIP() []
 }
 
 var file_github_com_kevpar_test_ttrpcstress_protogo_type_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
-var file_github_com_kevpar_test_ttrpcstress_protogo_type_proto_goTypes = []interface{}{
+var file_github_com_kevpar_test_ttrpcstress_protogo_type_proto_goTypes = []any{
 	(*Payload)(nil), // 0: type.Payload
 }
 var file_github_com_kevpar_test_ttrpcstress_protogo_type_proto_depIdxs = []int32{
@@ -112,7 +171,7 @@ func file_github_com_kevpar_test_ttrpcstress_protogo_type_proto_init() {
 		return
 	}
 	if !protoimpl.UnsafeEnabled {
-		file_github_com_kevpar_test_ttrpcstress_protogo_type_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+		file_github_com_kevpar_test_ttrpcstress_protogo_type_proto_msgTypes[0].Exporter = func(v any, i int) any {
 			switch v := v.(*Payload); i {
 			case 0:
 				return &v.state
@@ -21,7 +21,23 @@ var _ = math.Inf
 const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
 
 type Payload struct {
-	Value                uint32   `protobuf:"varint,1,opt,name=value,proto3" json:"value,omitempty"`
+	Value uint32 `protobuf:"varint,1,opt,name=value,proto3" json:"value,omitempty"`
+	// data is filler used to vary the size of requests and responses.
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	// checksum is the CRC-32 (IEEE) of data, so that the receiver can detect
+	// corruption independently of the echoed data.
+	Checksum uint32 `protobuf:"varint,3,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	// worker is the client worker that sent the request, and sequence a number
+	// unique to the request among all those the client sent. Both are echoed in
+	// the response, so that a response delivered to the wrong call is caught
+	// even where the two requests carried the same value.
+	Worker   uint32 `protobuf:"varint,4,opt,name=worker,proto3" json:"worker,omitempty"`
+	Sequence uint64 `protobuf:"varint,5,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// data_hash is the FNV-1a hash of sequence, as 8 big-endian bytes, followed
+	// by data, computed by the sender of each message. It binds the data to the
+	// request, so that data spliced from another message is caught even if it
+	// happens to be intact.
+	DataHash             uint64   `protobuf:"varint,6,opt,name=data_hash,json=dataHash,proto3" json:"data_hash,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -58,6 +74,41 @@ func (m *Payload) GetValue() uint32 {
 	return 0
 }
 
+func (m *Payload) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *Payload) GetChecksum() uint32 {
+	if m != nil {
+		return m.Checksum
+	}
+	return 0
+}
+
+func (m *Payload) GetWorker() uint32 {
+	if m != nil {
+		return m.Worker
+	}
+	return 0
+}
+
+func (m *Payload) GetSequence() uint64 {
+	if m != nil {
+		return m.Sequence
+	}
+	return 0
+}
+
+func (m *Payload) GetDataHash() uint64 {
+	if m != nil {
+		return m.DataHash
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Payload)(nil), "type.Payload")
 }
@@ -67,13 +118,18 @@ func init() {
 }
 
 var fileDescriptor_668d7fb83c7679f9 = []byte{
-	// 122 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x32, 0x4f, 0xcf, 0x2c, 0xc9,
-	0x28, 0x4d, 0xd2, 0x4b, 0xce, 0xcf, 0xd5, 0xcf, 0x4e, 0x2d, 0x2b, 0x48, 0x2c, 0xd2, 0x2f, 0x49,
-	0x2d, 0x2e, 0xd1, 0x2f, 0x29, 0x29, 0x2a, 0x48, 0x2e, 0x2e, 0x29, 0x4a, 0x2d, 0x2e, 0xd6, 0x2f,
-	0x28, 0xca, 0x2f, 0xc9, 0x4f, 0xcf, 0x4f, 0xcf, 0xd7, 0x2f, 0xa9, 0x2c, 0x48, 0xd5, 0x03, 0x73,
-	0x85, 0x58, 0x40, 0x6c, 0x25, 0x79, 0x2e, 0xf6, 0x80, 0xc4, 0xca, 0x9c, 0xfc, 0xc4, 0x14, 0x21,
-	0x11, 0x2e, 0xd6, 0xb2, 0xc4, 0x9c, 0xd2, 0x54, 0x09, 0x46, 0x05, 0x46, 0x0d, 0xde, 0x20, 0x08,
-	0xc7, 0x49, 0x2f, 0x4a, 0x87, 0x14, 0x1b, 0x92, 0xd8, 0xc0, 0x4c, 0x63, 0x40, 0x00, 0x00, 0x00,
-	0xff, 0xff, 0xb5, 0xaa, 0x0f, 0x12, 0x98, 0x00, 0x00, 0x00,
+	// 205 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x8f, 0xb1, 0x4e, 0xc4, 0x30,
+	0x0c, 0x86, 0x15, 0xc8, 0x15, 0x88, 0x60, 0x89, 0x10, 0x8a, 0x60, 0xa9, 0x98, 0x3a, 0xa0, 0x66,
+	0x60, 0x60, 0x67, 0x62, 0x44, 0x1d, 0x59, 0x90, 0x2f, 0x67, 0x35, 0xa8, 0x77, 0x24, 0xc4, 0xce,
+	0xa1, 0xbe, 0x0d, 0x8f, 0x8a, 0x92, 0xa2, 0xee, 0x6c, 0xdf, 0xe7, 0xdf, 0xb6, 0xf4, 0xab, 0xa7,
+	0xf1, 0x83, 0x7d, 0xde, 0xf6, 0x2e, 0x1c, 0xec, 0x84, 0xc7, 0x08, 0xc9, 0x32, 0x12, 0x5b, 0xe6,
+	0x14, 0x1d, 0x71, 0x42, 0x22, 0x1b, 0x53, 0xe0, 0x30, 0x86, 0x31, 0x58, 0x9e, 0x23, 0xf6, 0x55,
+	0xb5, 0x2c, 0x7c, 0xff, 0x23, 0xd4, 0xd9, 0x2b, 0xcc, 0xfb, 0x00, 0x3b, 0x7d, 0xad, 0x36, 0x47,
+	0xd8, 0x67, 0x34, 0xa2, 0x15, 0xdd, 0xd5, 0xb0, 0x88, 0xd6, 0x4a, 0xee, 0x80, 0xc1, 0x9c, 0xb4,
+	0xa2, 0xbb, 0x1c, 0x2a, 0xeb, 0x5b, 0x75, 0xee, 0x3c, 0xba, 0x89, 0xf2, 0xc1, 0x9c, 0xd6, 0xe5,
+	0xd5, 0xf5, 0x8d, 0x6a, 0xbe, 0x43, 0x9a, 0x30, 0x19, 0x59, 0x93, 0x3f, 0x2b, 0x37, 0x84, 0x5f,
+	0x19, 0x3f, 0x1d, 0x9a, 0x4d, 0x2b, 0x3a, 0x39, 0xac, 0xae, 0xef, 0xd4, 0x45, 0xf9, 0xfb, 0xee,
+	0x81, 0xbc, 0x69, 0x96, 0xb0, 0x0c, 0x5e, 0x80, 0xfc, 0x73, 0xff, 0xf6, 0xf0, 0x9f, 0x8e, 0xdb,
+	0xa6, 0xe2, 0xe3, 0xef, 0x00, 0x35, 0xda, 0xce, 0x2a, 0x1a, 0x01, 0x00, 0x00,
 }
@@ -0,0 +1,257 @@
+// Package stress implements the ttrpcstress commands. RunServer, Serve, and
+// RunClient expose the server and client workloads, so that the stress scenario
+// can be embedded in a Go test, for instance to run it under -race, rather than
+// run as a separate binary.
+//
+// As with the command, the package must be built with the protogo or protogogo
+// tag to select a payload encoding that matches the ttrpc version in use.
+package stress
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// ServerOptions configures a server started by RunServer or Serve. The zero
+// value is a server that echoes every request immediately.
+type ServerOptions struct {
+	// Protocol is "ttrpc", the default, or "grpc" to serve the unary methods
+	// over gRPC.
+	Protocol string
+	// ResponseDelay holds each request this long before responding, plus a
+	// random time of up to ResponseJitter.
+	ResponseDelay  time.Duration
+	ResponseJitter time.Duration
+	// Reorder holds each even-valued request until that many odd-valued ones
+	// have been answered, or for at most ReorderHold, 100ms if zero, so that
+	// responses complete out of order.
+	Reorder     int
+	ReorderHold time.Duration
+	// SlowDelay holds each request to the slow method of a call mix this long,
+	// or 10ms if zero.
+	SlowDelay time.Duration
+	// ResponseBytes is the size of the data in each response. Zero echoes the
+	// request's data.
+	ResponseBytes int
+	// DuplicateRate is the fraction of responses that are sent twice.
+	DuplicateRate float64
+	// ErrorRate is the fraction of requests failed with an injected error, of a
+	// code chosen at random from ErrorCodes, or codes.Aborted if it is empty.
+	ErrorRate  float64
+	ErrorCodes []codes.Code
+	// PanicRate is the fraction of requests whose handlers panic, which
+	// RecoverPanics recovers in an interceptor rather than leaving to ttrpc.
+	PanicRate     float64
+	RecoverPanics bool
+	// MaxConcurrent bounds the number of unary handlers running at once,
+	// queueing the requests beyond them. Zero means no limit.
+	MaxConcurrent int
+	// IdleTimeout closes connections idle for this long. Zero disables it.
+	IdleTimeout time.Duration
+	// WriteChunk splits each write to a connection into chunks of at most this
+	// many bytes, with WritePause between them. Zero writes whole.
+	WriteChunk int
+	WritePause time.Duration
+	// Seed makes the random choices above, and is chosen at random if zero.
+	Seed uint64
+	// DrainTimeout is how long in-flight requests are given to complete once
+	// the context is cancelled, five seconds if zero.
+	DrainTimeout time.Duration
+}
+
+func (o ServerOptions) config(addr string) serverConfig {
+	cfg := serverConfig{
+		addr:           addr,
+		protocol:       o.Protocol,
+		duplicateRate:  o.DuplicateRate,
+		errorRate:      o.ErrorRate,
+		errorCodes:     o.ErrorCodes,
+		panicRate:      o.PanicRate,
+		recoverPanics:  o.RecoverPanics,
+		idleTimeout:    o.IdleTimeout,
+		maxConcurrent:  o.MaxConcurrent,
+		responseBytes:  o.ResponseBytes,
+		responseDelay:  o.ResponseDelay,
+		responseJitter: o.ResponseJitter,
+		slowDelay:      o.SlowDelay,
+		reorder:        o.Reorder,
+		reorderHold:    o.ReorderHold,
+		drainTimeout:   o.DrainTimeout,
+		writeChunks:    writeChunks{o.WriteChunk, o.WritePause},
+		shutdownMode:   "graceful",
+		seed:           o.Seed,
+	}
+	if cfg.responseBytes == 0 {
+		cfg.responseBytes = -1
+	}
+	if cfg.reorderHold == 0 {
+		cfg.reorderHold = defaultReorderHold
+	}
+	if cfg.slowDelay == 0 {
+		cfg.slowDelay = defaultSlowDelay
+	}
+	if cfg.drainTimeout == 0 {
+		cfg.drainTimeout = defaultDrainTimeout
+	}
+	if len(cfg.errorCodes) == 0 {
+		cfg.errorCodes = []codes.Code{injectedErrorCode}
+	}
+	return cfg
+}
+
+// RunServer listens on addr, in any of the forms accepted by the server
+// command's -addr flag, and serves until ctx is cancelled.
+func RunServer(ctx context.Context, addr string, opts ServerOptions) error {
+	return runServer(ctx, opts.config(addr))
+}
+
+// Serve serves on l until ctx is cancelled. l is closed on return.
+func Serve(ctx context.Context, l net.Listener, opts ServerOptions) error {
+	return serve(ctx, l, opts.config(l.Addr().String()))
+}
+
+// ClientOptions configures a run of RunClient. Zero values select the same
+// defaults as the client command's flags.
+type ClientOptions struct {
+	// Addr is the server address, in any of the forms accepted by the client
+	// command's -addr flag. It is ignored if Dial is set.
+	Addr string
+	// Dial, if set, opens each connection to the server.
+	Dial func() (net.Conn, error)
+	// Protocol is "ttrpc", the default, or "grpc" to make the unary calls over
+	// gRPC, to a server with the same Protocol.
+	Protocol string
+	// Iters is the number of calls to send, or with Mode "stream", the number
+	// of messages on each stream. Duration sends continuously for that long
+	// instead.
+	Iters    int
+	Duration time.Duration
+	// Warmup is the number of calls to send before the run, which are
+	// verified but excluded from its timing and stats.
+	Warmup int
+	// Workers is the number of concurrent workers, spread across Conns
+	// connections. Each defaults to 1.
+	Workers int
+	Conns   int
+	// Mode is "unary" or "stream", and StreamType, for streams, is "bidi",
+	// "server", or "client".
+	Mode       string
+	StreamType string
+	// Method is "echo", "ping", "large", or "mixed". Mix, if set, spreads calls
+	// across methods by weight instead, as the client command's -mix flag does.
+	Method string
+	Mix    string
+	// PayloadBytes is the size of the data sent with each request, which is
+	// pseudo-random with PayloadRandom. RandomValues sends pseudo-random request
+	// values. Seed seeds both, along with CancelRate's choice of calls, and is
+	// chosen at random if zero.
+	PayloadBytes  int
+	PayloadRandom bool
+	RandomValues  bool
+	Seed          uint64
+	// ResponseBytes is the size of the data expected in each response, which
+	// must match the server's. Zero expects the request's data to be echoed.
+	ResponseBytes int
+	// Rate limits dispatch to this many calls per second, and MaxInflight the
+	// number of calls outstanding at once. Zero disables either limit.
+	Rate        float64
+	MaxInflight int
+	// ReadRate throttles reading from each connection to this many bytes per
+	// second, as a client not keeping up with responses would. Zero disables
+	// it.
+	ReadRate float64
+	// WriteChunk splits each write to a connection into chunks of at most this
+	// many bytes, with WritePause between them. Zero writes whole.
+	WriteChunk int
+	WritePause time.Duration
+	// BurstSize, if set, has every worker send this many calls at once, all
+	// workers together, idling for BurstInterval between bursts.
+	BurstSize     int
+	BurstInterval time.Duration
+	// CallTimeout gives up on calls that take longer than this, which are
+	// counted and fail the run once it completes, or with AbortOnTimeout, end
+	// it at the first.
+	CallTimeout    time.Duration
+	AbortOnTimeout bool
+	// CancelRate is the fraction of unary calls cancelled after a random time
+	// of up to CancelAfter, one millisecond if zero.
+	CancelRate  float64
+	CancelAfter time.Duration
+	// Reconnect re-dials a connection that drops, and retries its calls.
+	Reconnect bool
+	// DrainTimeout is how long calls in flight are given to complete once
+	// the context is cancelled, which stops sending at once.
+	DrainTimeout time.Duration
+	// Watchdog reports a stall, with a dump of all goroutines, when no call
+	// completes for this long. Zero disables it.
+	Watchdog time.Duration
+	// Duplex, if set, is an address to serve on for the server to run the
+	// same workload back to, as with the client command's -duplex.
+	Duplex string
+}
+
+// RunClient runs a client workload and returns its result. The error is that
+// recorded in the result, or one that prevented the run from starting, in which
+// case the result is empty.
+func RunClient(ctx context.Context, opts ClientOptions) (ClientResult, error) {
+	var res ClientResult
+	cfg := clientConfig{
+		addr:            opts.Addr,
+		dial:            opts.Dial,
+		protocol:        opts.Protocol,
+		iters:           opts.Iters,
+		duration:        opts.Duration,
+		warmup:          opts.Warmup,
+		workers:         max(opts.Workers, 1),
+		conns:           max(opts.Conns, 1),
+		mode:            opts.Mode,
+		streamType:      opts.StreamType,
+		method:          opts.Method,
+		mix:             opts.Mix,
+		payloadBytes:    opts.PayloadBytes,
+		payloadRandom:   opts.PayloadRandom,
+		randomValues:    opts.RandomValues,
+		seed:            opts.Seed,
+		expectRespBytes: opts.ResponseBytes,
+		rate:            opts.Rate,
+		maxInflight:     opts.MaxInflight,
+		readRate:        opts.ReadRate,
+		writeChunks:     writeChunks{opts.WriteChunk, opts.WritePause},
+		burstSize:       opts.BurstSize,
+		burstInterval:   opts.BurstInterval,
+		callTimeout:     opts.CallTimeout,
+		abortOnTimeout:  opts.AbortOnTimeout,
+		cancelRate:      opts.CancelRate,
+		cancelAfter:     opts.CancelAfter,
+		reconnect:       opts.Reconnect,
+		watchdog:        opts.Watchdog,
+		drainTimeout:    opts.DrainTimeout,
+		duplex:          opts.Duplex,
+		output:          "text",
+		result:          &res,
+	}
+	if cfg.mode == "" {
+		cfg.mode = "unary"
+	}
+	if cfg.streamType == "" {
+		cfg.streamType = "bidi"
+	}
+	if cfg.method == "" {
+		cfg.method = "echo"
+	}
+	if cfg.expectRespBytes == 0 {
+		cfg.expectRespBytes = -1
+	}
+	if cfg.cancelAfter == 0 {
+		cfg.cancelAfter = time.Millisecond
+	}
+	if cfg.dial == nil {
+		addr := cfg.addr
+		cfg.dial = func() (net.Conn, error) { return dial(addr, 10*time.Second) }
+	}
+	err := runClient(ctx, cfg)
+	return res, err
+}
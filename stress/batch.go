@@ -0,0 +1,91 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errBatchDeadline is the cause of a batch's context being done once its
+// shared deadline has passed, and the error of each call it cut off.
+var errBatchDeadline = errors.New("batch deadline exceeded")
+
+// batchStats aggregates the outcome of batches sent under a shared deadline.
+type batchStats struct {
+	completed atomic.Int64
+	failed    atomic.Int64
+	totalNs   atomic.Int64
+	maxNs     atomic.Int64
+}
+
+func (s *batchStats) record(elapsed time.Duration, ok bool) {
+	if !ok {
+		s.failed.Add(1)
+		return
+	}
+	s.completed.Add(1)
+	s.totalNs.Add(int64(elapsed))
+	for {
+		max := s.maxNs.Load()
+		if int64(elapsed) <= max || s.maxNs.CompareAndSwap(max, int64(elapsed)) {
+			return
+		}
+	}
+}
+
+func (s *batchStats) report(missedCalls int64) {
+	completed, failed := s.completed.Load(), s.failed.Load()
+	var mean time.Duration
+	if completed > 0 {
+		mean = time.Duration(s.totalNs.Load() / completed)
+	}
+	infof("batches: %d completed within deadline, %d failed; completed batch time mean %v, max %v",
+		completed, failed, mean, time.Duration(s.maxNs.Load()))
+	infof("calls cut off by a batch deadline, not counted as failed: %d", missedCalls)
+}
+
+// sendBatch sends values first..first+n-1 concurrently, all sharing a single
+// deadline. The batch fails as a whole if any call misses the deadline. Errors
+// other than the deadline (such as a response mismatch) are returned.
+func (r *clientRun) sendBatch(ctx context.Context, worker int, first uint32, n int, deadline time.Duration, stats *batchStats) error {
+	bctx, cancel := context.WithTimeoutCause(ctx, deadline, errBatchDeadline)
+	defer cancel()
+	start := time.Now()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		missed   bool
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(id uint32) {
+			defer wg.Done()
+			err := r.send(bctx, worker, id)
+			if err == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			// Calls still waiting to be sent at the deadline are cut off too.
+			if errors.Is(err, errBatchDeadline) || (isTimeout(err) && ctx.Err() == nil) {
+				missed = true
+				return
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}(first + uint32(i))
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if missed {
+		debugf("worker %d batch starting at %d missed its %v deadline", worker, first, deadline)
+	}
+	stats.record(time.Since(start), !missed)
+	return nil
+}
@@ -0,0 +1,191 @@
+package stress
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// benchConfig holds the client's -runs settings, which repeat a scenario to
+// measure its throughput, and compare it against a baseline.
+type benchConfig struct {
+	runs int
+	// baseline is a JSON result to compare the mean throughput against, and
+	// threshold the fraction below it that fails the run.
+	baseline  string
+	threshold float64
+}
+
+func benchFlags(fs *flag.FlagSet) *benchConfig {
+	b := &benchConfig{}
+	fs.IntVar(&b.runs, "runs", 1, "Repeat the whole run this many times, and report the mean and standard deviation of its elapsed time and throughput")
+	fs.StringVar(&b.baseline, "baseline", "", "Compare the mean throughput against that of this JSON result, of a single run or of -runs, as written with -output json (or -json FILE)")
+	fs.Float64Var(&b.threshold, "baseline-threshold", 0.05, fmt.Sprintf("With -baseline, exit with status %d if the mean throughput is more than this fraction below the baseline's", exitRegression))
+	return b
+}
+
+func (b *benchConfig) validate() error {
+	switch {
+	case b.runs < 1:
+		return usageErrorf("-runs must be at least 1, got %d", b.runs)
+	case b.threshold < 0 || b.threshold > 1:
+		return usageErrorf("-baseline-threshold must be between 0 and 1, got %v", b.threshold)
+	}
+	return nil
+}
+
+// enabled reports whether the run is to be benchmarked, rather than run once.
+func (b *benchConfig) enabled() bool {
+	return b.runs > 1 || b.baseline != ""
+}
+
+// BenchResult is the outcome of a client run repeated with -runs, written with
+// -output json or -json in place of a ClientResult, and accepted as a later
+// run's -baseline. Standard deviations are of the sample of runs.
+type BenchResult struct {
+	Runs                 []ClientResult `json:"runs"`
+	ElapsedMeanMs        float64        `json:"elapsed_mean_ms"`
+	ElapsedStddevMs      float64        `json:"elapsed_stddev_ms"`
+	RequestsPerSecMean   float64        `json:"requests_per_sec_mean"`
+	RequestsPerSecStddev float64        `json:"requests_per_sec_stddev"`
+	// BaselineRequestsPerSec is the mean throughput of the -baseline, and
+	// Change the fraction by which RequestsPerSecMean differs from it.
+	BaselineRequestsPerSec float64 `json:"baseline_requests_per_sec,omitempty"`
+	Change                 float64 `json:"change,omitempty"`
+	ExitCode               int     `json:"exit_code"`
+	ExitReason             string  `json:"exit_reason"`
+	Error                  string  `json:"error,omitempty"`
+}
+
+func (res BenchResult) write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+func (res BenchResult) writeFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := res.write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadBaseline returns the mean throughput of the result in path, which is
+// either a BenchResult or the ClientResult of a single run.
+func loadBaseline(path string) (float64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var res struct {
+		Runs               []json.RawMessage `json:"runs"`
+		RequestsPerSecMean float64           `json:"requests_per_sec_mean"`
+		RequestsPerSec     float64           `json:"requests_per_sec"`
+	}
+	if err := json.Unmarshal(b, &res); err != nil {
+		return 0, fmt.Errorf("baseline %s: %w", path, err)
+	}
+	rate := res.RequestsPerSec
+	if res.Runs != nil {
+		rate = res.RequestsPerSecMean
+	}
+	if rate <= 0 {
+		return 0, fmt.Errorf("baseline %s: no throughput recorded", path)
+	}
+	return rate, nil
+}
+
+// runBench runs the client scenario b.runs times in succession, with the same
+// seed, and reports the spread of elapsed time and throughput across runs, so
+// that a change in throughput between ttrpc versions can be told apart from
+// noise. Any run failing ends the benchmark.
+func runBench(ctx context.Context, cfg clientConfig, b benchConfig) error {
+	var baseline float64
+	if b.baseline != "" {
+		var err error
+		if baseline, err = loadBaseline(b.baseline); err != nil {
+			return err
+		}
+	}
+	output, jsonPath := cfg.output, cfg.jsonPath
+	cfg.output, cfg.jsonPath = "text", ""
+	cfg.seed = resolveSeed(cfg.seed)
+	var (
+		res BenchResult
+		err error
+	)
+	for i := 1; i <= b.runs && err == nil; i++ {
+		infof("=== run %d of %d ===", i, b.runs)
+		var run ClientResult
+		cfg.result = &run
+		if err = runClient(ctx, cfg); run.TTRPCVersion != "" {
+			res.Runs = append(res.Runs, run)
+		}
+	}
+	elapsed, rates := make([]float64, len(res.Runs)), make([]float64, len(res.Runs))
+	for i, run := range res.Runs {
+		elapsed[i], rates[i] = run.ElapsedMs, run.RequestsPerSec
+	}
+	res.ElapsedMeanMs, res.ElapsedStddevMs = meanStddev(elapsed)
+	res.RequestsPerSecMean, res.RequestsPerSecStddev = meanStddev(rates)
+	if err == nil {
+		infof("%d runs: elapsed %.3f ms ± %.3f, throughput %.0f ± %.0f req/s (%.1f%% variation)",
+			b.runs, res.ElapsedMeanMs, res.ElapsedStddevMs, res.RequestsPerSecMean, res.RequestsPerSecStddev, 100*res.RequestsPerSecStddev/res.RequestsPerSecMean)
+	}
+	if err == nil && baseline > 0 {
+		res.BaselineRequestsPerSec = baseline
+		res.Change = res.RequestsPerSecMean/baseline - 1
+		if res.Change < -b.threshold {
+			err = withExit(exitRegression, fmt.Errorf("throughput %.0f req/s is %.1f%% below the baseline's %.0f, beyond the %.1f%% threshold",
+				res.RequestsPerSecMean, -100*res.Change, baseline, 100*b.threshold))
+		} else {
+			infof("throughput is %+.1f%% against the baseline's %.0f req/s, within the %.1f%% threshold", 100*res.Change, baseline, 100*b.threshold)
+		}
+	}
+	res.ExitReason = exitReason(0)
+	if err != nil {
+		res.ExitCode = exitCode(err)
+		res.ExitReason = exitReason(res.ExitCode)
+		res.Error = err.Error()
+	}
+	var werr error
+	if output == "json" {
+		werr = res.write(os.Stdout)
+	}
+	if jsonPath != "" {
+		werr = errors.Join(werr, res.writeFile(jsonPath))
+	}
+	if werr != nil {
+		errorf("failed writing result: %s", werr)
+	}
+	return err
+}
+
+// meanStddev returns the mean and sample standard deviation of xs.
+func meanStddev(xs []float64) (mean, stddev float64) {
+	if len(xs) == 0 {
+		return 0, 0
+	}
+	for _, x := range xs {
+		mean += x
+	}
+	mean /= float64(len(xs))
+	if len(xs) < 2 {
+		return mean, 0
+	}
+	var ss float64
+	for _, x := range xs {
+		ss += (x - mean) * (x - mean)
+	}
+	return mean, math.Sqrt(ss / float64(len(xs)-1))
+}
@@ -0,0 +1,26 @@
+package stress
+
+import (
+	"context"
+	"flag"
+	"runtime"
+)
+
+// bothCommand runs the client against a server started in the same process, as
+// the client's -loopback does, on a private pipe: a unix socket in a new
+// temporary directory, or on Windows a uniquely named pipe. It takes the
+// client's options, with -loopback set and -loopback-transport defaulting to
+// the pipe, so that a run in CI needs no second process to orchestrate.
+func bothCommand(fs *flag.FlagSet) func(context.Context) error {
+	run := clientCommand(fs)
+	transport := "unix"
+	if runtime.GOOS == "windows" {
+		transport = "npipe"
+	}
+	for name, value := range map[string]string{"loopback": "true", "loopback-transport": transport} {
+		f := fs.Lookup(name)
+		f.Value.Set(value)
+		f.DefValue = value
+	}
+	return run
+}
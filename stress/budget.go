@@ -0,0 +1,75 @@
+package stress
+
+import (
+	"context"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// byteBudget limits the total number of payload bytes outstanding at once.
+// With large payloads this is a more meaningful backpressure control than a
+// count of in-flight calls, since a few large calls can fill buffers that many
+// small calls would not.
+//
+// A nil *byteBudget is valid and imposes no limit.
+type byteBudget struct {
+	sem   *semaphore.Weighted
+	limit int64
+}
+
+func newByteBudget(limit int64) *byteBudget {
+	return &byteBudget{sem: semaphore.NewWeighted(limit), limit: limit}
+}
+
+// clamp ensures a single call larger than the whole budget can still proceed
+// (alone) rather than blocking forever.
+func (b *byteBudget) clamp(n int64) int64 {
+	if n > b.limit {
+		return b.limit
+	}
+	return n
+}
+
+func (b *byteBudget) acquire(ctx context.Context, n int64) error {
+	if b == nil {
+		return nil
+	}
+	return b.sem.Acquire(ctx, b.clamp(n))
+}
+
+func (b *byteBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	b.sem.Release(b.clamp(n))
+}
+
+// callLimit limits the number of calls outstanding at once, independent of how
+// many goroutines are issuing them (which -goroutine-per-call and -batch-size
+// leave unbounded).
+//
+// A nil *callLimit is valid and imposes no limit.
+type callLimit chan struct{}
+
+func newCallLimit(n int) callLimit {
+	return make(callLimit, n)
+}
+
+func (l callLimit) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l callLimit) release() {
+	if l == nil {
+		return
+	}
+	<-l
+}
@@ -0,0 +1,110 @@
+package stress
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// burstSchedule releases every worker into each burst at the same moment, by
+// closing a channel they all wait on, and waits for them all to finish it before
+// idling until the next. Calls arriving at once from every worker, on top of
+// those a worker sends concurrently, are the likeliest way to fill a connection
+// in both directions at once.
+type burstSchedule struct {
+	// size is the number of calls each worker sends concurrently in a burst,
+	// and interval the idle time between one burst finishing and the next.
+	size     int
+	interval time.Duration
+	workers  int
+	iters    int
+	// gates holds a channel for each burst, closed to start it; done receives
+	// from each worker as it finishes a burst.
+	gates []chan struct{}
+	done  chan struct{}
+	// durations is how long each burst took, from its start to the last of
+	// its calls completing.
+	durations []time.Duration
+}
+
+func newBurstSchedule(iters, workers, size int, interval time.Duration) *burstSchedule {
+	n := (iters + workers*size - 1) / (workers * size)
+	s := &burstSchedule{
+		size:     size,
+		interval: interval,
+		workers:  workers,
+		iters:    iters,
+		gates:    make([]chan struct{}, n),
+		done:     make(chan struct{}, workers),
+	}
+	for i := range s.gates {
+		s.gates[i] = make(chan struct{})
+	}
+	return s
+}
+
+// worker runs worker w's part of every burst, until they are done or stop is
+// cancelled. Calls are sent with ctx, as in other dispatch modes.
+func (s *burstSchedule) worker(ctx, stop context.Context, r *clientRun, w int) error {
+	for b, gate := range s.gates {
+		select {
+		case <-gate:
+		case <-stop.Done():
+			return nil
+		}
+		var g errgroup.Group
+		for k := 0; k < s.size; k++ {
+			id := (b*s.workers+w)*s.size + k
+			if id >= s.iters {
+				break
+			}
+			g.Go(func() error { return r.send(ctx, w, uint32(id)) })
+		}
+		if err := g.Wait(); err != nil {
+			return err
+		}
+		s.done <- struct{}{}
+	}
+	return nil
+}
+
+// run starts each burst in turn, until they are done or ctx is cancelled.
+func (s *burstSchedule) run(ctx context.Context) {
+	for b, gate := range s.gates {
+		if b > 0 {
+			select {
+			case <-time.After(s.interval):
+			case <-ctx.Done():
+				return
+			}
+		}
+		start := time.Now()
+		close(gate)
+		for w := 0; w < s.workers; w++ {
+			select {
+			case <-s.done:
+			case <-ctx.Done():
+				return
+			}
+		}
+		s.durations = append(s.durations, time.Since(start))
+	}
+}
+
+// report logs how many bursts were sent, and how long they took.
+func (s *burstSchedule) report() {
+	if len(s.durations) == 0 {
+		return
+	}
+	sortDurations(s.durations)
+	var total time.Duration
+	for _, d := range s.durations {
+		total += d
+	}
+	infof("bursts: %d of up to %d calls, %v apart; burst time: mean %v, p50 %v, max %v",
+		len(s.durations), s.workers*s.size, s.interval,
+		(total / time.Duration(len(s.durations))).Round(time.Microsecond),
+		percentile(s.durations, 50).Round(time.Microsecond),
+		s.durations[len(s.durations)-1].Round(time.Microsecond))
+}
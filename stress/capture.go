@@ -0,0 +1,201 @@
+package stress
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// captureMagic begins every capture file.
+const captureMagic = "ttrpcstress capture v1\n"
+
+// Directions of the records in a capture, relative to the process that wrote
+// it.
+const (
+	captureSent     byte = '>'
+	captureReceived byte = '<'
+	captureClosed   byte = 'x'
+)
+
+// capture writes a copy of the bytes sent and received on connections to a
+// file, as a sequence of records each holding a timestamp, a direction, the
+// connection's name, and the bytes of one read or write. It shows exactly what
+// was written and read when a call hangs. Records are written as they happen,
+// without buffering, so that a process killed while hung leaves them all
+// behind.
+//
+// A nil *capture is valid and captures nothing.
+type capture struct {
+	mu sync.Mutex
+	f  *os.File
+	// err is the first error writing the file, after which nothing more is
+	// written.
+	err error
+}
+
+// newCapture creates a capture writing to the file at path. An empty path
+// returns a nil capture.
+func newCapture(path string) (*capture, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteString(captureMagic); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &capture{f: f}, nil
+}
+
+// record writes one record. A record is the time in Unix nanoseconds (8
+// bytes), the direction (1 byte), the length of the connection name (2 bytes)
+// and the name, then the length of the data (4 bytes) and the data, with all
+// integers big-endian.
+func (c *capture) record(name string, dir byte, data []byte) {
+	rec := make([]byte, 0, 15+len(name)+len(data))
+	rec = binary.BigEndian.AppendUint64(rec, uint64(time.Now().UnixNano()))
+	rec = append(rec, dir)
+	rec = binary.BigEndian.AppendUint16(rec, uint16(len(name)))
+	rec = append(rec, name...)
+	rec = binary.BigEndian.AppendUint32(rec, uint32(len(data)))
+	rec = append(rec, data...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if _, err := c.f.Write(rec); err != nil {
+		c.err = err
+		warnf("writing capture: %s", err)
+	}
+}
+
+func (c *capture) close() error {
+	if c == nil {
+		return nil
+	}
+	return c.f.Close()
+}
+
+// wrapConn returns conn, captured under name.
+func (c *capture) wrapConn(conn net.Conn, name string) net.Conn {
+	if c == nil {
+		return conn
+	}
+	return &captureConn{Conn: conn, c: c, name: name}
+}
+
+// captureConn wraps a net.Conn, capturing the data it reads and writes, and
+// when it is closed.
+type captureConn struct {
+	net.Conn
+	c      *capture
+	name   string
+	closed sync.Once
+}
+
+func (c *captureConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.c.record(c.name, captureReceived, b[:n])
+	}
+	return n, err
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.c.record(c.name, captureSent, b[:n])
+	}
+	return n, err
+}
+
+func (c *captureConn) Close() error {
+	c.closed.Do(func() { c.c.record(c.name, captureClosed, nil) })
+	return c.Conn.Close()
+}
+
+// wrapListener returns l, capturing each connection it accepts. Connections are
+// named as on the timeline.
+func (c *capture) wrapListener(l net.Listener) net.Listener {
+	if c == nil {
+		return l
+	}
+	return &captureListener{Listener: l, c: c}
+}
+
+type captureListener struct {
+	net.Listener
+	c *capture
+	n int
+}
+
+func (l *captureListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.n++
+	return l.c.wrapConn(conn, fmt.Sprintf("conn-%d", l.n)), nil
+}
+
+// captureRecord is one record read back from a capture.
+type captureRecord struct {
+	time time.Time
+	dir  byte
+	name string
+	data []byte
+}
+
+// captureReader reads the records of a capture file.
+type captureReader struct {
+	r *bufio.Reader
+}
+
+func newCaptureReader(r io.Reader) (*captureReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != captureMagic {
+		return nil, errors.New("not a ttrpcstress capture file")
+	}
+	return &captureReader{r: br}, nil
+}
+
+// next returns the next record, or io.EOF after the last. A record cut short,
+// as by the writer being killed, is reported as io.ErrUnexpectedEOF.
+func (cr *captureReader) next() (captureRecord, error) {
+	var hdr [11]byte
+	if _, err := io.ReadFull(cr.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return captureRecord{}, err
+		}
+		return captureRecord{}, io.EOF
+	}
+	rec := captureRecord{
+		time: time.Unix(0, int64(binary.BigEndian.Uint64(hdr[:8]))),
+		dir:  hdr[8],
+	}
+	name := make([]byte, binary.BigEndian.Uint16(hdr[9:11]))
+	var n [4]byte
+	if _, err := io.ReadFull(cr.r, name); err != nil {
+		return captureRecord{}, io.ErrUnexpectedEOF
+	}
+	if _, err := io.ReadFull(cr.r, n[:]); err != nil {
+		return captureRecord{}, io.ErrUnexpectedEOF
+	}
+	rec.name = string(name)
+	rec.data = make([]byte, binary.BigEndian.Uint32(n[:]))
+	if _, err := io.ReadFull(cr.r, rec.data); err != nil {
+		return captureRecord{}, io.ErrUnexpectedEOF
+	}
+	return rec, nil
+}
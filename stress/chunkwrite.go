@@ -0,0 +1,95 @@
+package stress
+
+import (
+	"flag"
+	"net"
+	"time"
+)
+
+// writeChunks holds the -write-chunk settings, shared by the client and
+// server, which split each write into chunks of at most size bytes, with a
+// pause between them. Frames then reach the peer torn at arbitrary points,
+// headers included, however ttrpc buffers its writes, which exercises the
+// peer's reassembly of frames from partial reads. Over a named pipe with no
+// buffer, each chunk is a separate transfer the peer must read before the next
+// can be written.
+//
+// The zero value splits nothing.
+type writeChunks struct {
+	size  int
+	pause time.Duration
+}
+
+// writeChunkFlags registers the -write-chunk flags.
+func writeChunkFlags(fs *flag.FlagSet) *writeChunks {
+	w := &writeChunks{}
+	fs.IntVar(&w.size, "write-chunk", 0, "Split each write to a connection into chunks of at most this many bytes, tearing frames across reads (0 to write whole)")
+	fs.DurationVar(&w.pause, "write-pause", 0, "Pause this long between the chunks of a write split by -write-chunk")
+	return w
+}
+
+func (w *writeChunks) validate() error {
+	switch {
+	case w.size < 0:
+		return usageErrorf("-write-chunk must not be negative, got %d", w.size)
+	case w.pause < 0:
+		return usageErrorf("-write-pause must not be negative, got %v", w.pause)
+	case w.pause > 0 && w.size == 0:
+		return usageErrorf("-write-pause requires -write-chunk")
+	}
+	return nil
+}
+
+// wrapConn returns c, splitting its writes.
+func (w writeChunks) wrapConn(c net.Conn) net.Conn {
+	if w.size <= 0 {
+		return c
+	}
+	return &chunkedConn{Conn: c, w: w}
+}
+
+// wrapListener returns l, splitting the writes of each connection it accepts.
+func (w writeChunks) wrapListener(l net.Listener) net.Listener {
+	if w.size <= 0 {
+		return l
+	}
+	return &chunkedListener{Listener: l, w: w}
+}
+
+type chunkedListener struct {
+	net.Listener
+	w writeChunks
+}
+
+func (l *chunkedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.w.wrapConn(c), nil
+}
+
+// chunkedConn wraps a net.Conn, writing each buffer in chunks. ttrpc serializes
+// its writes to a connection, so the chunks of one write are never interleaved
+// with those of another.
+type chunkedConn struct {
+	net.Conn
+	w writeChunks
+}
+
+func (c *chunkedConn) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		if written > 0 && c.w.pause > 0 {
+			time.Sleep(c.w.pause)
+		}
+		chunk := b[:min(len(b), c.w.size)]
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
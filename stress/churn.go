@@ -0,0 +1,202 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"golang.org/x/sync/errgroup"
+)
+
+func churnCommand(fs *flag.FlagSet) func(context.Context) error {
+	var (
+		cfg            churnConfig
+		loopback       bool
+		loopbackVia    string
+		connectTimeout time.Duration
+	)
+	fs.StringVar(&cfg.addr, "addr", "", "Address to connect to (tcp://HOST:PORT, unix://PATH, npipe://./pipe/NAME, hvsock://VMID:SERVICE, or vsock://CID:PORT; required unless -loopback)")
+	fs.DurationVar(&connectTimeout, "connect-timeout", 10*time.Second, "Give up on connecting after this long (0 to wait as long as the OS allows)")
+	fs.BoolVar(&loopback, "loopback", false, "Run against a server started in this process, rather than connecting to -addr")
+	fs.StringVar(&loopbackVia, "loopback-transport", "inproc", "Transport for -loopback: \"inproc\", \"tcp\", \"unix\", or on Windows \"npipe\", each on a private address")
+	fs.IntVar(&cfg.workers, "workers", 10, "Number of workers churning connections concurrently")
+	fs.IntVar(&cfg.cycles, "cycles", 100, "Number of connections each worker opens, one after another")
+	fs.IntVar(&cfg.burst, "burst", 10, "Number of calls sent concurrently on each connection before it is closed")
+	fs.Float64Var(&cfg.closeRate, "close-rate", 0.1, "Fraction of connections to close while their calls are still in flight")
+	fs.DurationVar(&cfg.closeAfter, "close-after", time.Millisecond, "Close each connection chosen by -close-rate after a random time up to this long")
+	fs.Uint64Var(&cfg.seed, "seed", 0, "Seed for -close-rate and -close-after, to repeat the choices an earlier run made about each connection (0 to pick one, which is logged)")
+	fs.DurationVar(&cfg.callTimeout, "call-timeout", 10*time.Second, "Count a call that neither completes nor fails within this long as hung")
+	leak := leakFlags(fs, "the run")
+	timelinePath := timelineFlag(fs)
+	return func(ctx context.Context) error {
+		switch {
+		case cfg.addr == "" && !loopback:
+			return usageErrorf("-addr or -loopback is required")
+		case cfg.addr != "" && loopback:
+			return usageErrorf("-addr cannot be combined with -loopback")
+		case loopbackVia != "inproc" && !loopback:
+			return usageErrorf("-loopback-transport requires -loopback")
+		case cfg.workers < 1:
+			return usageErrorf("-workers must be at least 1, got %d", cfg.workers)
+		case cfg.cycles < 0:
+			return usageErrorf("-cycles must not be negative, got %d", cfg.cycles)
+		case cfg.burst < 0:
+			return usageErrorf("-burst must not be negative, got %d", cfg.burst)
+		case cfg.closeRate < 0 || cfg.closeRate > 1:
+			return usageErrorf("-close-rate must be between 0 and 1, got %v", cfg.closeRate)
+		case cfg.closeAfter <= 0:
+			return usageErrorf("-close-after must be positive, got %v", cfg.closeAfter)
+		case cfg.callTimeout <= 0:
+			return usageErrorf("-call-timeout must be positive, got %v", cfg.callTimeout)
+		case connectTimeout < 0:
+			return usageErrorf("-connect-timeout must not be negative, got %v", connectTimeout)
+		}
+		if err := leak.validate(); err != nil {
+			return err
+		}
+		tl, err := newTimeline(*timelinePath)
+		if err != nil {
+			return err
+		}
+		defer tl.close()
+		cfg.tl = tl
+		checkLeaks := leak.begin("churn")
+		stopServer := func() error { return nil }
+		if loopback {
+			cfg.dial, stopServer, err = startLoopback(ctx, loopbackVia, protocolTTRPC, tl)
+			if err != nil {
+				return err
+			}
+		} else {
+			addr := cfg.addr
+			cfg.dial = func() (net.Conn, error) { return dial(addr, connectTimeout) }
+		}
+		err = runChurn(ctx, cfg)
+		if serverErr := stopServer(); err == nil {
+			err = serverErr
+		}
+		if leakErr := checkLeaks(); err == nil {
+			err = leakErr
+		}
+		return err
+	}
+}
+
+// churnConfig holds the settings for a connection churn run.
+type churnConfig struct {
+	addr    string
+	dial    func() (net.Conn, error)
+	workers int
+	// cycles is the number of connections each worker opens in turn, and burst
+	// the number of calls sent at once on each.
+	cycles int
+	burst  int
+	// closeRate is the fraction of connections closed after a random time of up
+	// to closeAfter, whether or not their calls have completed.
+	closeRate  float64
+	closeAfter time.Duration
+	// seed makes those choices for each connection.
+	seed uint64
+	// callTimeout is how long a call may take before it is counted as hung.
+	callTimeout time.Duration
+	tl          *timeline
+}
+
+// churnStats counts the outcomes of a churn run.
+type churnStats struct {
+	conns atomic.Int64
+	// succeeded, closed, and hung count calls: those that got their response,
+	// those failed by their connection closing early, and those that did
+	// neither within the call timeout.
+	succeeded atomic.Int64
+	closed    atomic.Int64
+	hung      atomic.Int64
+}
+
+// runChurn has each of cfg.workers repeatedly open a connection, send a burst of
+// calls on it, and close it, with some connections closed while their calls are
+// still in flight. Every call must either get its response or fail promptly
+// with ttrpc.ErrClosed; one that hangs instead points at a setup or teardown
+// race in the client or server.
+func runChurn(ctx context.Context, cfg churnConfig) error {
+	var stats churnStats
+	cfg.seed = resolveSeed(cfg.seed)
+	infof("churn: %d workers, %d cycles, seed %d", cfg.workers, cfg.cycles, cfg.seed)
+	cfg.tl.record("churn", "start", "%d workers, %d cycles", cfg.workers, cfg.cycles)
+	start := time.Now()
+	eg, egCtx := errgroup.WithContext(ctx)
+	for w := 0; w < cfg.workers; w++ {
+		w := w
+		eg.Go(func() error {
+			for c := 0; c < cfg.cycles; c++ {
+				if err := churnCycle(egCtx, cfg, w, c, &stats); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	err := eg.Wait()
+	infof("churn: %d connections in %v; calls: %d succeeded, %d failed by an early close, %d hung (>%v)",
+		stats.conns.Load(), time.Since(start).Round(time.Millisecond),
+		stats.succeeded.Load(), stats.closed.Load(), stats.hung.Load(), cfg.callTimeout)
+	if n := stats.hung.Load(); err == nil && n > 0 {
+		err = withExit(exitStalled, fmt.Errorf("%d calls hung for more than %v", n, cfg.callTimeout))
+	}
+	return err
+}
+
+// churnCycle runs cycle c of worker w: one connection and its burst of calls.
+func churnCycle(ctx context.Context, cfg churnConfig, w, c int, stats *churnStats) error {
+	name := fmt.Sprintf("churn-%d-%d", w, c)
+	cfg.tl.record(name, "dial", "%s", cfg.addr)
+	conn, err := cfg.dial()
+	if err != nil {
+		cfg.tl.record(name, "error", "dial: %s", err)
+		return withExit(exitTransport, fmt.Errorf("worker %d cycle %d: %w", w, c, err))
+	}
+	cfg.tl.record(name, "connected", "%s", conn.RemoteAddr())
+	client := ttrpc.NewClient(cfg.tl.wrapConn(conn, name))
+	defer client.Close()
+	stats.conns.Add(1)
+	cycle := uint32(w*cfg.cycles + c)
+	early := randomChance(cfg.seed, drawChurnClose, cycle, cfg.closeRate)
+	if early {
+		t := time.AfterFunc(randomDuration(cfg.seed, drawChurnCloseAfter, cycle, cfg.closeAfter), func() {
+			cfg.tl.record(name, "close", "with calls in flight")
+			client.Close()
+		})
+		defer t.Stop()
+	}
+	var g errgroup.Group
+	for i := 0; i < cfg.burst; i++ {
+		id := uint32((w*cfg.cycles+c)*cfg.burst + i)
+		g.Go(func() error {
+			callCtx, cancel := context.WithTimeout(ctx, cfg.callTimeout)
+			defer cancel()
+			resp := &payload{}
+			err := client.Call(callCtx, serviceName, methodEcho, &payload{Value: id}, resp)
+			switch {
+			case err == nil && resp.Value != id:
+				return withExit(exitMismatch, fmt.Errorf("worker %d cycle %d: expected return value %d but got %d", w, c, id, resp.Value))
+			case err == nil:
+				stats.succeeded.Add(1)
+			case early && errors.Is(err, ttrpc.ErrClosed):
+				stats.closed.Add(1)
+			case isTimeout(err) && ctx.Err() == nil:
+				cfg.tl.record(name, "error", "request %d hung", id)
+				errorf("worker %d cycle %d request %d: no response or error within %v", w, c, id, cfg.callTimeout)
+				stats.hung.Add(1)
+			default:
+				return withExit(callExit(err), fmt.Errorf("worker %d cycle %d request %d: %w", w, c, id, err))
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
@@ -0,0 +1,1559 @@
+package stress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func clientCommand(fs *flag.FlagSet) func(context.Context) error {
+	var (
+		cfg            clientConfig
+		tlsCA          string
+		tlsSkipVerify  bool
+		tlsServerName  string
+		loopback       bool
+		loopbackVia    string
+		connectTimeout time.Duration
+	)
+	fs.StringVar(&cfg.addr, "addr", "", "Address to connect to (tcp://HOST:PORT, unix://PATH, npipe://./pipe/NAME, hvsock://VMID:SERVICE, or vsock://CID:PORT; required unless -loopback)")
+	fs.DurationVar(&connectTimeout, "connect-timeout", 10*time.Second, "Give up on connecting after this long, as to an unreachable remote host (0 to wait as long as the OS allows)")
+	fs.StringVar(&cfg.protocol, "protocol", protocolTTRPC, "Protocol to call the server with: \"ttrpc\", or \"grpc\" to run the same unary calls over gRPC, against a server run with the same -protocol, for comparison")
+	fs.BoolVar(&loopback, "loopback", false, "Run against a server started in this process, rather than connecting to -addr")
+	fs.StringVar(&loopbackVia, "loopback-transport", "inproc", "Transport for -loopback: \"inproc\", \"tcp\", \"unix\", or on Windows \"npipe\", each on a private address")
+	fs.IntVar(&cfg.iters, "iters", 1000, "Number of requests to send")
+	fs.DurationVar(&cfg.duration, "duration", 0, "Have every worker send continuously for this long (-iters is ignored)")
+	fs.IntVar(&cfg.warmup, "warmup", 0, "Number of requests to send before the run, which are verified but excluded from timing and stats")
+	fs.IntVar(&cfg.workers, "workers", 10, "Number of concurrent workers sending requests")
+	fs.StringVar(&cfg.control, "control", "", "Listen on this address (e.g. unix://PATH or npipe://./pipe/NAME) for \"ttrpcstress scale\" to change the number of active workers during the run")
+	fs.StringVar(&cfg.duplex, "duplex", "", "Also serve on this address (e.g. unix://PATH), and have the server run the same workload back to it while the run's calls are under way, so requests flow both ways at once; the server must be able to reach the address")
+	fs.IntVar(&cfg.maxWorkers, "max-workers", 0, "Most workers -control can make active, all started up front with -workers of them active (0 for -workers)")
+	fs.DurationVar(&cfg.rampup, "rampup", 0, "Bring workers online gradually over this long, rather than all at once")
+	fs.DurationVar(&cfg.rampup, "ramp", 0, "The same as -rampup")
+	fs.DurationVar(&cfg.rampdown, "rampdown", 0, "Retire workers gradually over the last this long of a -duration run, rather than all at once")
+	fs.IntVar(&cfg.conns, "conns", 1, "Number of connections to open, with workers assigned to them round-robin")
+	fs.IntVar(&cfg.conns, "connections", 1, "The same as -conns")
+	fs.StringVar(&cfg.method, "method", "echo", "Method to call: \"echo\", \"ping\" (empty response), \"large\" (1 MiB response), or \"mixed\" to rotate through all three")
+	fs.StringVar(&cfg.mix, "mix", "", "Spread calls across methods by weight, as NAME=WEIGHT pairs (e.g. echo=80,slow=15,error=5), where NAME is echo, ping, large, slow (held for the server's -slow-delay), or error (always fails with an injected error)")
+	fs.StringVar(&cfg.mode, "mode", "unary", "Call type: \"unary\" sends -iters calls, \"stream\" sends -iters messages on each of -workers concurrent streams")
+	fs.StringVar(&cfg.streamType, "stream-type", "bidi", "Stream type for -mode stream: \"bidi\" has each message echoed, \"server\" has the server send the messages, \"client\" has the client send them and the server confirm them at the end")
+	fs.Float64Var(&cfg.rate, "rate", 0, "Limit dispatch across all workers to this many requests per second (0 for as fast as possible); with -goroutine-per-call, dispatch at this rate whether or not responses keep up")
+	fs.Float64Var(&cfg.readRate, "read-rate", 0, "Throttle reading from each connection to this many bytes per second, so the server's writes back up as they would to a client not keeping up with responses (0 for no limit)")
+	fs.IntVar(&cfg.maxInflight, "max-inflight", 0, "Limit the number of calls outstanding at once, independent of -workers (0 for no limit)")
+	fs.Int64Var(&cfg.maxInflightBytes, "max-inflight-bytes", 0, "Limit total request+response bytes outstanding at once (0 for no limit)")
+	fs.BoolVar(&cfg.randomValues, "random-values", false, "Send pseudo-random request values, rather than each request's sequence number")
+	fs.Uint64Var(&cfg.seed, "seed", 0, "Seed for -random-values, -payload-random, -cancel-rate, -oneway-rate, and -trace-rate, to repeat the requests of an earlier run and the choices made about each (0 to pick one, which is logged)")
+	fs.BoolVar(&cfg.duplicateValues, "duplicate-values", false, "Have every worker send the full 0..iters range, rather than partitioning it")
+	fs.StringVar(&tlsCA, "tls-ca", "", "Connect with TLS, verifying the server against the PEM CA certificates in this file")
+	fs.BoolVar(&tlsSkipVerify, "tls-skip-verify", false, "Connect with TLS, without verifying the server's certificate")
+	fs.StringVar(&tlsServerName, "tls-server-name", "", "Name to verify the server's certificate against (default the host of a tcp:// address; required to verify over other transports)")
+	fs.BoolVar(&cfg.reconnect, "reconnect", false, "Re-dial a connection that drops and retry its calls, rather than failing the run")
+	fs.BoolVar(&cfg.expectShutdown, "expect-shutdown", false, "Expect the server to shut down during the run, as with its -shutdown-within: the run ends cleanly once calls fail with the connection closed (pair with -call-timeout to catch calls that hang instead)")
+	fs.BoolVar(&cfg.detectDuplicates, "detect-duplicates", false, "Watch for more than one response arriving for the same request")
+	fs.DurationVar(&cfg.keepalive, "keepalive", 0, "Send a PING call at this interval to keep the connection active (0 to disable)")
+	fs.DurationVar(&cfg.callTimeout, "call-timeout", 0, "Give up on a call that takes longer than this, counting it as timed out and carrying on (0 to wait forever)")
+	fs.BoolVar(&cfg.abortOnTimeout, "abort-on-timeout", false, "End the run at the first call to exceed -call-timeout, as at the first error, rather than carrying on to count how many do")
+	fs.Float64Var(&cfg.cancelRate, "cancel-rate", 0, "Fraction of unary calls to cancel while in flight, counting them as cancelled and carrying on")
+	fs.Float64Var(&cfg.onewayRate, "oneway-rate", 0, "Fraction of unary calls to send instead as messages with no response, on a client stream each worker keeps open alongside its calls; the server confirms their count once the worker finishes")
+	fs.DurationVar(&cfg.cancelAfter, "cancel-after", time.Millisecond, "Cancel each call chosen by -cancel-rate after a random time up to this long")
+	fs.DurationVar(&cfg.drainTimeout, "drain-timeout", 5*time.Second, "On SIGINT or SIGTERM, stop sending and wait this long for calls in flight to complete before summarizing the run; a second interrupt exits at once")
+	fs.DurationVar(&cfg.watchdog, "watchdog", 0, "Report a stall if no call completes within this long (0 to disable)")
+	fs.BoolVar(&cfg.watchdogExit, "watchdog-exit", false, fmt.Sprintf("Exit with status %d once the watchdog has reported a stall, rather than waiting on the stuck calls", exitStalled))
+	fs.IntVar(&cfg.batchSize, "batch-size", 0, "Group requests into batches of this size, sent concurrently under a shared deadline")
+	fs.DurationVar(&cfg.batchDeadline, "batch-deadline", time.Second, "Deadline for each batch to complete when -batch-size is set")
+	fs.IntVar(&cfg.burstSize, "burst-size", 0, "Send calls in synchronized bursts: every worker sends this many at once, all starting together, then idles for -burst-interval")
+	fs.DurationVar(&cfg.burstInterval, "burst-interval", 100*time.Millisecond, "Idle time between the end of one -burst-size burst and the start of the next")
+	fs.BoolVar(&cfg.goroutinePerCall, "goroutine-per-call", false, "Start a goroutine for every call rather than using a fixed pool of workers")
+	fs.DurationVar(&cfg.steadyWindow, "steady-window", 0, "Send continuously and measure only calls within a window of this length, after -steady-warmup (-iters is ignored)")
+	fs.DurationVar(&cfg.steadyWarmup, "steady-warmup", 5*time.Second, "Warm-up time before the -steady-window measurement begins")
+	fs.DurationVar(&cfg.progressEvery, "progress", 0, "Report the number of completed requests, the rate, and the time left, at this interval (0 to disable)")
+	fs.BoolVar(&cfg.progressBar, "progress-bar", true, fmt.Sprintf("When stderr is a terminal, show progress on a single line redrawn in place, every -progress or %v, rather than logging a line each interval", defaultProgressBarInterval))
+	fs.StringVar(&cfg.csv, "record", "", "Write a row for every call to this CSV file: its worker, request, send and receive times, latency, and outcome")
+	fs.StringVar(&cfg.csv, "csv", "", "The same as -record")
+	fs.StringVar(&cfg.timeseries, "timeseries", "", "Write periodic CSV snapshots of run progress to this file")
+	fs.DurationVar(&cfg.timeseriesEvery, "timeseries-interval", 100*time.Millisecond, "Interval between -timeseries rows")
+	fs.IntVar(&cfg.payloadBytes, "payload-bytes", 0, "Bytes of filler data to add to each request")
+	fs.IntVar(&cfg.payloadBytes, "payload-size", 0, "The same as -payload-bytes")
+	fs.IntVar(&cfg.metadataKeys, "metadata-keys", 0, "Attach this many metadata entries to each unary call, which the server verifies")
+	fs.IntVar(&cfg.metadataBytes, "metadata-bytes", 16, "Size of each -metadata-keys value in bytes")
+	fs.Float64Var(&cfg.traceRate, "trace-rate", 1, "Fraction of unary calls to trace with -otlp-endpoint")
+	fs.BoolVar(&cfg.payloadRandom, "payload-random", false, "Fill requests with pseudo-random data, of a pseudo-random length up to -payload-bytes")
+	fs.IntVar(&cfg.expectRespBytes, "expect-response-bytes", -1, "Bytes of data expected in each response (-1 to expect the request's data echoed)")
+	leak := leakFlags(fs, "the run")
+	bench := benchFlags(fs)
+	writes := writeChunkFlags(fs)
+	fs.StringVar(&cfg.output, "output", "text", "Result format: \"text\" logs a summary, \"json\" writes a JSON result to stdout")
+	fs.StringVar(&cfg.jsonPath, "json", "", "Also write the JSON result to this file, whatever the -output format")
+	timelinePath := timelineFlag(fs)
+	capturePath := captureFlag(fs)
+	pprofAddr := pprofFlag(fs)
+	metricsAddr := metricsFlag(fs)
+	otlpEndpoint := otlpFlag(fs)
+	return func(ctx context.Context) error {
+		switch {
+		case cfg.addr == "" && !loopback:
+			return usageErrorf("-addr or -loopback is required")
+		case cfg.addr != "" && loopback:
+			return usageErrorf("-addr cannot be combined with -loopback")
+		case loopback && (tlsCA != "" || tlsSkipVerify || tlsServerName != ""):
+			return usageErrorf("TLS is not supported with -loopback")
+		case loopbackVia != "inproc" && !loopback:
+			return usageErrorf("-loopback-transport requires -loopback")
+		case cfg.iters < 0:
+			return usageErrorf("-iters must not be negative, got %d", cfg.iters)
+		case cfg.duration < 0:
+			return usageErrorf("-duration must not be negative, got %v", cfg.duration)
+		case cfg.warmup < 0:
+			return usageErrorf("-warmup must not be negative, got %d", cfg.warmup)
+		case cfg.workers < 1:
+			return usageErrorf("-workers must be at least 1, got %d", cfg.workers)
+		case connectTimeout < 0:
+			return usageErrorf("-connect-timeout must not be negative, got %v", connectTimeout)
+		case cfg.conns < 1:
+			return usageErrorf("-conns must be at least 1, got %d", cfg.conns)
+		case cfg.payloadBytes < 0:
+			return usageErrorf("-payload-bytes must not be negative, got %d", cfg.payloadBytes)
+		case cfg.metadataKeys < 0:
+			return usageErrorf("-metadata-keys must not be negative, got %d", cfg.metadataKeys)
+		case cfg.metadataBytes < 0:
+			return usageErrorf("-metadata-bytes must not be negative, got %d", cfg.metadataBytes)
+		case cfg.traceRate < 0 || cfg.traceRate > 1:
+			return usageErrorf("-trace-rate must be between 0 and 1, got %v", cfg.traceRate)
+		case cfg.batchSize < 0:
+			return usageErrorf("-batch-size must not be negative, got %d", cfg.batchSize)
+		case cfg.watchdogExit && cfg.watchdog == 0:
+			return usageErrorf("-watchdog-exit requires -watchdog")
+		case cfg.rampup < 0:
+			return usageErrorf("-rampup must not be negative, got %v", cfg.rampup)
+		case cfg.rampdown < 0:
+			return usageErrorf("-rampdown must not be negative, got %v", cfg.rampdown)
+		case cfg.rate < 0:
+			return usageErrorf("-rate must not be negative, got %v", cfg.rate)
+		case cfg.readRate < 0:
+			return usageErrorf("-read-rate must not be negative, got %v", cfg.readRate)
+		case cfg.maxInflight < 0:
+			return usageErrorf("-max-inflight must not be negative, got %d", cfg.maxInflight)
+		case cfg.progressEvery < 0:
+			return usageErrorf("-progress must not be negative, got %v", cfg.progressEvery)
+		case cfg.drainTimeout < 0:
+			return usageErrorf("-drain-timeout must not be negative, got %v", cfg.drainTimeout)
+		case cfg.callTimeout < 0:
+			return usageErrorf("-call-timeout must not be negative, got %v", cfg.callTimeout)
+		case cfg.abortOnTimeout && cfg.callTimeout == 0:
+			return usageErrorf("-abort-on-timeout requires -call-timeout")
+		case cfg.cancelRate < 0 || cfg.cancelRate > 1:
+			return usageErrorf("-cancel-rate must be between 0 and 1, got %v", cfg.cancelRate)
+		case cfg.burstSize < 0:
+			return usageErrorf("-burst-size must not be negative, got %d", cfg.burstSize)
+		case cfg.burstInterval < 0:
+			return usageErrorf("-burst-interval must not be negative, got %v", cfg.burstInterval)
+		case cfg.onewayRate < 0 || cfg.onewayRate > 1:
+			return usageErrorf("-oneway-rate must be between 0 and 1, got %v", cfg.onewayRate)
+		case cfg.onewayRate > 0 && !streamingSupported:
+			return usageErrorf("-oneway-rate requires ttrpc v1.2.0 or later, built with -tags protogo")
+		case cfg.cancelAfter <= 0:
+			return usageErrorf("-cancel-after must be positive, got %v", cfg.cancelAfter)
+		case cfg.mode != "unary" && cfg.mode != "stream":
+			return usageErrorf("-mode must be \"unary\" or \"stream\", got %q", cfg.mode)
+		case cfg.mode == "stream" && !streamingSupported:
+			return usageErrorf("-mode stream requires ttrpc v1.2.0 or later, built with -tags protogo")
+		case cfg.streamType != "bidi" && cfg.streamType != "server" && cfg.streamType != "client":
+			return usageErrorf("-stream-type must be \"bidi\", \"server\", or \"client\", got %q", cfg.streamType)
+		case cfg.streamType != "bidi" && cfg.mode != "stream":
+			return usageErrorf("-stream-type requires -mode stream")
+		case cfg.method != "echo" && cfg.method != "ping" && cfg.method != "large" && cfg.method != "mixed":
+			return usageErrorf("-method must be \"echo\", \"ping\", \"large\", or \"mixed\", got %q", cfg.method)
+		case cfg.mix != "" && cfg.method != "echo":
+			return usageErrorf("-mix cannot be combined with -method")
+		case cfg.output != "text" && cfg.output != "json":
+			return usageErrorf("-output must be \"text\" or \"json\", got %q", cfg.output)
+		case cfg.output == "json" && cfg.steadyWindow > 0:
+			return usageErrorf("-output json cannot be combined with -steady-window")
+		}
+		if err := validateProtocol(cfg.protocol); err != nil {
+			return err
+		}
+		if err := leak.validate(); err != nil {
+			return err
+		}
+		if err := writes.validate(); err != nil {
+			return err
+		}
+		cfg.writeChunks = *writes
+		if err := bench.validate(); err != nil {
+			return err
+		}
+		if bench.enabled() && cfg.steadyWindow > 0 {
+			return usageErrorf("-runs and -baseline cannot be combined with -steady-window")
+		}
+		if bench.enabled() && cfg.control != "" {
+			return usageErrorf("-runs and -baseline cannot be combined with -control")
+		}
+		if bench.enabled() && cfg.duplex != "" {
+			return usageErrorf("-runs and -baseline cannot be combined with -duplex")
+		}
+		if err := startPprof(*pprofAddr); err != nil {
+			return err
+		}
+		cfg.metricsAddr = *metricsAddr
+		cfg.otlpEndpoint = *otlpEndpoint
+		tl, err := newTimeline(*timelinePath)
+		if err != nil {
+			return err
+		}
+		defer tl.close()
+		cfg.tl = tl
+		if cfg.capture, err = newCapture(*capturePath); err != nil {
+			return err
+		}
+		defer cfg.capture.close()
+		checkLeaks := leak.begin("client")
+		stopServer := func() error { return nil }
+		if loopback {
+			cfg.dial, stopServer, err = startLoopback(ctx, loopbackVia, cfg.protocol, tl)
+			if err != nil {
+				return err
+			}
+			cfg.addr = loopbackVia
+		}
+		// An interrupt ends the run, listing the calls still outstanding. Once
+		// it has, a second interrupt exits at once, in case the run then hangs.
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		context.AfterFunc(ctx, stop)
+		addr := cfg.addr
+		switch {
+		case loopback:
+		case tlsCA != "" || tlsSkipVerify || tlsServerName != "":
+			tlsConfig, err := clientTLSConfig(addr, tlsCA, tlsServerName, tlsSkipVerify)
+			if err != nil {
+				return err
+			}
+			cfg.dial = func() (net.Conn, error) { return dialTLS(addr, tlsConfig, connectTimeout) }
+		default:
+			cfg.dial = func() (net.Conn, error) { return dial(addr, connectTimeout) }
+		}
+		start := time.Now()
+		if bench.enabled() {
+			err = runBench(ctx, cfg, *bench)
+		} else {
+			err = runClient(ctx, cfg)
+		}
+		elapsed := time.Since(start)
+		if serverErr := stopServer(); err == nil {
+			err = serverErr
+		}
+		if leakErr := checkLeaks(); err == nil {
+			err = leakErr
+		}
+		if err != nil {
+			return err
+		}
+		// Steady-state runs report only their measurement window, JSON
+		// results include the elapsed time, and benchmarks its spread.
+		if cfg.steadyWindow == 0 && cfg.output == "text" && !bench.enabled() {
+			infof("elapsed time: %v", elapsed)
+		}
+		return nil
+	}
+}
+
+// clientConfig holds the settings for a client run.
+type clientConfig struct {
+	addr string
+	dial func() (net.Conn, error)
+	// protocol is protocolTTRPC, or protocolGRPC to make the unary calls over
+	// gRPC instead. Empty means protocolTTRPC.
+	protocol string
+	iters    int
+	workers  int
+	// control is the address of a socket on which the number of active
+	// workers can be changed during the run, up to maxWorkers.
+	control    string
+	maxWorkers int
+	// duplex is an address the client also serves on, for the server to run
+	// the same workload back to during the run; see startDuplex.
+	duplex string
+	// conns is the number of connections to spread workers across. Zero is
+	// treated as one.
+	conns int
+	// duration, when non-zero, has workers send continuously until it has
+	// elapsed, in place of iters. Calls in flight at the deadline are allowed to
+	// complete.
+	duration time.Duration
+	// warmup is the number of requests sent, across all workers, before the run
+	// is timed. They are verified, but not counted in the results.
+	warmup int
+	// method is the client-facing name of the method to call ("echo", "ping",
+	// or "large"), or "mixed" to rotate through them by request value. Empty
+	// means "echo".
+	method string
+	// mix, if set, is a weighted call mix in the form parsed by parseMix, which
+	// takes the place of method.
+	mix string
+	// mode is "unary" for individual calls, or "stream" to send iters messages
+	// on each of workers concurrent streams.
+	mode string
+	// streamType is the kind of stream used in stream mode: "bidi", "server",
+	// or "client", for which messages flow in both directions, from the server
+	// only, or from the client only. Empty means "bidi".
+	streamType string
+	// rate, when non-zero, paces dispatch to this many requests per second in
+	// total, so latency can be measured at a fixed load.
+	rate float64
+	// readRate, when non-zero, limits reading from each connection to this
+	// many bytes per second.
+	readRate float64
+	// writeChunks splits each write to a connection into chunks.
+	writeChunks writeChunks
+	// rampup spreads the start of the workers evenly over this long, so that
+	// load builds gradually rather than arriving all at once.
+	rampup time.Duration
+	// rampdown likewise spreads the retirement of the workers over the end of a
+	// fixed-duration run, the last worker online being the first to go.
+	rampdown time.Duration
+	// maxInflight bounds the number of outstanding calls. Zero means no limit.
+	maxInflight int
+	// maxInflightBytes bounds the total size of outstanding request+response
+	// payloads. Zero means no limit.
+	maxInflightBytes int64
+	// randomValues sends a pseudo-random value derived from seed and the request
+	// number, rather than the request number itself, so that a response matched
+	// to a neighbouring request is not mistaken for the right one.
+	randomValues bool
+	seed         uint64
+	// burstSize, when non-zero, has every worker send this many calls at once,
+	// all workers together, idling for burstInterval between bursts.
+	burstSize     int
+	burstInterval time.Duration
+	// duplicateValues has each worker send the full 0..iters range independently,
+	// so the server sees many concurrent calls carrying identical payloads.
+	duplicateValues bool
+	// reconnect re-dials a connection that drops, and retries the calls that
+	// failed with it.
+	reconnect bool
+	// expectShutdown expects the server to shut down during the run. Calls that
+	// fail with the connection closed are counted, and end the run once those
+	// in flight have finished, rather than failing it.
+	expectShutdown bool
+	// detectDuplicates watches the connection for repeated responses to the same request.
+	detectDuplicates bool
+	// keepalive is the interval at which PING calls are sent. Zero disables them.
+	keepalive time.Duration
+	// callTimeout bounds each call. A call that exceeds it is counted as timed
+	// out rather than failing the run. Zero means calls may wait forever.
+	callTimeout time.Duration
+	// abortOnTimeout ends the run at the first call to exceed callTimeout.
+	abortOnTimeout bool
+	// drainTimeout is how long calls in flight are given to complete once the
+	// run is interrupted. Zero cancels them at once.
+	drainTimeout time.Duration
+	// cancelRate is the fraction of unary calls that are cancelled after a
+	// random time up to cancelAfter, which races the cancellation against the
+	// response. Calls cancelled before their response arrives are counted rather
+	// than failing the run.
+	cancelRate  float64
+	cancelAfter time.Duration
+	// watchdog reports a stall when no call completes within this long. Zero disables it.
+	watchdog time.Duration
+	// watchdogExit exits the process once a stall has been reported.
+	watchdogExit bool
+	// batchSize, when non-zero, groups requests into batches that are sent
+	// concurrently and must all complete within batchDeadline.
+	batchSize     int
+	batchDeadline time.Duration
+	// goroutinePerCall starts a goroutine for every call rather than dispatching
+	// to a fixed pool of workers, so concurrency is bounded only by iters.
+	goroutinePerCall bool
+	// steadyWindow, when non-zero, has workers send continuously and reports
+	// only calls within a window of this length, starting after steadyWarmup.
+	steadyWindow time.Duration
+	steadyWarmup time.Duration
+	// progressEvery is the interval at which progress is logged. Zero disables it.
+	progressEvery time.Duration
+	// progressBar draws progress on a single line of a terminal instead; see
+	// logProgress.
+	progressBar bool
+	// csv is a file to write a record of every call to.
+	csv string
+	// timeseries is a file to write periodic snapshots of run progress to,
+	// every timeseriesEvery.
+	timeseries      string
+	timeseriesEvery time.Duration
+	// metricsAddr is the address to serve Prometheus metrics on, if any.
+	metricsAddr string
+	// otlpEndpoint is the collector to export traces of traceRate of the
+	// unary calls to, if any.
+	otlpEndpoint string
+	traceRate    float64
+	// payloadBytes is the size of the filler data sent with each request.
+	payloadBytes int
+	// payloadRandom sends pseudo-random data of pseudo-random length up to
+	// payloadBytes instead, so that framing sees messages of every size.
+	payloadRandom bool
+	// onewayRate is the fraction of unary calls sent instead as messages with
+	// no response; see clientRun.sendOneway.
+	onewayRate float64
+	// metadataKeys is the number of metadata entries attached to each unary
+	// call, each of metadataBytes; see callMetadata.
+	metadataKeys  int
+	metadataBytes int
+	// expectRespBytes is the size of the data expected in each response, which
+	// must match the server's configuration. If negative, the request's data is
+	// expected to be echoed back.
+	expectRespBytes int
+	// output is "text" to log a summary of the run, or "json" to write a
+	// ClientResult to stdout.
+	output string
+	// jsonPath is a file to write the ClientResult to, in addition to output.
+	jsonPath string
+	// result, if set, receives the ClientResult of a run that gets under way.
+	result *ClientResult
+	tl     *timeline
+	// capture, if set, records the bytes sent and received on each connection.
+	capture *capture
+}
+
+// clientRun holds the state shared by all workers during a client run.
+type clientRun struct {
+	conns    []*connSlot
+	limit    callLimit
+	budget   *byteBudget
+	inflight *inflightTracker
+	window   *measureWindow
+	samples  *sampleWriter
+	progress progress
+	reqData  []byte
+	reqSum   uint32
+	latency  latencyRecorder
+	// randomValues and seed select the value sent with each request; see value.
+	// payloadRandom and seed likewise select its data; see requestData. seed
+	// also decides which calls are cancelled, sent oneway, or traced.
+	randomValues  bool
+	payloadRandom bool
+	seed          uint64
+	// mdKeys and mdBytes shape the metadata sent with each call, if mdKeys is
+	// positive.
+	mdKeys  int
+	mdBytes int
+	// onewayRate is the fraction of calls sent as oneway messages, on the
+	// stream in oneway for each worker, which only that worker uses.
+	// onewaySent counts them, and onewayConfirmed those the server has
+	// confirmed receiving.
+	onewayRate      float64
+	oneway          []*onewayStream
+	onewaySent      atomic.Int64
+	onewayConfirmed atomic.Int64
+	// tracer, if set, traces traceRate of the calls.
+	tracer    *tracer
+	traceRate float64
+	// echo is set if responses are expected to echo the request data.
+	echo bool
+	// methods are the methods that requests are spread across by value.
+	// respBytes is the expected size of echoed response data, and respData its
+	// expected content; largeData is the expected content of methodLarge responses.
+	methods     []string
+	respBytes   int
+	respData    []byte
+	largeData   []byte
+	callTimeout time.Duration
+	// abortOnTimeout fails a call that exceeds callTimeout, ending the run.
+	abortOnTimeout bool
+	cancelRate     float64
+	cancelAfter    time.Duration
+	streamType     string
+	// connect dials a new connection for the named slot, for reconnect.
+	connect    func(name string) (*ttrpc.Client, error)
+	reconnect  bool
+	reconnects atomic.Int64
+	// lost counts calls failed by their connection dropping, which were retried
+	// on its replacement, and recovery the time taken to replace each.
+	lost      atomic.Int64
+	recovery  recoveryStats
+	detectMu  sync.Mutex
+	detectors []*duplicateDetector
+	// completed, failed, timedOut, injected, and cancelled count finished calls;
+	// active is the number of calls currently outstanding.
+	completed atomic.Int64
+	failed    atomic.Int64
+	timedOut  atomic.Int64
+	injected  atomic.Int64
+	// panicked counts calls failed by an injected server panic that was
+	// recovered.
+	panicked  atomic.Int64
+	cancelled atomic.Int64
+	// batchMissed counts calls cut off by their batch's shared deadline,
+	// which fail the batch rather than the run.
+	batchMissed atomic.Int64
+	// closed counts calls failed by the server shutting down, with
+	// expectShutdown.
+	closed         atomic.Int64
+	expectShutdown bool
+	active         atomic.Int64
+	// peakActive is the most unary calls outstanding at once, which grows
+	// without bound when open-loop dispatch outpaces the server.
+	peakActive atomic.Int64
+	// responses accounts for every unary call, by the sequence number its
+	// request carries.
+	responses *responseLedger
+	// perWorker counts the calls completed by each worker of a pool, and
+	// throughput holds the samples taken over the run.
+	perWorker  workerCounts
+	throughput []throughputSample
+}
+
+func runClient(ctx context.Context, cfg clientConfig) error {
+	if cfg.batchSize > 0 && cfg.duplicateValues {
+		return usageErrorf("batches cannot be combined with duplicate values")
+	}
+	if cfg.goroutinePerCall && (cfg.batchSize > 0 || cfg.duplicateValues) {
+		return usageErrorf("goroutine-per-call cannot be combined with batches or duplicate values")
+	}
+	// Connections are assigned to workers, so any beyond the number of workers
+	// would sit idle; herd is the command for holding idle connections open.
+	if !cfg.goroutinePerCall && cfg.conns > cfg.workers {
+		return usageErrorf("-conns (%d) must not exceed -workers (%d), or the extra connections are never used", cfg.conns, cfg.workers)
+	}
+	if cfg.goroutinePerCall && cfg.rampup > 0 {
+		return usageErrorf("ramp-up applies only to a pool of workers, not goroutine-per-call")
+	}
+	if cfg.rampdown > 0 && cfg.duration == 0 {
+		return usageErrorf("ramp-down applies only to runs of a fixed -duration")
+	}
+	if cfg.rampdown > cfg.duration-cfg.rampup && cfg.duration > 0 {
+		return usageErrorf("ramp-up and ramp-down together must fit within -duration")
+	}
+	if cfg.steadyWindow > 0 && (cfg.goroutinePerCall || cfg.batchSize > 0 || cfg.duplicateValues) {
+		return usageErrorf("steady-state measurement cannot be combined with other dispatch modes")
+	}
+	if cfg.duration > 0 && (cfg.steadyWindow > 0 || cfg.goroutinePerCall || cfg.batchSize > 0 || cfg.duplicateValues) {
+		return usageErrorf("a fixed duration cannot be combined with other dispatch modes")
+	}
+	if cfg.mode == "stream" && (cfg.duration > 0 || cfg.steadyWindow > 0 || cfg.goroutinePerCall || cfg.batchSize > 0 || cfg.duplicateValues) {
+		return usageErrorf("stream mode cannot be combined with other dispatch modes")
+	}
+	if cfg.burstSize > 0 && (cfg.mode == "stream" || cfg.duration > 0 || cfg.steadyWindow > 0 || cfg.goroutinePerCall || cfg.batchSize > 0 || cfg.duplicateValues || cfg.rate > 0) {
+		return usageErrorf("bursts cannot be combined with other dispatch modes or rate limiting")
+	}
+	if cfg.rate > 0 && (cfg.mode == "stream" || cfg.batchSize > 0 || cfg.duplicateValues) {
+		return usageErrorf("rate limiting cannot be combined with stream mode, batches, or duplicate values")
+	}
+	if cfg.warmup > 0 && cfg.steadyWindow > 0 {
+		return usageErrorf("steady-state measurement has its own warm-up; use -steady-warmup")
+	}
+	if cfg.mode == "stream" && (cfg.method != "" && cfg.method != "echo" || cfg.mix != "") {
+		return usageErrorf("stream mode only echoes; -method and -mix do not apply")
+	}
+	if cfg.mode == "stream" && cfg.callTimeout > 0 {
+		return usageErrorf("call timeouts apply only to unary calls")
+	}
+	if cfg.mode == "stream" && cfg.reconnect {
+		return usageErrorf("reconnecting applies only to unary calls")
+	}
+	if cfg.mode == "stream" && cfg.cancelRate > 0 {
+		return usageErrorf("cancellation applies only to unary calls")
+	}
+	if cfg.mode == "stream" && cfg.metadataKeys > 0 {
+		return usageErrorf("metadata applies only to unary calls")
+	}
+	if cfg.mode == "stream" && cfg.otlpEndpoint != "" {
+		return usageErrorf("tracing applies only to unary calls")
+	}
+	// Each worker's oneway messages share a stream on its connection, which is
+	// lost if the connection drops.
+	if cfg.onewayRate > 0 && (cfg.mode == "stream" || cfg.goroutinePerCall || cfg.batchSize > 0 || cfg.burstSize > 0 || cfg.reconnect || cfg.expectShutdown) {
+		return usageErrorf("oneway messages apply only to unary calls from a pool of workers, without batches, bursts, reconnecting, or expecting a shutdown")
+	}
+	if cfg.expectShutdown && (cfg.mode == "stream" || cfg.reconnect) {
+		return usageErrorf("expecting a server shutdown applies only to unary calls, without reconnecting")
+	}
+	// Only a pool that takes its calls one at a time, as they come, can grow or
+	// shrink without disturbing how the run's work is divided between workers.
+	if cfg.control != "" && (cfg.mode == "stream" || cfg.goroutinePerCall || cfg.batchSize > 0 || cfg.burstSize > 0 || cfg.duplicateValues || cfg.steadyWindow > 0 || cfg.rampup > 0 || cfg.rampdown > 0) {
+		return usageErrorf("-control applies only to unary calls from a pool of workers, without batches, bursts, duplicate values, ramping, or -steady-window")
+	}
+	if cfg.maxWorkers != 0 && cfg.control == "" {
+		return usageErrorf("-max-workers requires -control")
+	}
+	if cfg.maxWorkers != 0 && cfg.maxWorkers < cfg.workers {
+		return usageErrorf("-max-workers (%d) must not be less than -workers (%d)", cfg.maxWorkers, cfg.workers)
+	}
+	if cfg.protocol == "" {
+		cfg.protocol = protocolTTRPC
+	}
+	// gRPC frames its own messages, and re-dials by itself, so looking for
+	// ttrpc frames and replacing connections do not apply.
+	if cfg.protocol == protocolGRPC && (cfg.mode == "stream" || cfg.onewayRate > 0 || cfg.reconnect || cfg.expectShutdown || cfg.detectDuplicates || cfg.keepalive > 0 || cfg.metadataKeys > 0 || cfg.capture != nil) {
+		return usageErrorf("-protocol grpc applies only to unary calls, without -oneway-rate, -reconnect, -expect-shutdown, -detect-duplicates, -keepalive, -metadata-keys, or -capture")
+	}
+	cfg.seed = resolveSeed(cfg.seed)
+	tl := cfg.tl
+	conns := cfg.conns
+	if conns < 1 {
+		conns = 1
+	}
+	// With -control, the whole pool is started, and held at the gate beyond
+	// the workers active.
+	var gate *workerGate
+	pool := cfg.workers
+	if cfg.control != "" {
+		pool = max(cfg.maxWorkers, cfg.workers)
+		gate = newWorkerGate(cfg.workers, pool)
+	}
+	run := &clientRun{
+		methods:        []string{methodEcho},
+		reqData:        filler(cfg.payloadBytes),
+		echo:           cfg.expectRespBytes < 0,
+		respBytes:      cfg.expectRespBytes,
+		callTimeout:    cfg.callTimeout,
+		abortOnTimeout: cfg.abortOnTimeout,
+		cancelRate:     cfg.cancelRate,
+		cancelAfter:    cfg.cancelAfter,
+		streamType:     cfg.streamType,
+		reconnect:      cfg.reconnect,
+		inflight:       newInflightTracker(),
+		responses:      newResponseLedger(),
+		expectShutdown: cfg.expectShutdown,
+		mdKeys:         cfg.metadataKeys,
+		mdBytes:        cfg.metadataBytes,
+		traceRate:      cfg.traceRate,
+		onewayRate:     cfg.onewayRate,
+		oneway:         make([]*onewayStream, pool),
+		randomValues:   cfg.randomValues,
+		seed:           cfg.seed,
+	}
+	if !cfg.goroutinePerCall {
+		run.perWorker = newWorkerCounts(pool)
+	}
+	// A mix is parsed before connecting, so that a bad one fails fast.
+	if cfg.mix != "" {
+		methods, err := parseMix(cfg.mix)
+		if err != nil {
+			return usageErrorf("-mix: %s", err)
+		}
+		run.methods = methods
+		run.largeData = filler(largeResponseBytes)
+	}
+	var logParams sync.Once
+	dialConn := func(name string) (net.Conn, error) {
+		tl.record(name, "dial", "%s", cfg.addr)
+		c, err := cfg.dial()
+		if err != nil {
+			tl.record(name, "error", "dial: %s", err)
+			return nil, withExit(exitTransport, err)
+		}
+		tl.record(name, "connected", "%s", c.RemoteAddr())
+		logParams.Do(func() { logClientParams(cfg, c.RemoteAddr().Network(), conns) })
+		c = throttleReads(cfg.writeChunks.wrapConn(c), cfg.readRate)
+		c = tl.wrapConn(cfg.capture.wrapConn(c, name), name)
+		if cfg.detectDuplicates {
+			detector := newDuplicateDetector(c)
+			run.detectMu.Lock()
+			run.detectors = append(run.detectors, detector)
+			run.detectMu.Unlock()
+			c = detector
+		}
+		return c, nil
+	}
+	run.connect = func(name string) (*ttrpc.Client, error) {
+		c, err := dialConn(name)
+		if err != nil {
+			return nil, err
+		}
+		return ttrpc.NewClient(c), nil
+	}
+	defer func() {
+		for _, slot := range run.conns {
+			slot.close()
+		}
+	}()
+	for i := 0; i < conns; i++ {
+		slot := &connSlot{name: "client"}
+		if conns > 1 {
+			slot.name = fmt.Sprintf("client-%d", i)
+		}
+		var err error
+		if cfg.protocol == protocolGRPC {
+			slot.grpc, err = dialGRPC(slot.name, dialConn)
+		} else {
+			slot.client, err = run.connect(slot.name)
+		}
+		if err != nil {
+			return err
+		}
+		run.conns = append(run.conns, slot)
+	}
+	if run.respBytes < 0 {
+		run.respBytes = cfg.payloadBytes
+	}
+	// Both the echoed request data and the server's own response data are filler,
+	// so the expected response content is known either way.
+	if cfg.payloadRandom {
+		run.payloadRandom = true
+		run.reqData = randomFiller(cfg.payloadBytes, cfg.seed)
+	}
+	run.reqSum = checksum(run.reqData)
+	run.respData = filler(run.respBytes)
+	switch cfg.method {
+	case "ping":
+		run.methods = []string{methodPing}
+	case "large":
+		run.methods = []string{methodLarge}
+		run.largeData = filler(largeResponseBytes)
+	case "mixed":
+		run.methods = []string{methodEcho, methodPing, methodLarge}
+		run.largeData = filler(largeResponseBytes)
+	}
+	if cfg.maxInflight > 0 {
+		run.limit = newCallLimit(cfg.maxInflight)
+	}
+	if cfg.maxInflightBytes > 0 {
+		run.budget = newByteBudget(cfg.maxInflightBytes)
+	}
+	if cfg.metricsAddr != "" {
+		run.latency.hist = newLatencyHistogram()
+	}
+	stopMetrics, merr := startMetrics(cfg.metricsAddr, func(m *metricsWriter) { writeClientMetrics(m, run) })
+	if merr != nil {
+		return merr
+	}
+	defer stopMetrics()
+	if gate != nil {
+		stopControl, err := serveControl(ctx, cfg.control, gate, run, tl)
+		if err != nil {
+			return err
+		}
+		defer stopControl()
+	}
+	run.progress.mark()
+	wdCtx, wdCancel := context.WithCancel(ctx)
+	defer wdCancel()
+	if cfg.watchdog > 0 {
+		go watchdog(wdCtx, cfg.watchdog, cfg.watchdogExit, &run.progress, run.inflight, tl)
+	}
+	if cfg.warmup > 0 {
+		if err := run.warm(ctx, cfg.warmup, cfg.workers); err != nil {
+			tl.record("client", "error", "%s", err)
+			return err
+		}
+	}
+	// Calls are recorded and traced only once the warmup is over, so that
+	// neither holds any of its calls.
+	if cfg.csv != "" {
+		samples, err := newSampleWriter(cfg.csv)
+		if err != nil {
+			return err
+		}
+		run.samples = samples
+		defer func() {
+			if err := samples.close(); err != nil {
+				errorf("failed writing call records: %s", err)
+			}
+		}()
+	}
+	run.tracer = newTracer(cfg.otlpEndpoint, "ttrpcstress-client")
+	defer run.tracer.close()
+	var duplex *duplexRun
+	if cfg.duplex != "" {
+		d, err := startDuplex(ctx, cfg, run, tl)
+		if err != nil {
+			return err
+		}
+		duplex = d
+	}
+	tsCtx, tsCancel := context.WithCancel(ctx)
+	tsDone := make(chan error, 1)
+	if cfg.timeseries != "" {
+		go func() { tsDone <- writeTimeseries(tsCtx, cfg.timeseries, cfg.timeseriesEvery, run) }()
+	} else {
+		tsDone <- nil
+	}
+	tpCtx, tpCancel := context.WithCancel(ctx)
+	tpDone := make(chan struct{})
+	go func() {
+		defer close(tpDone)
+		run.throughput = sampleThroughput(tpCtx, run)
+	}()
+	progCtx, progCancel := context.WithCancel(ctx)
+	progDone := make(chan struct{})
+	// On a terminal, progress is shown whether or not -progress was given.
+	bar := cfg.progressBar && currentLevel == levelInfo && isTerminal(os.Stderr)
+	progressEvery := cfg.progressEvery
+	if bar && progressEvery == 0 {
+		progressEvery = defaultProgressBarInterval
+	}
+	if progressEvery > 0 {
+		total := cfg.iters
+		if cfg.mode == "stream" || cfg.duplicateValues {
+			total *= cfg.workers
+		}
+		var end time.Time
+		switch {
+		case cfg.steadyWindow > 0:
+			total, end = 0, time.Now().Add(cfg.steadyWarmup+cfg.steadyWindow)
+		case cfg.duration > 0:
+			total, end = 0, time.Now().Add(cfg.duration)
+		}
+		go func() {
+			defer close(progDone)
+			logProgress(progCtx, progressEvery, run, total, end, bar)
+		}()
+	} else {
+		close(progDone)
+	}
+	kaCtx, kaCancel := context.WithCancel(ctx)
+	kaDone := make(chan struct{})
+	if cfg.keepalive > 0 {
+		go func() {
+			defer close(kaDone)
+			var (
+				wg         sync.WaitGroup
+				mu         sync.Mutex
+				ok, failed int
+			)
+			for _, slot := range run.conns {
+				wg.Add(1)
+				go func(slot *connSlot) {
+					defer wg.Done()
+					o, f := keepalive(kaCtx, slot.current, cfg.keepalive, tl)
+					mu.Lock()
+					ok, failed = ok+o, failed+f
+					mu.Unlock()
+				}(slot)
+			}
+			wg.Wait()
+			infof("keepalive pings: %d succeeded, %d failed", ok, failed)
+		}()
+	} else {
+		close(kaDone)
+	}
+	// Cancelling ctx, as an interrupt does, stops dispatch at once, but calls
+	// already in flight are given up to drainTimeout to complete, so that the
+	// summary of an interrupted run covers them, and only the calls that are
+	// truly stuck are left outstanding. Streams end at once.
+	callCtx, cancelCalls := drainContext(ctx, cfg.drainTimeout)
+	defer cancelCalls()
+	if cfg.drainTimeout > 0 {
+		stopNotify := context.AfterFunc(ctx, func() {
+			infof("interrupted: stopped sending, waiting up to %v for %d calls in flight", cfg.drainTimeout, run.active.Load())
+		})
+		defer stopNotify()
+	}
+	start := time.Now()
+	ch := make(chan int)
+	eg, egCtx := errgroup.WithContext(ctx)
+	var (
+		bstats batchStats
+		stop   = make(chan struct{})
+		next   atomic.Uint32
+	)
+	if cfg.steadyWindow > 0 {
+		run.window = &measureWindow{}
+	}
+	var bursts *burstSchedule
+	if cfg.burstSize > 0 {
+		bursts = newBurstSchedule(cfg.iters, cfg.workers, cfg.burstSize, cfg.burstInterval)
+	}
+	// goWorker starts worker w, after its share of the ramp-up. Work left
+	// undispatched is picked up by the workers already online, so none is lost.
+	var online atomic.Int64
+	goWorker := func(w int, f func() error) {
+		eg.Go(func() error {
+			if cfg.rampup > 0 && cfg.workers > 1 {
+				delay := time.Duration(int64(cfg.rampup) * int64(w) / int64(cfg.workers-1))
+				select {
+				case <-time.After(time.Until(start.Add(delay))):
+				case <-egCtx.Done():
+					return nil
+				}
+				if online.Add(1) == int64(cfg.workers) {
+					infof("all %d workers online after %v", cfg.workers, time.Since(start).Round(time.Millisecond))
+					tl.record("client", "rampup", "all %d workers online", cfg.workers)
+				}
+			}
+			defer run.perWorker.finish(w)
+			if err := f(); err != nil {
+				return err
+			}
+			return run.closeOneway(w)
+		})
+	}
+	// retire returns a channel that is ready once worker w is due to stop, for
+	// its share of the ramp-down, or nil if it runs to the end.
+	retire := func(w int) <-chan time.Time {
+		if cfg.rampdown <= 0 {
+			return nil
+		}
+		early := cfg.rampdown
+		if cfg.workers > 1 {
+			early = time.Duration(int64(cfg.rampdown) * int64(cfg.workers-1-w) / int64(cfg.workers-1))
+		}
+		return time.After(time.Until(start.Add(cfg.duration - early)))
+	}
+	// pace waits for the next dispatch slot when a rate is set. Ticks missed
+	// while dispatch is blocked are dropped, so a stall is not followed by a burst.
+	var tick <-chan time.Time
+	if cfg.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	pace := func() bool {
+		if tick == nil {
+			return egCtx.Err() == nil
+		}
+		select {
+		case <-tick:
+			return true
+		case <-egCtx.Done():
+			return false
+		}
+	}
+	// dispatched is closed once every call has been dispatched, to release the
+	// workers held at the gate.
+	dispatched := make(chan struct{})
+	for w := 0; w < pool && !cfg.goroutinePerCall; w++ {
+		w := w
+		if cfg.mode == "stream" {
+			goWorker(w, func() error { return run.stream(ctx, w, cfg.iters) })
+			continue
+		}
+		if cfg.steadyWindow > 0 || cfg.duration > 0 {
+			// Workers check for the end of the run only between calls, so that
+			// calls in flight when it ends still complete. With a rate, workers
+			// take turns at the shared dispatch slots.
+			retired := retire(w)
+			goWorker(w, func() error {
+				for {
+					select {
+					case <-stop:
+						return nil
+					case <-retired:
+						debugf("worker %d retired", w)
+						return nil
+					default:
+					}
+					if !gate.wait(w, stop, egCtx.Done()) {
+						return nil
+					}
+					if tick != nil {
+						select {
+						case <-stop:
+							return nil
+						case <-retired:
+							debugf("worker %d retired", w)
+							return nil
+						case <-tick:
+						}
+					}
+					if err := run.send(callCtx, w, next.Add(1)); err != nil {
+						return err
+					}
+				}
+			})
+			continue
+		}
+		if bursts != nil {
+			goWorker(w, func() error { return bursts.worker(callCtx, egCtx, run, w) })
+			continue
+		}
+		if cfg.batchSize > 0 {
+			goWorker(w, func() error {
+				for first := range ch {
+					n := cfg.batchSize
+					if first+n > cfg.iters {
+						n = cfg.iters - first
+					}
+					if err := run.sendBatch(callCtx, w, uint32(first), n, cfg.batchDeadline, &bstats); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			continue
+		}
+		if cfg.duplicateValues {
+			goWorker(w, func() error {
+				for i := 0; i < cfg.iters && egCtx.Err() == nil; i++ {
+					if err := run.send(callCtx, w, uint32(i)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			continue
+		}
+		goWorker(w, func() error {
+			for {
+				if !gate.wait(w, dispatched, egCtx.Done()) {
+					return nil
+				}
+				i, ok := <-ch
+				if !ok {
+					return nil
+				}
+				if err := run.send(callCtx, w, uint32(i)); err != nil {
+					return err
+				}
+			}
+		})
+	}
+	// Work is dispatched until done, or until a worker fails.
+	dispatch := func(i int) bool {
+		select {
+		case ch <- i:
+			return true
+		case <-egCtx.Done():
+			return false
+		}
+	}
+	sleep := func(d time.Duration) bool {
+		select {
+		case <-time.After(d):
+			return true
+		case <-egCtx.Done():
+			return false
+		}
+	}
+	switch {
+	case cfg.steadyWindow > 0:
+		if sleep(cfg.steadyWarmup) {
+			run.window.begin(cfg.steadyWindow)
+			sleep(cfg.steadyWindow)
+		}
+		close(stop)
+	case cfg.duration > 0:
+		sleep(cfg.duration)
+		close(stop)
+	case cfg.goroutinePerCall:
+		for i := 0; i < cfg.iters && pace(); i++ {
+			i := i
+			eg.Go(func() error { return run.send(callCtx, i, uint32(i)) })
+		}
+	case bursts != nil:
+		bursts.run(egCtx)
+	case cfg.batchSize > 0:
+		for i := 0; i < cfg.iters; i += cfg.batchSize {
+			if !dispatch(i) {
+				break
+			}
+		}
+	case cfg.mode != "stream" && !cfg.duplicateValues:
+		for i := 0; i < cfg.iters; i++ {
+			if !pace() || !dispatch(i) {
+				break
+			}
+		}
+	}
+	close(ch)
+	close(dispatched)
+	err := eg.Wait()
+	elapsed := time.Since(start)
+	// The progress bar is cleared before anything else is written.
+	progCancel()
+	<-progDone
+	if errors.Is(err, errServerShutdown) {
+		err = nil
+	}
+	// An interrupted run lists the calls it was still waiting on, to be matched
+	// against the server's log of the requests it received. Its calls failed
+	// only because they were cancelled, so their errors are not reported.
+	if ctx.Err() != nil {
+		calls := run.inflight.snapshot()
+		if len(calls) > 0 {
+			fmt.Fprintf(os.Stderr, "=== interrupted with %d calls outstanding, longest first ===\n", len(calls))
+			listInflight(calls)
+		}
+		err = fmt.Errorf("interrupted with %d calls outstanding", len(calls))
+	}
+	// Once every call has returned, each must have been answered or failed
+	// exactly once.
+	if err == nil {
+		err = run.responses.check()
+	}
+	// The server's calls back run alongside the client's, and must finish, and
+	// succeed, for the run to pass.
+	if duplex != nil {
+		if err != nil {
+			duplex.abandon()
+		} else {
+			err = duplex.wait(ctx)
+		}
+	}
+	kaCancel()
+	<-kaDone
+	tpCancel()
+	<-tpDone
+	tsCancel()
+	if tsErr := <-tsDone; tsErr != nil {
+		errorf("failed writing timeseries: %s", tsErr)
+	}
+	// Calls that timed out do not end the run early, but do fail it.
+	result := err
+	if n := run.timedOut.Load(); result == nil && n > 0 {
+		result = withExit(exitStalled, fmt.Errorf("%d calls timed out after %v", n, cfg.callTimeout))
+	}
+	if cfg.output == "json" || cfg.jsonPath != "" || cfg.result != nil {
+		res := newClientResult(cfg, run, elapsed, result)
+		if cfg.result != nil {
+			*cfg.result = res
+		}
+		if cfg.output == "json" {
+			if werr := res.write(os.Stdout); werr != nil {
+				errorf("failed writing result: %s", werr)
+			}
+		}
+		if cfg.jsonPath != "" {
+			if werr := res.writeFile(cfg.jsonPath); werr != nil {
+				errorf("failed writing result: %s", werr)
+			}
+		}
+	}
+	switch {
+	case cfg.output == "json":
+	case run.window != nil:
+		// Steady-state runs report only calls within their measurement window.
+		run.window.report()
+	default:
+		summarizeLatency(run.latency.sorted()).report(elapsed)
+		reportThroughput(run.throughput, run.perWorker.snapshot())
+	}
+	if cfg.batchSize > 0 {
+		bstats.report(run.batchMissed.Load())
+	}
+	if bursts != nil && cfg.output == "text" {
+		bursts.report()
+	}
+	if cfg.detectDuplicates {
+		var duplicates int64
+		for _, d := range run.detectors {
+			duplicates += d.duplicates.Load()
+		}
+		infof("duplicate responses detected: %d", duplicates)
+	}
+	if cfg.reconnect && cfg.output == "text" {
+		infof("reconnects: %d; calls lost to a dropped connection and retried: %d", run.reconnects.Load(), run.lost.Load())
+		if mean, longest := run.recovery.summary(); longest > 0 {
+			infof("recovery time: mean %v, max %v", mean.Round(time.Microsecond), longest.Round(time.Microsecond))
+		}
+	}
+	if cfg.callTimeout > 0 && cfg.output == "text" {
+		infof("calls: %d succeeded, %d timed out (>%v), %d failed",
+			run.completed.Load(), run.timedOut.Load(), cfg.callTimeout, run.failed.Load())
+	}
+	if n := run.injected.Load(); n > 0 && cfg.output == "text" {
+		infof("calls failed with injected server errors: %d", n)
+	}
+	if n := run.panicked.Load(); n > 0 && cfg.output == "text" {
+		infof("calls failed by recovered server panics: %d, with later calls on the same connections unaffected", n)
+	}
+	if cfg.rate > 0 && cfg.output == "text" {
+		infof("peak outstanding calls: %d", run.peakActive.Load())
+	}
+	if cfg.onewayRate > 0 && cfg.output == "text" {
+		infof("oneway messages: %d sent, %d confirmed by the server", run.onewaySent.Load(), run.onewayConfirmed.Load())
+	}
+	if cfg.cancelRate > 0 && cfg.output == "text" {
+		infof("calls cancelled before their response arrived: %d", run.cancelled.Load())
+	}
+	if cfg.expectShutdown {
+		if n := run.closed.Load(); n == 0 {
+			warnf("the server did not shut down during the run")
+		} else if cfg.output == "text" {
+			infof("calls failed by the server shutting down: %d", n)
+		}
+	}
+	if err != nil {
+		tl.record("client", "error", "run: %s", err)
+		return err
+	}
+	if n := run.timedOut.Load(); n > 0 {
+		tl.record("client", "error", "%d calls timed out", n)
+		return result
+	}
+	return nil
+}
+
+// logClientParams logs the conditions of a client run.
+func logClientParams(cfg clientConfig, transport string, conns int) {
+	logConnParams("client",
+		connParam{"protocol", cfg.protocol},
+		connParam{"transport", transport},
+		connParam{"address", cfg.addr},
+		connParam{"connections", conns},
+		connParam{"request size", payloadSize(&payload{Value: math.MaxUint32, Data: filler(cfg.payloadBytes), Checksum: math.MaxUint32, Worker: math.MaxUint32, Sequence: math.MaxUint64, DataHash: math.MaxUint64})},
+		connParam{"metadata keys", cfg.metadataKeys},
+		connParam{"metadata bytes", cfg.metadataBytes},
+		connParam{"expected response data", cfg.expectRespBytes},
+		connParam{"iterations", cfg.iters},
+		connParam{"warmup", cfg.warmup},
+		connParam{"duration", cfg.duration},
+		connParam{"workers", cfg.workers},
+		connParam{"max workers", cfg.maxWorkers},
+		connParam{"control", cfg.control},
+		connParam{"duplex", cfg.duplex},
+		connParam{"rampup", cfg.rampup},
+		connParam{"rampdown", cfg.rampdown},
+		connParam{"mode", cfg.mode},
+		connParam{"stream type", cfg.streamType},
+		connParam{"method", cfg.method},
+		connParam{"mix", cfg.mix},
+		connParam{"rate", cfg.rate},
+		connParam{"read rate", cfg.readRate},
+		connParam{"write chunk", cfg.writeChunks.size},
+		connParam{"write pause", cfg.writeChunks.pause},
+		connParam{"burst size", cfg.burstSize},
+		connParam{"burst interval", cfg.burstInterval},
+		connParam{"call timeout", cfg.callTimeout},
+		connParam{"abort on timeout", cfg.abortOnTimeout},
+		connParam{"drain timeout", cfg.drainTimeout},
+		connParam{"cancel rate", cfg.cancelRate},
+		connParam{"cancel after", cfg.cancelAfter},
+		connParam{"reconnect", cfg.reconnect},
+		connParam{"expect shutdown", cfg.expectShutdown},
+		connParam{"random values", cfg.randomValues},
+		connParam{"random payloads", cfg.payloadRandom},
+		connParam{"seed", cfg.seed},
+		connParam{"max inflight", cfg.maxInflight},
+		connParam{"max inflight bytes", cfg.maxInflightBytes})
+}
+
+// warm sends n calls spread across workers, then discards their stats so that
+// connection setup and other first-use costs do not skew the results. Any
+// failure during warm-up fails the run.
+func (r *clientRun) warm(ctx context.Context, n, workers int) error {
+	start := time.Now()
+	eg, egCtx := errgroup.WithContext(ctx)
+	for w := 0; w < workers; w++ {
+		w := w
+		eg.Go(func() error {
+			for i := w; i < n && egCtx.Err() == nil; i += workers {
+				if err := r.send(egCtx, w, uint32(i)); err != nil {
+					return fmt.Errorf("warmup: %w", err)
+				}
+			}
+			if err := r.closeOneway(w); err != nil {
+				return fmt.Errorf("warmup: %w", err)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	infof("warmup: %d requests in %v", n, time.Since(start).Round(time.Millisecond))
+	// Nothing the warmup did is counted in the run, or in its metrics.
+	r.latency = latencyRecorder{hist: r.latency.hist}
+	r.latency.hist.reset()
+	r.completed.Store(0)
+	r.failed.Store(0)
+	r.lost.Store(0)
+	r.reconnects.Store(0)
+	r.recovery.reset()
+	r.timedOut.Store(0)
+	r.injected.Store(0)
+	r.panicked.Store(0)
+	r.batchMissed.Store(0)
+	r.cancelled.Store(0)
+	r.closed.Store(0)
+	r.onewaySent.Store(0)
+	r.onewayConfirmed.Store(0)
+	r.peakActive.Store(0)
+	r.perWorker.reset()
+	return nil
+}
+
+// send issues a single call from the given worker and verifies the response
+// echoes the value sent. Since the same value may be in flight from several
+// workers at once, errors identify both the worker and the value.
+func (r *clientRun) send(ctx context.Context, worker int, id uint32) error {
+	if randomChance(r.seed, drawOneway, id, r.onewayRate) {
+		return r.sendOneway(ctx, worker, id)
+	}
+	var (
+		method = r.methods[id%uint32(len(r.methods))]
+		req    = r.request(id)
+		resp   = &payload{}
+		seq    = r.responses.issue()
+	)
+	req.Worker, req.Sequence, req.DataHash = uint32(worker), seq, dataHash(seq, req.Data)
+	if err := r.limit.acquire(ctx); err != nil {
+		r.responses.failed(seq)
+		return err
+	}
+	defer r.limit.release()
+	reqSize := payloadSize(req)
+	n := int64(reqSize + reqSize - len(req.Data) + len(r.expectedData(id, method)))
+	if err := r.budget.acquire(ctx, n); err != nil {
+		r.responses.failed(seq)
+		return err
+	}
+	defer r.budget.release(n)
+	debugf("worker %d sending request: %d", worker, id)
+	token := r.inflight.begin(worker, id, req.Value)
+	r.markActive(r.active.Add(1))
+	callCtx := ctx
+	if r.callTimeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, r.callTimeout)
+		defer cancel()
+	}
+	cancelling := randomChance(r.seed, drawCancel, id, r.cancelRate)
+	if cancelling {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithCancel(callCtx)
+		defer cancel()
+		t := time.AfterFunc(randomDuration(r.seed, drawCancelAfter, id, r.cancelAfter), cancel)
+		defer t.Stop()
+	}
+	if r.mdKeys > 0 {
+		callCtx = ttrpc.WithMetadata(callCtx, callMetadata(req.Value, r.mdKeys, r.mdBytes))
+	}
+	var sp *span
+	if r.tracer != nil && randomChance(r.seed, drawTrace, id, r.traceRate) {
+		callCtx, sp = r.tracer.startClient(callCtx, method,
+			spanAttr{"ttrpcstress.worker", worker}, spanAttr{"ttrpcstress.request_id", id}, spanAttr{"ttrpcstress.value", req.Value})
+	}
+	start := time.Now()
+	err := r.call(callCtx, worker, method, req, resp)
+	end := time.Now()
+	r.tracer.finish(sp, err)
+	r.active.Add(-1)
+	// A call cut short by the run being interrupted never got its response, so
+	// it is left outstanding for the report of stuck calls.
+	if err == nil || !errors.Is(ctx.Err(), context.Canceled) {
+		r.inflight.end(token)
+		switch {
+		case err != nil:
+			r.responses.failed(seq)
+		case method == methodPing:
+			// Ping responses are empty, so carry no sequence number.
+			r.responses.answered(seq)
+		default:
+			r.responses.answered(resp.Sequence)
+		}
+	}
+	r.progress.mark()
+	// Errors injected by the server's -error-rate are expected, and counted, as
+	// long as they came back for the right request. They are checked first, as
+	// their code may be any, including those of a timeout or cancellation.
+	if v, ok := injectedErrorValue(err); ok {
+		if want := r.value(id); v != want {
+			r.samples.record(worker, id, start, end, callMismatch, err)
+			r.failed.Add(1)
+			return withExit(exitMismatch, fmt.Errorf("worker %d request %d: got the injected error for request value %d, expected %d", worker, id, v, want))
+		}
+		r.samples.record(worker, id, start, end, callInjected, err)
+		r.injected.Add(1)
+		debugf("worker %d request %d failed with an injected %s error", worker, id, status.Code(err))
+		return nil
+	}
+	// A handler the server's -panic-rate had panic must fail only its own
+	// call, once the panic is recovered, leaving the connection to carry the
+	// calls after it. A server that does not recover it crashes instead, and
+	// the run fails with the connection.
+	if v, ok := injectedPanicValue(err); ok {
+		if want := r.value(id); v != want {
+			r.samples.record(worker, id, start, end, callMismatch, err)
+			r.failed.Add(1)
+			return withExit(exitMismatch, fmt.Errorf("worker %d request %d: got the error for an injected panic on request value %d, expected %d", worker, id, v, want))
+		}
+		r.samples.record(worker, id, start, end, callPanicked, err)
+		r.panicked.Add(1)
+		debugf("worker %d request %d failed by an injected server panic: %s", worker, id, err)
+		return nil
+	}
+	// A call cut off by its batch's deadline is a miss of the batch, not a
+	// failure of the call; see sendBatch.
+	if err != nil && errors.Is(context.Cause(ctx), errBatchDeadline) {
+		r.samples.record(worker, id, start, end, callBatchMissed, err)
+		r.batchMissed.Add(1)
+		return errBatchDeadline
+	}
+	// A call that outlived its own timeout, rather than some deadline of the
+	// caller's, is counted and skipped so the remaining calls are still attempted,
+	// unless abortOnTimeout asks for the run to end at the first.
+	if err != nil && r.callTimeout > 0 && isTimeout(err) && ctx.Err() == nil {
+		r.samples.record(worker, id, start, end, callTimedOut, err)
+		r.timedOut.Add(1)
+		if r.abortOnTimeout {
+			return withExit(exitStalled, fmt.Errorf("worker %d request %d: no response within %v", worker, id, r.callTimeout))
+		}
+		debugf("worker %d request %d timed out after %v", worker, id, r.callTimeout)
+		return nil
+	}
+	// A call cancelled by -cancel-rate before its response arrived is expected.
+	// One whose response won the race is verified as usual.
+	if err != nil && cancelling && ctx.Err() == nil && (errors.Is(err, context.Canceled) || status.Code(err) == codes.Canceled) {
+		r.samples.record(worker, id, start, end, callCancelled, err)
+		r.cancelled.Add(1)
+		debugf("worker %d request %d cancelled", worker, id)
+		return nil
+	}
+	// With -expect-shutdown, a call failed by the server closing its connection
+	// ends the run, once the calls already in flight have finished too.
+	if err != nil && r.expectShutdown && errors.Is(err, ttrpc.ErrClosed) && ctx.Err() == nil {
+		r.samples.record(worker, id, start, end, callClosed, err)
+		r.closed.Add(1)
+		debugf("worker %d request %d failed by the server shutting down", worker, id)
+		return errServerShutdown
+	}
+	if err != nil {
+		r.samples.record(worker, id, start, end, callFailed, err)
+		r.failed.Add(1)
+		return withExit(callExit(err), fmt.Errorf("worker %d request %d: %w", worker, id, err))
+	}
+	debugf("worker %d got response: %d", worker, resp.Value)
+	err = r.verifyOrigin(worker, id, seq, method, resp)
+	if err == nil {
+		err = r.verify(worker, id, method, resp)
+	}
+	if err != nil {
+		r.samples.record(worker, id, start, end, callMismatch, err)
+		r.failed.Add(1)
+		return withExit(exitMismatch, err)
+	}
+	r.samples.record(worker, id, start, end, callOK, nil)
+	r.completed.Add(1)
+	r.perWorker.add(worker, 1)
+	r.latency.record(worker, end.Sub(start))
+	r.window.record(start, end)
+	return nil
+}
+
+// markActive records that n calls are outstanding.
+func (r *clientRun) markActive(n int64) {
+	for {
+		peak := r.peakActive.Load()
+		if n <= peak || r.peakActive.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+// slotFor returns the connection the given worker sends on.
+func (r *clientRun) slotFor(worker int) *connSlot {
+	return r.conns[worker%len(r.conns)]
+}
+
+// value returns the value sent with request id.
+func (r *clientRun) value(id uint32) uint32 {
+	if !r.randomValues {
+		return id
+	}
+	return randomValue(r.seed, id)
+}
+
+// requestData returns the data sent with request id, and its checksum. With
+// payloadRandom, each request sends a prefix of reqData of pseudo-random length.
+func (r *clientRun) requestData(id uint32) ([]byte, uint32) {
+	if !r.payloadRandom {
+		return r.reqData, r.reqSum
+	}
+	// The seed is offset so that the length is not derived from the value.
+	data := r.reqData[:randomValue(r.seed+1, id)%uint32(len(r.reqData)+1)]
+	return data, checksum(data)
+}
+
+// request returns the payload to send as request id.
+func (r *clientRun) request(id uint32) *payload {
+	data, sum := r.requestData(id)
+	return &payload{Value: r.value(id), Data: data, Checksum: sum}
+}
+
+// expectedData returns the response data expected from request id sent to
+// method.
+func (r *clientRun) expectedData(id uint32, method string) []byte {
+	switch method {
+	case methodPing:
+		return nil
+	case methodLarge:
+		return r.largeData
+	}
+	if r.payloadRandom && r.echo {
+		data, _ := r.requestData(id)
+		return data
+	}
+	return r.respData
+}
+
+// verify checks that resp is the expected response to request id sent to
+// method: that it carries the same value, that its data is intact, and that the
+// server received the request data intact. A response for the wrong request or
+// method, or one that was truncated or corrupted in framing, fails at least one
+// of these.
+func (r *clientRun) verify(worker int, id uint32, method string, resp *payload) error {
+	if method == methodFail {
+		return fmt.Errorf("worker %d request %d: expected the injected error of %s but got a response with value %d", worker, id, methodFail, resp.Value)
+	}
+	if method == methodPing {
+		if resp.Value != 0 || len(resp.Data) != 0 || resp.Checksum != 0 {
+			return fmt.Errorf("worker %d request %d: expected an empty ping response but got value %d with %d bytes of data", worker, id, resp.Value, len(resp.Data))
+		}
+		return nil
+	}
+	if value := r.value(id); resp.Value != value {
+		return fmt.Errorf("worker %d request %d: expected return value %d but got %d", worker, id, value, resp.Value)
+	}
+	if err := diffData(r.expectedData(id, method), resp.Data); err != nil {
+		return fmt.Errorf("worker %d request %d: %w", worker, id, err)
+	}
+	if _, sum := r.requestData(id); resp.Checksum != sum {
+		return fmt.Errorf("worker %d request %d: server computed request checksum %08x, expected %08x", worker, id, resp.Checksum, sum)
+	}
+	return nil
+}
+
+// verifyOrigin checks that resp, to request id sent by worker with sequence
+// number seq, is the response to that request rather than to another: that it
+// echoes the worker and sequence number, and carries the hash of its data for
+// that sequence number. A response routed to the wrong call fails this, even
+// where the two requests carried the same value, as with -duplicate-values.
+func (r *clientRun) verifyOrigin(worker int, id uint32, seq uint64, method string, resp *payload) error {
+	if method == methodPing || method == methodFail {
+		return nil
+	}
+	if resp.Worker != uint32(worker) || resp.Sequence != seq {
+		return fmt.Errorf("worker %d request %d (sequence %d): got the response to worker %d's request with sequence %d", worker, id, seq, resp.Worker, resp.Sequence)
+	}
+	if h := dataHash(seq, resp.Data); resp.DataHash != h {
+		return fmt.Errorf("worker %d request %d: response data hash %016x, expected %016x", worker, id, resp.DataHash, h)
+	}
+	return nil
+}
+
+// diffData describes how the response data got differs from want, if it does.
+func diffData(want, got []byte) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("expected %d bytes of response data but got %d", len(want), len(got))
+	}
+	if !bytes.Equal(got, want) {
+		off := 0
+		for got[off] == want[off] {
+			off++
+		}
+		return fmt.Errorf("response data differs at offset %d: expected %#02x but got %#02x", off, want[off], got[off])
+	}
+	return nil
+}
+
+// isTimeout reports whether err is a call deadline expiring, either locally or
+// as reported by the server.
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded
+}
@@ -0,0 +1,138 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// command is a ttrpcstress subcommand.
+type command struct {
+	name    string
+	summary string
+	// setup registers the command's flags on fs, and returns a function that runs
+	// the command once the flags have been parsed.
+	setup func(fs *flag.FlagSet) func(ctx context.Context) error
+}
+
+var commands = []command{
+	{"server", "Run a server that echoes requests", serverCommand},
+	{"stop", "Stop a server started with -pidfile, such as one running in the background with -detach", stopCommand},
+	{"client", "Send requests to a server", clientCommand},
+	{"both", "Run a server and a client against it in one process, on a private pipe", bothCommand},
+	{"herd", "Open many connections to a server at once", herdCommand},
+	{"maxsize", "Send messages at and around the message size limit and check how they fail", maxSizeCommand},
+	{"fuzz", "Send malformed TTRPC frames to a server and check that it rejects them without hanging", fuzzCommand},
+	{"interop", "Send payloads encoded by either protobuf generator variant and check that they round trip", interopCommand},
+	{"churn", "Repeatedly open connections, send a burst of calls, and close them", churnCommand},
+	{"idle", "Hold connections open with long idle gaps between bursts of calls", idleCommand},
+	{"scale", "Change the number of active workers of a client run with -control", scaleCommand},
+	{"proxy", "Relay connections to a server, injecting delays and faults", proxyCommand},
+	{"smoke", "Run a short sanity check against an in-process server", smokeCommand},
+	{"parity", "Run the same workload over each local transport and compare", parityCommand},
+	{"matrix", "Run every pairing of client and server binaries built against different ttrpc versions", matrixCommand},
+	{"footprint", "Compare memory use of worker pool and goroutine-per-call dispatch", footprintCommand},
+	{"decode", "Print the TTRPC frames in a file written with -capture", decodeCommand},
+	{"wire-hash", "Print a hash of the payload wire format", wireHashCommand},
+}
+
+// Main runs the ttrpcstress command named by os.Args[1], and exits.
+func Main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	name := os.Args[1]
+	if name == "-help" || name == "--help" || name == "-h" || name == "help" {
+		usage()
+	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(fs.Output(), "usage: ttrpcstress %s [OPTIONS]\n\n%s.\n\noptions:\n", cmd.name, cmd.summary)
+			fs.PrintDefaults()
+		}
+		logFlags(fs)
+		configPath := configFlag(fs)
+		run := cmd.setup(fs)
+		if err := fs.Parse(os.Args[2:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(0)
+			}
+			os.Exit(exitUsage)
+		}
+		if *configPath != "" {
+			if err := applyConfig(fs, *configPath, cmd.name); err != nil {
+				fmt.Fprintf(os.Stderr, "ttrpcstress %s: %s\n", cmd.name, err)
+				os.Exit(exitUsage)
+			}
+		}
+		if fs.NArg() != 0 {
+			fmt.Fprintf(os.Stderr, "ttrpcstress %s: unexpected argument %q\n", cmd.name, fs.Arg(0))
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		if err := run(context.Background()); err != nil {
+			var uerr usageError
+			if errors.As(err, &uerr) {
+				fmt.Fprintf(os.Stderr, "ttrpcstress %s: %s\n", cmd.name, err)
+				fs.Usage()
+				os.Exit(exitUsage)
+			}
+			log.Printf("%s: %s", cmd.name, err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "ttrpcstress: unknown command %q\n", name)
+	usage()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: ttrpcstress <COMMAND> [OPTIONS]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun \"ttrpcstress <COMMAND> -help\" for the options of each command.\n")
+	os.Exit(exitUsage)
+}
+
+// usageError is returned by a command when its flags are invalid.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+func usageErrorf(format string, args ...interface{}) error {
+	return usageError(fmt.Sprintf(format, args...))
+}
+
+// timelineFlag registers the -timeline flag, shared by every command that makes
+// connections.
+func timelineFlag(fs *flag.FlagSet) *string {
+	return fs.String("timeline", "", "Write a timeline of connection lifecycle events to this file (\"-\" for stderr)")
+}
+
+// captureFlag registers the -capture flag, shared by the client and server.
+func captureFlag(fs *flag.FlagSet) *string {
+	return fs.String("capture", "", "Write every byte sent and received on each connection, timestamped, to this file, for \"ttrpcstress decode\"")
+}
+
+// metricsFlag registers the -metrics flag, shared by the long-running commands.
+func metricsFlag(fs *flag.FlagSet) *string {
+	return fs.String("metrics", "", "Serve Prometheus metrics at /metrics on this HOST:PORT (e.g. :9090) while running")
+}
+
+// otlpFlag registers the -otlp-endpoint flag, shared by the client and server.
+func otlpFlag(fs *flag.FlagSet) *string {
+	return fs.String("otlp-endpoint", "", "Export a span for each traced call to the OpenTelemetry collector at this OTLP/HTTP URL (e.g. http://localhost:4318)")
+}
+
+// pprofFlag registers the -pprof flag, shared by the long-running commands.
+func pprofFlag(fs *flag.FlagSet) *string {
+	return fs.String("pprof", "", "Serve net/http/pprof on this HOST:PORT (e.g. :6060) while running, with mutex and block profiling enabled")
+}
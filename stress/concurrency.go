@@ -0,0 +1,75 @@
+package stress
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/containerd/ttrpc"
+)
+
+// handlerLimit bounds the number of unary handlers executing at once, as a
+// service with a fixed pool of workers would. Requests beyond the limit wait
+// for a free slot, so that a client sending faster than the handlers can keep
+// up sees sustained back-pressure, rather than every request being started at
+// once, which is the condition the known deadlocks need.
+//
+// A nil *handlerLimit limits nothing.
+type handlerLimit struct {
+	slots chan struct{}
+	// queued is the number of requests waiting for a slot, and peak the most
+	// that ever were at once. waited counts the requests that had to wait.
+	queued atomic.Int64
+	peak   atomic.Int64
+	waited atomic.Int64
+}
+
+func newHandlerLimit(n int) *handlerLimit {
+	if n <= 0 {
+		return nil
+	}
+	return &handlerLimit{slots: make(chan struct{}, n)}
+}
+
+// interceptor runs each unary handler once there is a slot free for it, or
+// fails the request if it is cancelled while waiting.
+func (h *handlerLimit) interceptor(ctx context.Context, unmarshal ttrpc.Unmarshaler, _ *ttrpc.UnaryServerInfo, method ttrpc.Method) (interface{}, error) {
+	select {
+	case h.slots <- struct{}{}:
+	default:
+		h.waited.Add(1)
+		h.markQueued(h.queued.Add(1))
+		select {
+		case h.slots <- struct{}{}:
+			h.queued.Add(-1)
+		case <-ctx.Done():
+			h.queued.Add(-1)
+			return nil, ctx.Err()
+		}
+	}
+	defer func() { <-h.slots }()
+	return method(ctx, unmarshal)
+}
+
+func (h *handlerLimit) markQueued(n int64) {
+	for {
+		peak := h.peak.Load()
+		if n <= peak || h.peak.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+// depth returns the number of requests waiting for a slot.
+func (h *handlerLimit) depth() int64 {
+	if h == nil {
+		return 0
+	}
+	return h.queued.Load()
+}
+
+func (h *handlerLimit) report() {
+	if h == nil {
+		return
+	}
+	infof("%d requests waited for one of %d handler slots, at most %d of them at once", h.waited.Load(), cap(h.slots), h.peak.Load())
+}
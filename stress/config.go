@@ -0,0 +1,80 @@
+package stress
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// configFlag registers the -config flag, shared by every command.
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", "", "Read flags from this JSON scenario file; flags given on the command line take precedence")
+}
+
+// applyConfig sets the flags of the named command from the scenario file at
+// path. A scenario file is a JSON object with a member for each command it
+// configures, holding that command's flags by name, so that one file can
+// describe both ends of a reproduction:
+//
+//	{
+//	  "server": {"addr": "npipe://./pipe/repro", "response-delay": "1ms"},
+//	  "client": {"addr": "npipe://./pipe/repro", "iters": 1000000, "workers": 100}
+//	}
+//
+// Values are strings, numbers, or booleans, as they would be written on the
+// command line; false leaves a boolean flag unset. Flags already set on the
+// command line are left as they are.
+func applyConfig(fs *flag.FlagSet, path, command string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var scenario map[string]map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// Decoding numbers as json.Number keeps them in the form they were written,
+	// so that integer flags do not see 1e+06.
+	dec.UseNumber()
+	if err := dec.Decode(&scenario); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	flags, ok := scenario[command]
+	if !ok {
+		return fmt.Errorf("%s has no %q section", path, command)
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: %s has no flag -%s", path, command, name)
+		}
+		if set[name] {
+			continue
+		}
+		var value string
+		switch v := flags[name].(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			if !v {
+				continue
+			}
+			value = "true"
+		default:
+			return fmt.Errorf("%s: -%s must be a string, number, or boolean", path, name)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: -%s: %w", path, name, err)
+		}
+	}
+	return nil
+}
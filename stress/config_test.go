@@ -0,0 +1,116 @@
+package stress
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyConfig(t *testing.T) {
+	type flags struct {
+		addr    string
+		iters   int
+		delay   time.Duration
+		verbose bool
+	}
+	defaults := flags{addr: "unix:///default", iters: 1000}
+	tests := []struct {
+		name     string
+		scenario string
+		args     []string
+		want     flags
+		wantErr  string
+	}{
+		{
+			name:     "from the file",
+			scenario: `{"client": {"addr": "npipe://./pipe/repro", "iters": 1000000, "delay": "1ms", "verbose": true}}`,
+			want:     flags{addr: "npipe://./pipe/repro", iters: 1000000, delay: time.Millisecond, verbose: true},
+		},
+		{
+			name:     "command line takes precedence",
+			scenario: `{"client": {"addr": "npipe://./pipe/repro", "iters": 1000000}}`,
+			args:     []string{"-iters", "5"},
+			want:     flags{addr: "npipe://./pipe/repro", iters: 5},
+		},
+		{
+			name:     "false leaves a boolean unset",
+			scenario: `{"client": {"verbose": false}}`,
+			args:     []string{"-verbose"},
+			want:     flags{addr: defaults.addr, iters: defaults.iters, verbose: true},
+		},
+		{
+			name:     "other sections ignored",
+			scenario: `{"server": {"addr": "tcp://:1"}, "client": {"iters": 2}}`,
+			want:     flags{addr: defaults.addr, iters: 2},
+		},
+		{
+			name:     "no section",
+			scenario: `{"server": {"addr": "tcp://:1"}}`,
+			wantErr:  `has no "client" section`,
+		},
+		{
+			name:     "unknown flag",
+			scenario: `{"client": {"workers": 10}}`,
+			wantErr:  "client has no flag -workers",
+		},
+		{
+			name:     "config in a config",
+			scenario: `{"client": {"config": "other.json"}}`,
+			wantErr:  "client has no flag -config",
+		},
+		{
+			name:     "bad type",
+			scenario: `{"client": {"iters": [1]}}`,
+			wantErr:  "-iters must be a string, number, or boolean",
+		},
+		{
+			name:     "bad value",
+			scenario: `{"client": {"delay": "soon"}}`,
+			wantErr:  "-delay: ",
+		},
+		{
+			name:     "not JSON",
+			scenario: `client: {}`,
+			wantErr:  "invalid character",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scenario.json")
+			if err := os.WriteFile(path, []byte(tt.scenario), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			var got flags
+			fs := flag.NewFlagSet("client", flag.ContinueOnError)
+			fs.StringVar(&got.addr, "addr", defaults.addr, "")
+			fs.IntVar(&got.iters, "iters", defaults.iters, "")
+			fs.DurationVar(&got.delay, "delay", defaults.delay, "")
+			fs.BoolVar(&got.verbose, "verbose", defaults.verbose, "")
+			configFlag(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			err := applyConfig(fs, path, "client")
+			switch {
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("applyConfig() = %v, want an error containing %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("applyConfig() = %v", err)
+			case got != tt.want:
+				t.Fatalf("flags = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigMissingFile(t *testing.T) {
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	if err := applyConfig(fs, filepath.Join(t.TempDir(), "missing.json"), "client"); !os.IsNotExist(err) {
+		t.Fatalf("applyConfig() = %v, want a not-exist error", err)
+	}
+}
@@ -0,0 +1,187 @@
+package stress
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// workerGate holds the workers of a pool numbered at or above its active
+// count, so that the number sending calls can be changed during a run by the
+// control socket. The pool is started at its largest; a nil gate holds none.
+type workerGate struct {
+	active atomic.Int64
+	size   int
+	mu     sync.Mutex
+	// changed is closed, and replaced, whenever active changes.
+	changed chan struct{}
+}
+
+func newWorkerGate(active, size int) *workerGate {
+	g := &workerGate{size: size, changed: make(chan struct{})}
+	g.active.Store(int64(active))
+	return g
+}
+
+// wait blocks worker w until it is among the active workers, and reports true,
+// or until stop or done is closed, and reports false.
+func (g *workerGate) wait(w int, stop, done <-chan struct{}) bool {
+	if g == nil {
+		return true
+	}
+	for {
+		g.mu.Lock()
+		changed := g.changed
+		g.mu.Unlock()
+		if int64(w) < g.active.Load() {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-stop:
+			return false
+		case <-done:
+			return false
+		}
+	}
+}
+
+// set changes the number of active workers to n, and returns the number
+// before.
+func (g *workerGate) set(n int) (int, error) {
+	if n < 0 || n > g.size {
+		return 0, fmt.Errorf("workers must be between 0 and %d (-max-workers), got %d", g.size, n)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	prev := g.active.Swap(int64(n))
+	close(g.changed)
+	g.changed = make(chan struct{})
+	return int(prev), nil
+}
+
+// serveControl accepts connections on the control socket at addr until ctx is
+// done, and serves the commands sent on them, one per line:
+//
+//	workers          report the number of active workers
+//	workers N        make N workers active
+//	workers +N, -N   make N more, or N fewer, workers active
+//
+// Each command is answered with a line of the number of active workers, or
+// one starting "error:".
+func serveControl(ctx context.Context, addr string, gate *workerGate, run *clientRun, tl *timeline) (func(), error) {
+	l, err := listen(addr, defaultPipeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("-control: %w", err)
+	}
+	infof("control socket listening on %s", addr)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				stop := context.AfterFunc(ctx, func() { conn.Close() })
+				defer stop()
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					fmt.Fprintln(conn, controlCommand(s.Text(), gate, run, tl))
+				}
+			}()
+		}
+	}()
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	return func() {
+		stop()
+		l.Close()
+		wg.Wait()
+	}, nil
+}
+
+// controlCommand runs a command sent on the control socket, and returns the
+// reply.
+func controlCommand(line string, gate *workerGate, run *clientRun, tl *timeline) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "workers" || len(fields) > 2 {
+		return fmt.Sprintf("error: unknown command %q; expected \"workers [N|+N|-N]\"", line)
+	}
+	if len(fields) == 2 {
+		arg := fields[1]
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Sprintf("error: bad worker count %q", arg)
+		}
+		if strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-") {
+			n += int(gate.active.Load())
+		}
+		prev, err := gate.set(n)
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		infof("control: workers %d -> %d, with %d calls in flight", prev, n, run.active.Load())
+		tl.record("client", "workers", "%d -> %d", prev, n)
+	}
+	return fmt.Sprintf("workers %d of %d; %d calls completed, %d in flight", gate.active.Load(), gate.size, run.completed.Load(), run.active.Load())
+}
+
+func scaleCommand(fs *flag.FlagSet) func(context.Context) error {
+	var (
+		addr    string
+		workers string
+		timeout time.Duration
+	)
+	fs.StringVar(&addr, "control", "", "Control socket of the client run to change, as given to its -control (required)")
+	fs.StringVar(&workers, "workers", "", "Number of workers to make active, or with a sign, how many more or fewer (e.g. 20, +5, -5; empty to report the number)")
+	fs.DurationVar(&timeout, "timeout", 10*time.Second, "Give up on the client answering after this long")
+	return func(ctx context.Context) error {
+		switch {
+		case addr == "":
+			return usageErrorf("-control is required")
+		case timeout <= 0:
+			return usageErrorf("-timeout must be positive, got %v", timeout)
+		}
+		if workers != "" {
+			if _, err := strconv.Atoi(workers); err != nil {
+				return usageErrorf("-workers must be a number, optionally signed, got %q", workers)
+			}
+		}
+		conn, err := dial(addr, timeout)
+		if err != nil {
+			return withExit(exitTransport, err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(timeout))
+		cmd := "workers"
+		if workers != "" {
+			cmd += " " + workers
+		}
+		if _, err := fmt.Fprintln(conn, cmd); err != nil {
+			return withExit(exitTransport, err)
+		}
+		reply, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return withExit(exitTransport, fmt.Errorf("reading the reply: %w", err))
+		}
+		reply = strings.TrimSpace(reply)
+		// The client refuses only commands it cannot carry out, such as a
+		// worker count out of range, which are the caller's to correct.
+		if msg, ok := strings.CutPrefix(reply, "error: "); ok {
+			return usageErrorf("%s", msg)
+		}
+		fmt.Println(reply)
+		return nil
+	}
+}
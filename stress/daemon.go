@@ -0,0 +1,173 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// detachedEnv is set in the environment of a server started by -detach, which
+// tells it not to detach again.
+const detachedEnv = "TTRPCSTRESS_DETACHED"
+
+// detachStartTimeout is how long -detach waits for the background server to
+// write its pidfile, which it does once it is listening.
+const detachStartTimeout = 30 * time.Second
+
+// stopPollInterval is how often the stop command, and -detach, check on the
+// server process.
+const stopPollInterval = 100 * time.Millisecond
+
+// detach starts the server again as a background process, detached from the
+// session so that it outlives it, with the same arguments and its output
+// appended to logPath. It returns once the server is listening, or has failed.
+func detach(pidfile, logPath string) error {
+	if pid, err := readPidfile(pidfile); err == nil && processRunning(pid) {
+		return fmt.Errorf("a server is already running as process %d, from %s", pid, pidfile)
+	}
+	os.Remove(pidfile)
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	logFile, err := openLogFile(logPath)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), detachedEnv+"=1")
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	ticker := time.NewTicker(stopPollInterval)
+	defer ticker.Stop()
+	timeout := time.After(detachStartTimeout)
+	for {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("the server exited while starting (%v); see %s", err, logPath)
+		case <-timeout:
+			cmd.Process.Kill()
+			return fmt.Errorf("the server did not start listening within %v; see %s", detachStartTimeout, logPath)
+		case <-ticker.C:
+		}
+		if pid, err := readPidfile(pidfile); err == nil && pid == cmd.Process.Pid {
+			infof("server running in the background as process %d, logging to %s; stop it with \"ttrpcstress stop -pidfile %s\"", pid, logPath, pidfile)
+			return cmd.Process.Release()
+		}
+	}
+}
+
+// redirectLog sends the log, and anything else written to stdout or stderr
+// from here on, to the file at path, for a server with no console to write to.
+func redirectLog(path string) (restore func(), err error) {
+	f, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	log.SetOutput(f)
+	os.Stdout, os.Stderr = f, f
+	return func() {
+		log.SetOutput(stderr)
+		os.Stdout, os.Stderr = stdout, stderr
+		f.Close()
+	}, nil
+}
+
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+}
+
+// writePidfile records this process's ID in path, for the stop command, and
+// returns a function to remove it again. It fails if path names a process that
+// is still running.
+func writePidfile(path string) (remove func(), err error) {
+	if pid, err := readPidfile(path); err == nil && pid != os.Getpid() && processRunning(pid) {
+		return nil, fmt.Errorf("a server is already running as process %d, from %s", pid, path)
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return nil, err
+	}
+	return func() {
+		// A server started since, with the same pidfile, owns it now.
+		if pid, err := readPidfile(path); err == nil && pid == os.Getpid() {
+			os.Remove(path)
+		}
+	}, nil
+}
+
+func readPidfile(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("%s does not hold a process ID", path)
+	}
+	return pid, nil
+}
+
+func stopCommand(fs *flag.FlagSet) func(context.Context) error {
+	var (
+		pidfile string
+		timeout time.Duration
+	)
+	fs.StringVar(&pidfile, "pidfile", "", "Pidfile written by the server's -pidfile (required)")
+	fs.DurationVar(&timeout, "timeout", 30*time.Second, "Fail if the server has not exited within this long; allow for its -drain-timeout")
+	return func(ctx context.Context) error {
+		switch {
+		case pidfile == "":
+			return usageErrorf("-pidfile is required")
+		case timeout <= 0:
+			return usageErrorf("-timeout must be positive, got %v", timeout)
+		}
+		return stopPidfile(ctx, pidfile, timeout)
+	}
+}
+
+// stopPidfile asks the server whose process ID is in pidfile to shut down, as
+// it does on SIGINT, and waits up to timeout for it to exit.
+func stopPidfile(ctx context.Context, pidfile string, timeout time.Duration) error {
+	pid, err := readPidfile(pidfile)
+	if err != nil {
+		return err
+	}
+	if !processRunning(pid) {
+		os.Remove(pidfile)
+		return fmt.Errorf("process %d, from %s, is not running", pid, pidfile)
+	}
+	if err := requestStop(pid); err != nil {
+		return fmt.Errorf("stopping process %d: %w", pid, err)
+	}
+	infof("asked server process %d to stop", pid)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(stopPollInterval)
+	defer ticker.Stop()
+	for processRunning(pid) {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("process %d is still running after %v", pid, timeout)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	infof("server process %d stopped", pid)
+	return nil
+}
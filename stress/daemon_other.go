@@ -0,0 +1,34 @@
+//go:build !windows
+
+package stress
+
+import (
+	"context"
+	"errors"
+	"syscall"
+)
+
+// detachedProcAttr starts a -detach server in a session of its own, so that it
+// has no controlling terminal to be hung up with.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// listenForStop returns ctx unchanged: the stop command sends SIGTERM, which
+// the server already shuts down on.
+func listenForStop(ctx context.Context) (context.Context, func(), error) {
+	return ctx, func() {}, nil
+}
+
+func requestStop(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
+
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func runService(context.Context, string, func(context.Context) error) error {
+	return errors.New("running as a service is only supported on Windows")
+}
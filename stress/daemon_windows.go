@@ -0,0 +1,139 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+)
+
+// detachedProcAttr starts a -detach server with no console, so that closing
+// the one it was started from does not end it.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP,
+		HideWindow:    true,
+	}
+}
+
+// stopEventName names the event the stop command sets to stop the server with
+// process ID pid. There is no signal to send a process with no console.
+func stopEventName(pid int) string {
+	return fmt.Sprintf(`Local\ttrpcstress-stop-%d`, pid)
+}
+
+// listenForStop returns a context cancelled when the stop command asks this
+// process to stop, and a function to stop listening.
+func listenForStop(ctx context.Context) (context.Context, func(), error) {
+	name, err := windows.UTF16PtrFromString(stopEventName(int(windows.GetCurrentProcessId())))
+	if err != nil {
+		return nil, nil, err
+	}
+	event, err := windows.CreateEvent(nil, 1, 0, name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating stop event: %w", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer windows.CloseHandle(event)
+		windows.WaitForSingleObject(event, windows.INFINITE)
+		select {
+		case <-done:
+		default:
+			infof("stop requested")
+			cancel()
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		windows.SetEvent(event)
+		cancel()
+	}, nil
+}
+
+func requestStop(pid int) error {
+	name, err := windows.UTF16PtrFromString(stopEventName(pid))
+	if err != nil {
+		return err
+	}
+	event, err := windows.OpenEvent(windows.EVENT_MODIFY_STATE, false, name)
+	if err != nil {
+		return fmt.Errorf("opening stop event (was it started with -pidfile?): %w", err)
+	}
+	defer windows.CloseHandle(event)
+	return windows.SetEvent(event)
+}
+
+// stillActive is the exit code GetExitCodeProcess reports for a process that
+// is still running.
+const stillActive = 259
+
+func processRunning(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	return windows.GetExitCodeProcess(h, &code) == nil && code == stillActive
+}
+
+// runService runs the server as the Windows service name, reporting its state
+// to the service control manager, which stops it as SIGINT would.
+func runService(ctx context.Context, name string, run func(context.Context) error) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return usageErrorf("-service %s is for the service control manager to start; install it with \"sc.exe create %s binPath= ...\"", name, name)
+	}
+	s := &service{ctx: ctx, run: run}
+	if err := svc.Run(name, s); err != nil {
+		return err
+	}
+	return s.err
+}
+
+// service is the svc.Handler of a server run as a Windows service.
+type service struct {
+	ctx context.Context
+	run func(context.Context) error
+	err error
+}
+
+// servicePendingHint is the time the service control manager is told to allow
+// between status updates for the service to start or stop.
+const servicePendingHint = 30 * time.Second
+
+func (s *service) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending, WaitHint: uint32(servicePendingHint.Milliseconds())}
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.run(ctx) }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case s.err = <-done:
+			status <- svc.Status{State: svc.StopPending}
+			if s.err != nil {
+				return true, uint32(exitCode(s.err))
+			}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				infof("service stop requested")
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(servicePendingHint.Milliseconds())}
+				cancel()
+			}
+		}
+	}
+}
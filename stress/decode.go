@@ -0,0 +1,256 @@
+package stress
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func decodeCommand(fs *flag.FlagSet) func(context.Context) error {
+	var path, conn string
+	fs.StringVar(&path, "capture", "", "Capture file written by a client or server with -capture")
+	fs.StringVar(&conn, "conn", "", "Only decode the frames of this connection, such as \"conn-1\"")
+	return func(context.Context) error {
+		if path == "" {
+			return usageErrorf("-capture is required")
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w := bufio.NewWriter(os.Stdout)
+		defer w.Flush()
+		return decodeCapture(w, f, conn)
+	}
+}
+
+// decodeDirection keys the byte stream in one direction of one connection.
+type decodeDirection struct {
+	conn string
+	dir  byte
+}
+
+// decodeRequest is a request seen in a capture, for reporting those left
+// without a response.
+type decodeRequest struct {
+	time   time.Time
+	method string
+}
+
+// decodeCapture writes a line to w for each TTRPC frame in the capture read
+// from r, reassembled from the reads and writes of each connection, and then
+// lists any frames left incomplete and requests left without a response, which
+// are what a hung call looks like on the wire. If conn is set, only that
+// connection is decoded.
+func decodeCapture(w io.Writer, r io.Reader, conn string) error {
+	cr, err := newCaptureReader(r)
+	if err != nil {
+		return err
+	}
+	var (
+		start    time.Time
+		scanners = make(map[decodeDirection]*frameScanner)
+		pending  = make(map[string]map[uint32]decodeRequest)
+		frames   int
+	)
+	for {
+		rec, err := cr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			warnf("capture ends partway through a record, as if its writer was killed")
+			break
+		}
+		if start.IsZero() {
+			start = rec.time
+		}
+		if conn != "" && rec.name != conn {
+			continue
+		}
+		prefix := fmt.Sprintf("%s +%-12v %-10s", rec.time.Format(time.RFC3339Nano), rec.time.Sub(start).Round(time.Microsecond), rec.name)
+		if rec.dir == captureClosed {
+			fmt.Fprintf(w, "%s closed\n", prefix)
+			continue
+		}
+		key := decodeDirection{rec.name, rec.dir}
+		s := scanners[key]
+		if s == nil {
+			s = &frameScanner{}
+			scanners[key] = s
+		}
+		s.scan(rec.data, func(_ int, h frameHeader, frame []byte) {
+			frames++
+			fmt.Fprintf(w, "%s %s stream %-6d %s\n", prefix, directionName(rec.dir), h.streamID, describeFrame(h, frame[frameHeaderLength:]))
+			switch h.typ {
+			case messageTypeRequest:
+				if pending[rec.name] == nil {
+					pending[rec.name] = make(map[uint32]decodeRequest)
+				}
+				method, _ := requestMethod(frame[frameHeaderLength:])
+				pending[rec.name][h.streamID] = decodeRequest{rec.time, method}
+			case messageTypeResponse:
+				delete(pending[rec.name], h.streamID)
+			}
+		})
+	}
+	fmt.Fprintf(w, "%d frames\n", frames)
+
+	keys := make([]decodeDirection, 0, len(scanners))
+	for k := range scanners {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].conn != keys[j].conn {
+			return keys[i].conn < keys[j].conn
+		}
+		return keys[i].dir < keys[j].dir
+	})
+	for _, k := range keys {
+		buf := scanners[k].buf
+		if len(buf) == 0 {
+			continue
+		}
+		if len(buf) < frameHeaderLength {
+			fmt.Fprintf(w, "%s %s: %d bytes of an incomplete frame header at the end\n", k.conn, directionName(k.dir), len(buf))
+			continue
+		}
+		h := parseFrameHeader(buf)
+		fmt.Fprintf(w, "%s %s: incomplete frame at the end, stream %d type %d, %d of %d bytes\n",
+			k.conn, directionName(k.dir), h.streamID, h.typ, len(buf)-frameHeaderLength, h.length)
+	}
+
+	conns := make([]string, 0, len(pending))
+	for c := range pending {
+		conns = append(conns, c)
+	}
+	sort.Strings(conns)
+	for _, c := range conns {
+		ids := make([]uint32, 0, len(pending[c]))
+		for id := range pending[c] {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			req := pending[c][id]
+			fmt.Fprintf(w, "%s: no response to stream %d %s, requested at +%v\n", c, id, req.method, req.time.Sub(start).Round(time.Microsecond))
+		}
+	}
+	return nil
+}
+
+func directionName(dir byte) string {
+	switch dir {
+	case captureSent:
+		return "sent"
+	case captureReceived:
+		return "recv"
+	}
+	return fmt.Sprintf("%q", dir)
+}
+
+// describeFrame describes a frame with header h and data b, decoding the
+// request or response it carries.
+func describeFrame(h frameHeader, b []byte) string {
+	var sb strings.Builder
+	switch h.typ {
+	case messageTypeRequest:
+		sb.WriteString("request  ")
+		sb.WriteString(describeRequest(b))
+	case messageTypeResponse:
+		sb.WriteString("response ")
+		sb.WriteString(describeResponse(b))
+	case messageTypeData:
+		sb.WriteString("data     ")
+		if len(b) > 0 {
+			sb.WriteString(describePayload(b))
+		} else {
+			sb.WriteString("empty")
+		}
+	default:
+		fmt.Fprintf(&sb, "type %d, %d bytes", h.typ, len(b))
+	}
+	if h.flags != 0 {
+		fmt.Fprintf(&sb, " flags %#x", h.flags)
+	}
+	return sb.String()
+}
+
+// requestMethod returns the full method name a ttrpc Request is for.
+func requestMethod(b []byte) (string, error) {
+	var service, method string
+	err := consumeFields(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			service = string(v)
+		case 2:
+			method = string(v)
+		}
+		return nil
+	})
+	return "/" + service + "/" + method, err
+}
+
+// describeRequest decodes a ttrpc Request: service (1), method (2), payload (3),
+// timeout_nano (4), and metadata (5).
+func describeRequest(b []byte) string {
+	method, err := requestMethod(b)
+	if err != nil {
+		return fmt.Sprintf("undecodable, %d bytes: %s", len(b), err)
+	}
+	var (
+		payload  []byte
+		timeout  time.Duration
+		metadata int
+	)
+	consumeFields(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 3:
+			payload = v
+		case 4:
+			t, _ := protowire.ConsumeVarint(v)
+			timeout = time.Duration(t)
+		case 5:
+			metadata++
+		}
+		return nil
+	})
+	s := method + " " + describePayload(payload)
+	if timeout > 0 {
+		s += fmt.Sprintf(", timeout %v", timeout)
+	}
+	if metadata > 0 {
+		s += fmt.Sprintf(", %d metadata", metadata)
+	}
+	return s
+}
+
+// describeResponse decodes a ttrpc Response: status (1) and payload (2).
+func describeResponse(b []byte) string {
+	st, payload, err := parseResponse(b)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("undecodable, %d bytes: %s", len(b), err)
+	case st != nil:
+		return fmt.Sprintf("%s: %s", st.code, st.message)
+	}
+	return "OK " + describePayload(payload)
+}
+
+// describePayload decodes a payload, with the variant of this build.
+func describePayload(b []byte) string {
+	f, err := payloadCodecs[payloadVariant].unmarshal(b)
+	if err != nil {
+		return fmt.Sprintf("payload of %d bytes, undecodable: %s", len(b), err)
+	}
+	return fmt.Sprintf("payload value %d, %d bytes of data, checksum %#x", f.value, len(f.data), f.checksum)
+}
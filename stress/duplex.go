@@ -0,0 +1,148 @@
+package stress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// duplexRequest is carried, as JSON, in the data of a methodDuplex request. It
+// describes the workload the server sends back.
+type duplexRequest struct {
+	Addr         string        `json:"addr"`
+	Workers      int           `json:"workers"`
+	Iters        int           `json:"iters"`
+	Duration     time.Duration `json:"duration"`
+	PayloadBytes int           `json:"payload_bytes"`
+}
+
+// duplexHandler runs the workload of a methodDuplex request against the
+// client's -duplex address, and answers with the number of calls that
+// succeeded, or an error if the workload failed.
+func duplexHandler(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+	req := &payload{}
+	if err := unmarshal(req); err != nil {
+		return nil, err
+	}
+	var dr duplexRequest
+	if err := json.Unmarshal(req.Data, &dr); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "decoding %s request: %s", methodDuplex, err)
+	}
+	infof("duplex: calling back to %s with %d workers", dr.Addr, dr.Workers)
+	res, err := RunClient(ctx, ClientOptions{
+		Addr:         dr.Addr,
+		Workers:      dr.Workers,
+		Iters:        dr.Iters,
+		Duration:     dr.Duration,
+		PayloadBytes: dr.PayloadBytes,
+	})
+	if err != nil {
+		errorf("duplex: calls back to %s failed: %s", dr.Addr, err)
+		return nil, status.Errorf(codes.Internal, "calls back to %s: %s", dr.Addr, err)
+	}
+	infof("duplex: %d calls back to %s succeeded", res.Succeeded, dr.Addr)
+	return &payload{Value: uint32(min(res.Succeeded, 1<<32-1))}, nil
+}
+
+// duplexRun is the client's half of a duplex run: a server on the client's
+// -duplex address, and the methodDuplex call that has the server send its
+// workload there.
+type duplexRun struct {
+	addr     string
+	done     chan error
+	calls    uint32
+	cancel   context.CancelFunc
+	stopBack func() error
+}
+
+// startDuplex serves on cfg.duplex and asks the server, over the first of
+// run's connections, to call back to it with a workload like the client's
+// own, so that requests flow both ways at once.
+func startDuplex(ctx context.Context, cfg clientConfig, run *clientRun, tl *timeline) (*duplexRun, error) {
+	l, err := listen(cfg.duplex, defaultPipeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("-duplex: %w", err)
+	}
+	// A TCP port of 0 is chosen on listening, and the server is told the one
+	// chosen.
+	addr := cfg.duplex
+	if l.Addr().Network() == "tcp" {
+		addr = "tcp://" + l.Addr().String()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	backErr := make(chan error, 1)
+	go func() {
+		scfg := ServerOptions{}.config(cfg.duplex)
+		scfg.tl = tl
+		backErr <- serve(ctx, l, scfg)
+	}()
+	d := &duplexRun{
+		addr:   addr,
+		done:   make(chan error, 1),
+		cancel: cancel,
+		stopBack: func() error {
+			cancel()
+			err := <-backErr
+			l.Close()
+			return err
+		},
+	}
+	data, err := json.Marshal(duplexRequest{
+		Addr:         addr,
+		Workers:      cfg.workers,
+		Iters:        cfg.iters,
+		Duration:     cfg.duration,
+		PayloadBytes: cfg.payloadBytes,
+	})
+	if err != nil {
+		d.stopBack()
+		return nil, err
+	}
+	tl.record("client", "duplex", "asking for calls back to %s", addr)
+	go func() {
+		resp := &payload{}
+		err := run.call(ctx, 0, methodDuplex, &payload{Data: data}, resp)
+		d.calls = resp.Value
+		d.done <- err
+	}()
+	return d, nil
+}
+
+// abandon gives up on the server's calls back, for a run that has already
+// failed, and stops serving them.
+func (d *duplexRun) abandon() {
+	d.cancel()
+	<-d.done
+	d.stopBack()
+}
+
+// wait waits for the server's calls back to finish, and then stops serving
+// them. It fails if they did, or if the server could not make them.
+func (d *duplexRun) wait(ctx context.Context) error {
+	var err error
+	select {
+	case err = <-d.done:
+	default:
+		infof("duplex: waiting for the server's calls back to %s to finish", d.addr)
+		select {
+		case err = <-d.done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if backErr := d.stopBack(); err == nil {
+		err = backErr
+	}
+	switch {
+	case status.Code(err) == codes.Unimplemented:
+		return fmt.Errorf("the server does not support -duplex: %w", err)
+	case err != nil:
+		return withExit(exitCall, fmt.Errorf("duplex: %w", err))
+	}
+	infof("duplex: the server's %d calls back to %s succeeded", d.calls, d.addr)
+	return nil
+}
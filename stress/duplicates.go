@@ -0,0 +1,98 @@
+package stress
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// duplicatingConn wraps a server connection, occasionally sending a second copy of
+// a response frame immediately after the original. This tests how the client's
+// demux handles extra, unexpected responses. Which responses are duplicated is
+// chosen by stream ID, with a seed that differs for each connection.
+type duplicatingConn struct {
+	net.Conn
+	rate    float64
+	seed    uint64
+	mu      sync.Mutex
+	scanner frameScanner
+}
+
+func (c *duplicatingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var (
+		prev int
+		err  error
+	)
+	c.scanner.scan(b, func(off int, h frameHeader, frame []byte) {
+		if err != nil || h.typ != messageTypeResponse || !randomChance(c.seed, drawDuplicate, h.streamID, c.rate) {
+			return
+		}
+		dup := append([]byte(nil), frame...)
+		if _, err = c.Conn.Write(b[prev:off]); err != nil {
+			return
+		}
+		prev = off
+		if _, err = c.Conn.Write(dup); err != nil {
+			return
+		}
+		debugf("sent duplicate response for stream %d", h.streamID)
+	})
+	if err != nil {
+		return prev, err
+	}
+	if _, err := c.Conn.Write(b[prev:]); err != nil {
+		return prev, err
+	}
+	return len(b), nil
+}
+
+// duplicatingListener wraps each accepted connection in a duplicatingConn.
+type duplicatingListener struct {
+	net.Listener
+	rate  float64
+	seed  uint64
+	conns atomic.Uint32
+}
+
+func (l *duplicatingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &duplicatingConn{Conn: c, rate: l.rate, seed: l.seed ^ uint64(l.conns.Add(1))<<32}, nil
+}
+
+// duplicateDetector wraps a client connection, watching incoming frames for more
+// than one response on the same stream. TTRPC clients never reuse stream IDs on a
+// connection, so any repeat is a duplicate.
+type duplicateDetector struct {
+	net.Conn
+	mu         sync.Mutex
+	scanner    frameScanner
+	seen       map[uint32]struct{}
+	duplicates atomic.Int64
+}
+
+func newDuplicateDetector(c net.Conn) *duplicateDetector {
+	return &duplicateDetector{Conn: c, seen: make(map[uint32]struct{})}
+}
+
+func (c *duplicateDetector) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	c.scanner.scan(b[:n], func(_ int, h frameHeader, _ []byte) {
+		if h.typ != messageTypeResponse {
+			return
+		}
+		if _, ok := c.seen[h.streamID]; ok {
+			c.duplicates.Add(1)
+			warnf("duplicate response received for stream %d", h.streamID)
+			return
+		}
+		c.seen[h.streamID] = struct{}{}
+	})
+	c.mu.Unlock()
+	return n, err
+}
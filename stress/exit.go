@@ -0,0 +1,102 @@
+package stress
+
+import (
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Exit statuses, so that scripts bisecting ttrpc versions can tell a known
+// deadlock firing apart from the harness itself breaking.
+const (
+	// exitFailure is used for any failure not covered below, such as failing
+	// to set up the run.
+	exitFailure = 1
+	// exitUsage is used for invalid flags or arguments.
+	exitUsage = 2
+	// exitStalled is used when calls time out, or the watchdog reports a stall.
+	exitStalled = 3
+	// exitMismatch is used when a response fails verification.
+	exitMismatch = 4
+	// exitTransport is used when a connection cannot be made, or drops.
+	exitTransport = 5
+	// exitCall is used when the server fails a call with an error status.
+	exitCall = 6
+	// exitLeak is used when -leak-check finds resources left behind.
+	exitLeak = 7
+	// exitRegression is used when throughput falls below a -baseline.
+	exitRegression = 8
+)
+
+// exitReason returns a short name for an exit status, for machine-readable
+// results.
+func exitReason(code int) string {
+	switch code {
+	case 0:
+		return "ok"
+	case exitUsage:
+		return "usage"
+	case exitStalled:
+		return "stalled"
+	case exitMismatch:
+		return "mismatch"
+	case exitTransport:
+		return "transport"
+	case exitCall:
+		return "call"
+	case exitLeak:
+		return "leak"
+	case exitRegression:
+		return "regression"
+	}
+	return "failure"
+}
+
+// exitError annotates an error with the exit status it should produce.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+func withExit(code int, err error) error {
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit status for an error returned by a command.
+func exitCode(err error) int {
+	var uerr usageError
+	if errors.As(err, &uerr) {
+		return exitUsage
+	}
+	var eerr *exitError
+	if errors.As(err, &eerr) {
+		return eerr.code
+	}
+	return exitFailure
+}
+
+// callExit returns the exit status for an error returned by a call. Errors
+// from the server are classified by status code, and any other error is taken
+// to be from the connection itself.
+func callExit(err error) int {
+	var eerr *exitError
+	if errors.As(err, &eerr) {
+		return eerr.code
+	}
+	if isTimeout(err) {
+		return exitStalled
+	}
+	if s, ok := status.FromError(err); ok {
+		// The server reports request data that fails its checksum as DataLoss.
+		if s.Code() == codes.DataLoss {
+			return exitMismatch
+		}
+		return exitCall
+	}
+	return exitTransport
+}
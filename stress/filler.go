@@ -0,0 +1,43 @@
+package stress
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"math/rand"
+)
+
+// filler returns n bytes of payload filler. The content is a repeating pattern
+// rather than zeroes so that corruption or misplaced data is recognisable.
+func filler(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+// randomFiller returns n bytes of pseudo-random payload filler from seed. Unlike
+// filler, no two offsets in it are related, so data shifted or spliced from
+// elsewhere in a message does not match by chance.
+func randomFiller(n int, seed uint64) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(seed))).Read(b)
+	return b
+}
+
+// checksum returns the checksum carried alongside data in a payload.
+func checksum(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data)
+}
+
+// dataHash returns the hash carried alongside data in a payload whose request
+// had sequence number seq. Covering seq binds the data to its request.
+func dataHash(seq uint64, data []byte) uint64 {
+	h := fnv.New64a()
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], seq)
+	h.Write(b[:])
+	h.Write(data)
+	return h.Sum64()
+}
@@ -0,0 +1,117 @@
+package stress
+
+import (
+	"context"
+	"flag"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// footprintSampler periodically samples heap usage and goroutine count,
+// recording the peak of each.
+type footprintSampler struct {
+	peakHeap       uint64
+	peakGoroutines int
+	stop           chan struct{}
+	done           sync.WaitGroup
+}
+
+func startFootprintSampler(interval time.Duration) *footprintSampler {
+	s := &footprintSampler{stop: make(chan struct{})}
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.sample()
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return s
+}
+
+func (s *footprintSampler) sample() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if ms.HeapInuse > s.peakHeap {
+		s.peakHeap = ms.HeapInuse
+	}
+	if n := runtime.NumGoroutine(); n > s.peakGoroutines {
+		s.peakGoroutines = n
+	}
+}
+
+func (s *footprintSampler) finish() {
+	close(s.stop)
+	s.done.Wait()
+}
+
+func footprintCommand(fs *flag.FlagSet) func(context.Context) error {
+	iters := fs.Int("iters", 100000, "Number of requests to send under each dispatch model")
+	workers := fs.Int("workers", 100, "Number of workers in the worker pool")
+	timelinePath := timelineFlag(fs)
+	return func(ctx context.Context) error {
+		if *iters < 0 {
+			return usageErrorf("-iters must not be negative, got %d", *iters)
+		}
+		if *workers < 1 {
+			return usageErrorf("-workers must be at least 1, got %d", *workers)
+		}
+		tl, err := newTimeline(*timelinePath)
+		if err != nil {
+			return err
+		}
+		defer tl.close()
+		return runFootprint(ctx, *iters, *workers, tl)
+	}
+}
+
+// runFootprint runs the same workload against an in-process server under both
+// dispatch models, a fixed pool of workers and a goroutine per call, and reports
+// peak heap and goroutine counts for each. This quantifies the memory cost of
+// unbounded goroutine-per-call dispatch at high concurrency.
+func runFootprint(ctx context.Context, iters int, workers int, tl *timeline) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	l := newInprocListener()
+	defer l.Close()
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- serve(ctx, l, serverConfig{addr: "inproc", tl: tl}) }()
+
+	defer quietRequests()()
+
+	for _, perCall := range []bool{false, true} {
+		name := "worker pool"
+		if perCall {
+			name = "goroutine per call"
+		}
+		cfg := clientConfig{
+			addr:             "inproc",
+			dial:             l.dial,
+			iters:            iters,
+			workers:          workers,
+			goroutinePerCall: perCall,
+			tl:               tl,
+		}
+		runtime.GC()
+		s := startFootprintSampler(10 * time.Millisecond)
+		start := time.Now()
+		err := runClient(ctx, cfg)
+		elapsed := time.Since(start)
+		s.finish()
+		if err != nil {
+			return err
+		}
+		infof("%-18s: elapsed time %v, peak heap %.1f MiB, peak goroutines %d",
+			name, elapsed, float64(s.peakHeap)/(1<<20), s.peakGoroutines)
+	}
+	cancel()
+	return <-serverErr
+}
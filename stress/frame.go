@@ -0,0 +1,69 @@
+package stress
+
+import "encoding/binary"
+
+// TTRPC wire framing, as described in the ttrpc PROTOCOL.md. Each frame is a
+// 10-byte header (length, stream ID, message type, flags) followed by length
+// bytes of data.
+const frameHeaderLength = 10
+
+const (
+	messageTypeRequest  = 0x1
+	messageTypeResponse = 0x2
+	messageTypeData     = 0x3
+)
+
+type frameHeader struct {
+	length   uint32
+	streamID uint32
+	typ      byte
+	flags    byte
+}
+
+// appendFrame appends to b a frame of type typ on stream id, carrying body.
+func appendFrame(b []byte, id uint32, typ, flags byte, body []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(body)))
+	b = binary.BigEndian.AppendUint32(b, id)
+	b = append(b, typ, flags)
+	return append(b, body...)
+}
+
+func parseFrameHeader(b []byte) frameHeader {
+	return frameHeader{
+		length:   binary.BigEndian.Uint32(b[:4]),
+		streamID: binary.BigEndian.Uint32(b[4:8]),
+		typ:      b[8],
+		flags:    b[9],
+	}
+}
+
+// frameScanner splits a byte stream, fed in arbitrary chunks, into TTRPC frames.
+type frameScanner struct {
+	buf []byte
+}
+
+// scan consumes b, calling fn for each frame completed within it. off is the
+// offset in b just past the end of the frame, and frame holds the complete frame
+// (header included). frame is only valid for the duration of the call.
+func (s *frameScanner) scan(b []byte, fn func(off int, h frameHeader, frame []byte)) {
+	for off := 0; off < len(b); {
+		want := frameHeaderLength
+		if len(s.buf) >= frameHeaderLength {
+			want += int(binary.BigEndian.Uint32(s.buf[:4]))
+		}
+		n := want - len(s.buf)
+		if n > len(b)-off {
+			n = len(b) - off
+		}
+		s.buf = append(s.buf, b[off:off+n]...)
+		off += n
+		if len(s.buf) < frameHeaderLength {
+			continue
+		}
+		h := parseFrameHeader(s.buf)
+		if len(s.buf) == frameHeaderLength+int(h.length) {
+			fn(off, h, s.buf)
+			s.buf = s.buf[:0]
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package stress
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestFrameScanner(t *testing.T) {
+	type frame struct {
+		end    int
+		header frameHeader
+		data   []byte
+	}
+	var (
+		stream []byte
+		want   []frame
+	)
+	for _, f := range []struct {
+		id         uint32
+		typ, flags byte
+		body       []byte
+	}{
+		{1, messageTypeRequest, 0, []byte("request")},
+		{1, messageTypeResponse, 0, nil},
+		{3, messageTypeData, 0x1, bytes.Repeat([]byte{0xab}, 300)},
+		{5, messageTypeRequest, 0x2, []byte{0}},
+	} {
+		stream = appendFrame(stream, f.id, f.typ, f.flags, f.body)
+		want = append(want, frame{
+			end:    len(stream),
+			header: frameHeader{length: uint32(len(f.body)), streamID: f.id, typ: f.typ, flags: f.flags},
+			data:   stream[len(stream)-frameHeaderLength-len(f.body):],
+		})
+	}
+	for _, chunk := range []int{1, 3, frameHeaderLength - 1, frameHeaderLength, frameHeaderLength + 1, 64, len(stream)} {
+		t.Run(fmt.Sprintf("chunks of %d", chunk), func(t *testing.T) {
+			var (
+				s   frameScanner
+				got []frame
+			)
+			for start := 0; start < len(stream); start += chunk {
+				b := stream[start:min(start+chunk, len(stream))]
+				s.scan(b, func(off int, h frameHeader, data []byte) {
+					got = append(got, frame{start + off, h, bytes.Clone(data)})
+				})
+			}
+			if len(got) != len(want) {
+				t.Fatalf("scanned %d frames, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i].end != want[i].end || got[i].header != want[i].header {
+					t.Errorf("frame %d: ends at %d with header %+v, want %d with %+v", i, got[i].end, got[i].header, want[i].end, want[i].header)
+				}
+				if !bytes.Equal(got[i].data, want[i].data) {
+					t.Errorf("frame %d: data %x, want %x", i, got[i].data, want[i].data)
+				}
+			}
+		})
+	}
+}
+
+func TestFrameScannerIncomplete(t *testing.T) {
+	stream := appendFrame(nil, 1, messageTypeRequest, 0, []byte("request"))
+	var s frameScanner
+	s.scan(stream[:len(stream)-1], func(int, frameHeader, []byte) {
+		t.Fatal("scanned a frame missing its last byte")
+	})
+	if len(s.buf) != len(stream)-1 {
+		t.Errorf("buffered %d bytes, want %d", len(s.buf), len(stream)-1)
+	}
+}
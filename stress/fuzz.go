@@ -0,0 +1,401 @@
+package stress
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+)
+
+func fuzzCommand(fs *flag.FlagSet) func(context.Context) error {
+	var (
+		cfg            fuzzConfig
+		loopback       bool
+		loopbackVia    string
+		connectTimeout time.Duration
+		skip           string
+	)
+	fs.StringVar(&cfg.addr, "addr", "", "Address of a server with default response settings to connect to (tcp://HOST:PORT, unix://PATH, npipe://./pipe/NAME, hvsock://VMID:SERVICE, or vsock://CID:PORT; required unless -loopback)")
+	fs.DurationVar(&connectTimeout, "connect-timeout", 10*time.Second, "Give up on connecting after this long (0 to wait as long as the OS allows)")
+	fs.BoolVar(&loopback, "loopback", false, "Run against a server started in this process, rather than connecting to -addr; a case that panics the server ends the run with the panic")
+	fs.StringVar(&loopbackVia, "loopback-transport", "inproc", "Transport for -loopback: \"inproc\", \"tcp\", \"unix\", or on Windows \"npipe\", each on a private address")
+	fs.IntVar(&cfg.random, "random", 50, "Number of randomly mangled frames to send after the fixed cases")
+	fs.Uint64Var(&cfg.seed, "seed", 0, "Seed for the -random frames, to repeat those of an earlier run (0 to pick one, which is logged)")
+	fs.StringVar(&skip, "skip", "", "Comma-separated names of fixed cases not to send, such as one already known to crash the server")
+	fs.DurationVar(&cfg.timeout, "timeout", time.Second, "How long to wait for the server to answer a probe call, or to react to a frame it was left waiting on the rest of")
+	timelinePath := timelineFlag(fs)
+	return func(ctx context.Context) error {
+		switch {
+		case cfg.addr == "" && !loopback:
+			return usageErrorf("-addr or -loopback is required")
+		case cfg.addr != "" && loopback:
+			return usageErrorf("-addr cannot be combined with -loopback")
+		case loopbackVia != "inproc" && !loopback:
+			return usageErrorf("-loopback-transport requires -loopback")
+		case cfg.random < 0:
+			return usageErrorf("-random must not be negative, got %d", cfg.random)
+		case cfg.timeout <= 0:
+			return usageErrorf("-timeout must be positive, got %v", cfg.timeout)
+		case connectTimeout < 0:
+			return usageErrorf("-connect-timeout must not be negative, got %v", connectTimeout)
+		}
+		cfg.skip = map[string]bool{}
+		for _, name := range splitList(skip) {
+			if !isFuzzCase(name) {
+				return usageErrorf("-skip: no case named %q", name)
+			}
+			cfg.skip[name] = true
+		}
+		tl, err := newTimeline(*timelinePath)
+		if err != nil {
+			return err
+		}
+		defer tl.close()
+		cfg.tl = tl
+		stopServer := func() error { return nil }
+		if loopback {
+			cfg.dial, stopServer, err = startLoopback(ctx, loopbackVia, protocolTTRPC, tl)
+			if err != nil {
+				return err
+			}
+		} else {
+			addr := cfg.addr
+			cfg.dial = func() (net.Conn, error) { return dial(addr, connectTimeout) }
+		}
+		err = runFuzz(ctx, cfg)
+		if serverErr := stopServer(); err == nil {
+			err = serverErr
+		}
+		return err
+	}
+}
+
+// fuzzConfig holds the settings for a fuzz run.
+type fuzzConfig struct {
+	addr string
+	dial func() (net.Conn, error)
+	// random is the number of mangled frames to send, chosen by seed.
+	random int
+	seed   uint64
+	// skip holds the names of fixed cases not to send.
+	skip    map[string]bool
+	timeout time.Duration
+	tl      *timeline
+}
+
+// fuzzCase is a sequence of bytes that a well-behaved ttrpc client would never
+// write.
+type fuzzCase struct {
+	name string
+	data []byte
+}
+
+// fuzzProbeID is the lowest stream ID of the probe call sent after a case.
+const fuzzProbeID = 1001
+
+// fuzzCases returns the fixed cases followed by random mangled frames from
+// seed.
+func fuzzCases(random int, seed uint64) ([]fuzzCase, error) {
+	valid, err := fuzzPayload(0)
+	if err != nil {
+		return nil, err
+	}
+	req := requestMessage(serviceName, methodEcho, valid)
+	frame := appendFrame(nil, 1, messageTypeRequest, 0, req)
+	garbage := randomFiller(64, seed)
+	oversized := appendFrame(nil, 1, messageTypeRequest, 0, make([]byte, ttrpcMaxMessageSize+1))
+	short := appendFrame(nil, 1, messageTypeRequest, 0, req)
+	binary.BigEndian.PutUint32(short[:4], uint32(len(req)/2))
+	huge := appendFrame(nil, 1, messageTypeRequest, 0, nil)
+	binary.BigEndian.PutUint32(huge[:4], math.MaxUint32)
+	cases := []fuzzCase{
+		{"empty request", appendFrame(nil, 1, messageTypeRequest, 0, nil)},
+		{"garbage request", appendFrame(nil, 1, messageTypeRequest, 0, garbage)},
+		{"garbage payload", appendFrame(nil, 1, messageTypeRequest, 0, requestMessage(serviceName, methodEcho, garbage))},
+		{"unknown service", appendFrame(nil, 1, messageTypeRequest, 0, requestMessage("ttrpcstress.NoSuchService", methodEcho, valid))},
+		{"unknown method", appendFrame(nil, 1, messageTypeRequest, 0, requestMessage(serviceName, "NoSuchMethod", valid))},
+		{"stream 0", appendFrame(nil, 0, messageTypeRequest, 0, req)},
+		{"even stream", appendFrame(nil, 2, messageTypeRequest, 0, req)},
+		{"reused stream", append(append([]byte(nil), frame...), frame...)},
+		{"decreasing stream", appendFrame(appendFrame(nil, 5, messageTypeRequest, 0, req), 3, messageTypeRequest, 0, req)},
+		{"unknown type", appendFrame(nil, 1, 0x7f, 0, req)},
+		{"response type", appendFrame(nil, 1, messageTypeResponse, 0, req)},
+		{"orphan data", appendFrame(nil, 7, messageTypeData, 0, valid)},
+		{"unknown flags", appendFrame(nil, 1, messageTypeRequest, 0xff, req)},
+		{"length too short", short},
+		{"oversized", oversized},
+		{"huge length", huge},
+		{"truncated header", frame[:frameHeaderLength/2]},
+		{"truncated body", frame[:len(frame)-len(req)/2]},
+	}
+	for i := 0; i < random; i++ {
+		cases = append(cases, mangleFrame(frame, seed, uint32(i)))
+	}
+	return cases, nil
+}
+
+func isFuzzCase(name string) bool {
+	cases, _ := fuzzCases(0, 1)
+	for _, c := range cases {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// mangleFrame returns frame damaged in one of a few ways chosen, along with
+// how badly, by seed and i.
+func mangleFrame(frame []byte, seed uint64, i uint32) fuzzCase {
+	rng := rand.New(rand.NewSource(int64(randomValue(seed^drawFuzz<<48, i))))
+	b := append([]byte(nil), frame...)
+	var how string
+	switch rng.Intn(4) {
+	case 0:
+		how = "flipped bytes"
+		for n := 1 + rng.Intn(4); n > 0; n-- {
+			b[rng.Intn(len(b))] ^= byte(1 + rng.Intn(255))
+		}
+	case 1:
+		how = "mangled header"
+		b[rng.Intn(frameHeaderLength)] = byte(rng.Intn(256))
+	case 2:
+		how = "truncated"
+		b = b[:rng.Intn(len(b))]
+	default:
+		how = "garbage body"
+		body := make([]byte, rng.Intn(256))
+		rng.Read(body)
+		b = appendFrame(nil, 1, messageTypeRequest, 0, body)
+	}
+	return fuzzCase{name: fmt.Sprintf("random %d, %s", i, how), data: b}
+}
+
+// fuzzPayload encodes the payload of the probe call after case i.
+func fuzzPayload(i int) ([]byte, error) {
+	data := filler(16)
+	return payloadCodecs[payloadVariant].marshal(payloadFields{value: uint32(i), data: data, checksum: checksum(data)})
+}
+
+// probeStreamID returns the stream ID for a probe call after b, above any of
+// the stream IDs in b, since a server may require a client's to increase. It
+// returns false if b is not a sequence of complete frames, after which the
+// server is still reading the last, or if no stream ID is left above them.
+func probeStreamID(b []byte) (uint32, bool) {
+	next := uint64(fuzzProbeID)
+	for len(b) > 0 {
+		if len(b) < frameHeaderLength {
+			return 0, false
+		}
+		h := parseFrameHeader(b)
+		if uint64(len(b)-frameHeaderLength) < uint64(h.length) {
+			return 0, false
+		}
+		next = max(next, (uint64(h.streamID)|1)+2)
+		b = b[frameHeaderLength+uint64(h.length):]
+	}
+	return uint32(next), next <= math.MaxUint32
+}
+
+// errProbeMismatch is returned by fuzzRead for a probe answered with a payload
+// other than the one sent.
+var errProbeMismatch = errors.New("probe answered wrongly")
+
+// fuzzRead reads frames from conn until the response to the probe on stream
+// id, which it checks against probe, or if probe is nil, until the connection
+// fails. It returns how the server reacted to the case before
+// that, from the first response to any other stream.
+func fuzzRead(conn *interopConn, id uint32, probe []byte) (reaction string, err error) {
+	for {
+		var hb [frameHeaderLength]byte
+		if _, err := io.ReadFull(conn.r, hb[:]); err != nil {
+			return reaction, err
+		}
+		h := parseFrameHeader(hb[:])
+		body := make([]byte, h.length)
+		if _, err := io.ReadFull(conn.r, body); err != nil {
+			return reaction, err
+		}
+		if h.typ != messageTypeResponse {
+			continue
+		}
+		st, resp, err := parseResponse(body)
+		if probe != nil && h.streamID == id {
+			switch {
+			case err != nil:
+				return reaction, fmt.Errorf("%w: %s", errProbeMismatch, err)
+			case st != nil:
+				return reaction, fmt.Errorf("%w: %s: %s", errProbeMismatch, st.code, st.message)
+			}
+			want, _ := payloadCodecs[payloadVariant].unmarshal(probe)
+			if got, err := payloadCodecs[payloadVariant].unmarshal(resp); err != nil || !got.equal(want) {
+				return reaction, errProbeMismatch
+			}
+			return reaction, nil
+		}
+		if reaction != "" {
+			continue
+		}
+		switch {
+		case err != nil:
+			reaction = fmt.Sprintf("undecodable response on stream %d", h.streamID)
+		case st != nil:
+			reaction = fmt.Sprintf("%s on stream %d", st.code, h.streamID)
+		default:
+			reaction = fmt.Sprintf("answered on stream %d", h.streamID)
+		}
+	}
+}
+
+// fuzzTally counts the outcomes of a fuzz run.
+type fuzzTally struct {
+	passed, hung, mismatched int
+}
+
+// runFuzz writes each of fuzzCases to a new connection, as raw bytes rather
+// than through a ttrpc client, and checks that the server deals with it: by
+// failing the call, or closing the connection, and then, on a connection left
+// at a frame boundary, by answering a valid call. After each case, a call on
+// another connection checks that the server has neither crashed nor hung.
+func runFuzz(ctx context.Context, cfg fuzzConfig) error {
+	cfg.seed = resolveSeed(cfg.seed)
+	all, err := fuzzCases(cfg.random, cfg.seed)
+	if err != nil {
+		return err
+	}
+	var cases []fuzzCase
+	for _, c := range all {
+		if !cfg.skip[c.name] {
+			cases = append(cases, c)
+		}
+	}
+	infof("fuzz: %d cases, %d of them random with seed %d", len(cases), cfg.random, cfg.seed)
+	var tally fuzzTally
+	for i, c := range cases {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := fuzzCaseRun(cfg, i, c, &tally); err != nil {
+			return err
+		}
+	}
+	infof("fuzz: %d cases: %d ok, %d hung a connection, %d answered the probe wrongly", len(cases), tally.passed, tally.hung, tally.mismatched)
+	switch {
+	case tally.mismatched > 0:
+		return withExit(exitMismatch, fmt.Errorf("%d cases left the server answering a valid call wrongly", tally.mismatched))
+	case tally.hung > 0:
+		return withExit(exitStalled, fmt.Errorf("%d cases left a connection neither answering nor closed after %v", tally.hung, cfg.timeout))
+	}
+	infof("PASS: the server rejected every malformed frame and kept answering")
+	return nil
+}
+
+// fuzzCaseRun runs case i, logging and counting its outcome. It fails if the
+// server cannot be reached or answered wrongly afterwards, since the cases
+// that follow would tell nothing.
+func fuzzCaseRun(cfg fuzzConfig, i int, c fuzzCase, tally *fuzzTally) error {
+	name := fmt.Sprintf("fuzz-%d", i)
+	conn, err := fuzzDial(cfg, name)
+	if err != nil {
+		return withExit(exitTransport, fmt.Errorf("connecting: %w", err))
+	}
+	defer conn.conn.Close()
+
+	conn.conn.SetDeadline(time.Now().Add(cfg.timeout))
+	probe, err := fuzzPayload(i + 1)
+	if err != nil {
+		return err
+	}
+	id, whole := probeStreamID(c.data)
+	data := c.data
+	if whole {
+		data = appendFrame(append([]byte(nil), data...), id, messageTypeRequest, 0, requestMessage(serviceName, methodEcho, probe))
+	} else {
+		probe = nil
+	}
+	var reaction string
+	if _, err = conn.conn.Write(data); err == nil {
+		reaction, err = fuzzRead(conn, id, probe)
+	}
+	if reaction == "" {
+		reaction = "no response"
+	}
+	var outcome string
+	ok := true
+	switch {
+	case errors.Is(err, errProbeMismatch):
+		tally.mismatched++
+		ok = false
+		outcome = err.Error()
+	case errors.Is(err, os.ErrDeadlineExceeded) && whole:
+		tally.hung++
+		ok = false
+		outcome = fmt.Sprintf("HANG: probe neither answered nor failed within %v", cfg.timeout)
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		outcome = "connection left waiting for the rest"
+	case err != nil:
+		outcome = "connection closed"
+	default:
+		outcome = "probe answered"
+	}
+	cfg.tl.record(name, "fuzz", "%s: %s; %s", c.name, reaction, outcome)
+
+	// However the case's own connection fared, a new one must be answered.
+	if err := fuzzCheck(cfg, i); err != nil {
+		return fmt.Errorf("%s (%d bytes): %s; %s; then %w", c.name, len(c.data), reaction, outcome, err)
+	}
+	line := fmt.Sprintf("%-24s %7d bytes: %s; %s", c.name, len(c.data), reaction, outcome)
+	if ok {
+		tally.passed++
+		infof("%s", line)
+	} else {
+		errorf("%s", line)
+	}
+	return nil
+}
+
+// fuzzCheck makes a valid call on a new connection after case i.
+func fuzzCheck(cfg fuzzConfig, i int) error {
+	conn, err := fuzzDial(cfg, fmt.Sprintf("fuzz-%d-check", i))
+	if err != nil {
+		return withExit(exitTransport, fmt.Errorf("the server could not be reached: %w", err))
+	}
+	defer conn.conn.Close()
+	probe, err := fuzzPayload(i + 1)
+	if err != nil {
+		return err
+	}
+	conn.conn.SetDeadline(time.Now().Add(cfg.timeout))
+	_, err = conn.conn.Write(appendFrame(nil, fuzzProbeID, messageTypeRequest, 0, requestMessage(serviceName, methodEcho, probe)))
+	if err == nil {
+		_, err = fuzzRead(conn, fuzzProbeID, probe)
+	}
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errProbeMismatch):
+		return withExit(exitMismatch, fmt.Errorf("a call on a new connection: %w", err))
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return withExit(exitStalled, fmt.Errorf("a call on a new connection got no response within %v", cfg.timeout))
+	}
+	return withExit(exitTransport, fmt.Errorf("a call on a new connection: %w", err))
+}
+
+func fuzzDial(cfg fuzzConfig, name string) (*interopConn, error) {
+	cfg.tl.record(name, "dial", "%s", cfg.addr)
+	nc, err := cfg.dial()
+	if err != nil {
+		cfg.tl.record(name, "error", "dial: %s", err)
+		return nil, err
+	}
+	nc = cfg.tl.wrapConn(nc, name)
+	return &interopConn{conn: nc, r: bufio.NewReader(nc)}, nil
+}
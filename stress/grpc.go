@@ -0,0 +1,211 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// The protocols the client and server can speak. gRPC carries the same
+// payloads, to the same services and methods, as ttrpc, so that the two stacks
+// can be compared under identical load.
+const (
+	protocolTTRPC = "ttrpc"
+	protocolGRPC  = "grpc"
+)
+
+func validateProtocol(protocol string) error {
+	if protocol != protocolTTRPC && protocol != protocolGRPC {
+		return usageErrorf("-protocol must be %q or %q, got %q", protocolTTRPC, protocolGRPC, protocol)
+	}
+	return nil
+}
+
+// grpcCodec marshals payloads for gRPC as ttrpc does, with the encoding of
+// the payload variant, so that both protocols carry identical messages. It
+// takes the place of gRPC's own proto codec, under the same name.
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v interface{}) ([]byte, error) {
+	p, ok := v.(*payload)
+	if !ok {
+		return nil, fmt.Errorf("cannot marshal %T", v)
+	}
+	return marshalPayload(p)
+}
+
+func (grpcCodec) Unmarshal(b []byte, v interface{}) error {
+	p, ok := v.(*payload)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal into %T", v)
+	}
+	return unmarshalPayload(b, p)
+}
+
+func (grpcCodec) Name() string {
+	return "proto"
+}
+
+// rpcServer is the part of a *ttrpc.Server that serve uses, which a
+// *grpcServer also provides.
+type rpcServer interface {
+	Register(name string, methods map[string]ttrpc.Method)
+	Serve(ctx context.Context, l net.Listener) error
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+func newRPCServer(protocol string, interceptors []ttrpc.UnaryServerInterceptor) (rpcServer, error) {
+	if protocol == protocolGRPC {
+		return newGRPCServer(interceptors), nil
+	}
+	var opts []ttrpc.ServerOpt
+	if len(interceptors) > 0 {
+		opts = append(opts, ttrpc.WithUnaryServerInterceptor(chainInterceptors(interceptors)))
+	}
+	return ttrpc.NewServer(opts...)
+}
+
+// chainInterceptors returns a single interceptor that runs interceptors, the
+// first outermost. ttrpc.WithChainUnaryServerInterceptor does the same, but
+// only from ttrpc v1.2.0, so it is done here for the builds against older
+// versions.
+func chainInterceptors(interceptors []ttrpc.UnaryServerInterceptor) ttrpc.UnaryServerInterceptor {
+	return func(ctx context.Context, unmarshal ttrpc.Unmarshaler, info *ttrpc.UnaryServerInfo, method ttrpc.Method) (interface{}, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], method
+			method = func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+				return interceptor(ctx, unmarshal, info, next)
+			}
+		}
+		return method(ctx, unmarshal)
+	}
+}
+
+// grpcServer serves the ttrpc methods registered with it over gRPC, through
+// the same interceptors, with the same message size limit.
+type grpcServer struct {
+	server       *grpc.Server
+	interceptors []ttrpc.UnaryServerInterceptor
+	// active is the number of calls being handled, for Shutdown.
+	active atomic.Int64
+}
+
+func newGRPCServer(interceptors []ttrpc.UnaryServerInterceptor) *grpcServer {
+	return &grpcServer{
+		server: grpc.NewServer(
+			grpc.ForceServerCodec(grpcCodec{}),
+			grpc.MaxRecvMsgSize(ttrpcMaxMessageSize),
+			grpc.MaxSendMsgSize(ttrpcMaxMessageSize)),
+		interceptors: interceptors,
+	}
+}
+
+// Register adds the methods of the named service. It must be called before
+// Serve.
+func (s *grpcServer) Register(name string, methods map[string]ttrpc.Method) {
+	desc := &grpc.ServiceDesc{ServiceName: name}
+	for method, handler := range methods {
+		info := &ttrpc.UnaryServerInfo{FullMethod: grpcMethod(name, method)}
+		handler := s.chain(info, handler)
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: method,
+			Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				s.active.Add(1)
+				defer s.active.Add(-1)
+				return handler(ctx, dec)
+			},
+		})
+	}
+	s.server.RegisterService(desc, nil)
+}
+
+// chain returns handler wrapped in the server's interceptors, the first
+// outermost, as for ttrpc; see chainInterceptors.
+func (s *grpcServer) chain(info *ttrpc.UnaryServerInfo, handler ttrpc.Method) ttrpc.Method {
+	if len(s.interceptors) == 0 {
+		return handler
+	}
+	interceptor := chainInterceptors(s.interceptors)
+	return func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+		return interceptor(ctx, unmarshal, info, handler)
+	}
+}
+
+func (s *grpcServer) Serve(_ context.Context, l net.Listener) error {
+	if err := s.server.Serve(l); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return ttrpc.ErrServerClosed
+}
+
+// grpcShutdownPoll is how often Shutdown checks for calls in flight.
+const grpcShutdownPoll = 10 * time.Millisecond
+
+// Shutdown stops accepting connections and calls, and as ttrpc's does, closes
+// the connections once no calls are in flight, or fails once ctx is done.
+// gRPC's GracefulStop alone would also wait for clients to hang up.
+func (s *grpcServer) Shutdown(ctx context.Context) error {
+	go s.server.GracefulStop()
+	ticker := time.NewTicker(grpcShutdownPoll)
+	defer ticker.Stop()
+	for s.active.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	s.server.Stop()
+	return nil
+}
+
+func (s *grpcServer) Close() error {
+	s.server.Stop()
+	return nil
+}
+
+// grpcMethod returns the gRPC name of method of service.
+func grpcMethod(service, method string) string {
+	return "/" + service + "/" + method
+}
+
+// dialGRPC opens a gRPC connection over connections from dial, which is called
+// once up front, so that a server that cannot be reached fails the run as it
+// would over ttrpc, and again whenever gRPC re-dials a connection that drops.
+func dialGRPC(name string, dial func(name string) (net.Conn, error)) (*grpc.ClientConn, error) {
+	first, err := dial(name)
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	dialer := func(context.Context, string) (net.Conn, error) {
+		c := net.Conn(nil)
+		once.Do(func() { c = first })
+		if c != nil {
+			return c, nil
+		}
+		return dial(name)
+	}
+	cc, err := grpc.NewClient("passthrough:///"+name,
+		grpc.WithContextDialer(dialer),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(
+			grpc.ForceCodec(grpcCodec{}),
+			grpc.MaxCallRecvMsgSize(ttrpcMaxMessageSize),
+			grpc.MaxCallSendMsgSize(ttrpcMaxMessageSize)))
+	if err != nil {
+		first.Close()
+		return nil, err
+	}
+	cc.Connect()
+	return cc, nil
+}
@@ -0,0 +1,161 @@
+package stress
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/containerd/ttrpc"
+)
+
+func herdCommand(fs *flag.FlagSet) func(context.Context) error {
+	var cfg herdConfig
+	fs.StringVar(&cfg.addr, "addr", "", "Address to connect to (tcp://HOST:PORT, unix://PATH, npipe://./pipe/NAME, hvsock://VMID:SERVICE, or vsock://CID:PORT; required)")
+	fs.IntVar(&cfg.conns, "conns", 100, "Number of connections to open at once")
+	fs.IntVar(&cfg.calls, "calls", 1, "Number of calls to send on each connection")
+	fs.DurationVar(&cfg.connectTimeout, "connect-timeout", 10*time.Second, "Time after which a connect is counted as hung")
+	timelinePath := timelineFlag(fs)
+	return func(ctx context.Context) error {
+		switch {
+		case cfg.addr == "":
+			return usageErrorf("-addr is required")
+		case cfg.conns < 1:
+			return usageErrorf("-conns must be at least 1, got %d", cfg.conns)
+		case cfg.calls < 0:
+			return usageErrorf("-calls must not be negative, got %d", cfg.calls)
+		case cfg.connectTimeout <= 0:
+			return usageErrorf("-connect-timeout must be positive, got %v", cfg.connectTimeout)
+		}
+		tl, err := newTimeline(*timelinePath)
+		if err != nil {
+			return err
+		}
+		defer tl.close()
+		cfg.tl = tl
+		addr := cfg.addr
+		cfg.dial = func() (net.Conn, error) { return dial(addr, 0) }
+		return runHerd(ctx, cfg)
+	}
+}
+
+// herdConfig holds the settings for a thundering-herd connect run.
+type herdConfig struct {
+	addr  string
+	dial  func() (net.Conn, error)
+	conns int
+	// calls is the number of calls sent on each connection once established.
+	calls int
+	// connectTimeout is how long a dial may take before it is counted as hung.
+	connectTimeout time.Duration
+	tl             *timeline
+}
+
+type herdResult struct {
+	connect time.Duration
+	err     error
+	hung    bool
+}
+
+// runHerd opens cfg.conns connections as close to simultaneously as possible,
+// modelling many clients reconnecting at once after a server restart. It
+// reports the distribution of connection establishment latency and how many
+// connects failed or hung.
+func runHerd(ctx context.Context, cfg herdConfig) error {
+	var (
+		wg      sync.WaitGroup
+		start   = make(chan struct{})
+		results = make([]herdResult, cfg.conns)
+	)
+	for i := 0; i < cfg.conns; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			results[i] = herdConnect(ctx, cfg, i)
+		}(i)
+	}
+	cfg.tl.record("herd", "start", "%d connections", cfg.conns)
+	began := time.Now()
+	close(start)
+	wg.Wait()
+	elapsed := time.Since(began)
+
+	var (
+		latencies    []time.Duration
+		failed, hung int
+	)
+	for _, r := range results {
+		switch {
+		case r.hung:
+			hung++
+		case r.err != nil:
+			failed++
+		default:
+			latencies = append(latencies, r.connect)
+		}
+	}
+	sortDurations(latencies)
+	infof("herd: %d connections in %v: %d succeeded, %d failed, %d hung (>%v)",
+		cfg.conns, elapsed, len(latencies), failed, hung, cfg.connectTimeout)
+	if len(latencies) > 0 {
+		infof("connect latency: p50 %v, p90 %v, p99 %v, max %v",
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99), latencies[len(latencies)-1])
+	}
+	if failed > 0 || hung > 0 {
+		return fmt.Errorf("%d connections failed and %d hung", failed, hung)
+	}
+	return nil
+}
+
+func herdConnect(ctx context.Context, cfg herdConfig, i int) herdResult {
+	name := fmt.Sprintf("herd-%d", i)
+	type dialed struct {
+		c   net.Conn
+		err error
+	}
+	ch := make(chan dialed, 1)
+	start := time.Now()
+	go func() {
+		c, err := cfg.dial()
+		ch <- dialed{c, err}
+	}()
+	var d dialed
+	select {
+	case d = <-ch:
+	case <-time.After(cfg.connectTimeout):
+		cfg.tl.record(name, "error", "connect hung for %v", cfg.connectTimeout)
+		// Close the connection if the dial eventually completes.
+		go func() {
+			if d := <-ch; d.c != nil {
+				d.c.Close()
+			}
+		}()
+		return herdResult{hung: true}
+	}
+	connect := time.Since(start)
+	if d.err != nil {
+		cfg.tl.record(name, "error", "dial: %s", d.err)
+		errorf("connection %d failed: %s", i, d.err)
+		return herdResult{err: d.err}
+	}
+	cfg.tl.record(name, "connected", "after %v", connect)
+	client := ttrpc.NewClient(cfg.tl.wrapConn(d.c, name))
+	defer client.Close()
+	for j := 0; j < cfg.calls; j++ {
+		id := uint32(i*cfg.calls + j)
+		resp := &payload{}
+		if err := client.Call(ctx, serviceName, methodEcho, &payload{Value: id}, resp); err != nil {
+			errorf("connection %d call failed: %s", i, err)
+			return herdResult{connect: connect, err: err}
+		}
+		if resp.Value != id {
+			err := fmt.Errorf("connection %d: expected return value %d but got %d", i, id, resp.Value)
+			errorf("%s", err)
+			return herdResult{connect: connect, err: err}
+		}
+	}
+	return herdResult{connect: connect}
+}
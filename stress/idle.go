@@ -0,0 +1,252 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"golang.org/x/sync/errgroup"
+)
+
+func idleCommand(fs *flag.FlagSet) func(context.Context) error {
+	var (
+		cfg            idleConfig
+		loopback       bool
+		loopbackVia    string
+		connectTimeout time.Duration
+	)
+	fs.StringVar(&cfg.addr, "addr", "", "Address to connect to (tcp://HOST:PORT, unix://PATH, npipe://./pipe/NAME, hvsock://VMID:SERVICE, or vsock://CID:PORT; required unless -loopback)")
+	fs.DurationVar(&connectTimeout, "connect-timeout", 10*time.Second, "Give up on connecting after this long (0 to wait as long as the OS allows)")
+	fs.BoolVar(&loopback, "loopback", false, "Run against a server started in this process, rather than connecting to -addr")
+	fs.StringVar(&loopbackVia, "loopback-transport", "inproc", "Transport for -loopback: \"inproc\", \"tcp\", \"unix\", or on Windows \"npipe\", each on a private address")
+	fs.IntVar(&cfg.conns, "conns", 1, "Number of connections to hold open, each idling independently")
+	fs.IntVar(&cfg.cycles, "cycles", 3, "Number of bursts to send on each connection, with an idle gap before each but the first")
+	fs.IntVar(&cfg.burst, "burst", 10, "Number of calls sent concurrently in each burst")
+	fs.DurationVar(&cfg.idle, "idle", 30*time.Second, "How long each connection sits idle between bursts")
+	fs.DurationVar(&cfg.keepalive, "keepalive", 0, "Send a PING call at this interval while idle, to see whether it keeps the connection open (0 to leave it silent)")
+	fs.DurationVar(&cfg.callTimeout, "call-timeout", 10*time.Second, "Count a call that neither completes nor fails within this long as hung")
+	leak := leakFlags(fs, "the run")
+	timelinePath := timelineFlag(fs)
+	return func(ctx context.Context) error {
+		switch {
+		case cfg.addr == "" && !loopback:
+			return usageErrorf("-addr or -loopback is required")
+		case cfg.addr != "" && loopback:
+			return usageErrorf("-addr cannot be combined with -loopback")
+		case loopbackVia != "inproc" && !loopback:
+			return usageErrorf("-loopback-transport requires -loopback")
+		case cfg.conns < 1:
+			return usageErrorf("-conns must be at least 1, got %d", cfg.conns)
+		case cfg.cycles < 1:
+			return usageErrorf("-cycles must be at least 1, got %d", cfg.cycles)
+		case cfg.burst < 1:
+			return usageErrorf("-burst must be at least 1, got %d", cfg.burst)
+		case cfg.idle < 0:
+			return usageErrorf("-idle must not be negative, got %v", cfg.idle)
+		case cfg.keepalive < 0:
+			return usageErrorf("-keepalive must not be negative, got %v", cfg.keepalive)
+		case cfg.callTimeout <= 0:
+			return usageErrorf("-call-timeout must be positive, got %v", cfg.callTimeout)
+		case connectTimeout < 0:
+			return usageErrorf("-connect-timeout must not be negative, got %v", connectTimeout)
+		}
+		if err := leak.validate(); err != nil {
+			return err
+		}
+		tl, err := newTimeline(*timelinePath)
+		if err != nil {
+			return err
+		}
+		defer tl.close()
+		cfg.tl = tl
+		checkLeaks := leak.begin("idle")
+		stopServer := func() error { return nil }
+		if loopback {
+			cfg.dial, stopServer, err = startLoopback(ctx, loopbackVia, protocolTTRPC, tl)
+			if err != nil {
+				return err
+			}
+		} else {
+			addr := cfg.addr
+			cfg.dial = func() (net.Conn, error) { return dial(addr, connectTimeout) }
+		}
+		err = runIdle(ctx, cfg)
+		if serverErr := stopServer(); err == nil {
+			err = serverErr
+		}
+		if leakErr := checkLeaks(); err == nil {
+			err = leakErr
+		}
+		return err
+	}
+}
+
+// idleConfig holds the settings for an idle connection run.
+type idleConfig struct {
+	addr string
+	dial func() (net.Conn, error)
+	// conns is the number of connections, each of which sends cycles bursts
+	// of burst calls, idling for idle before each but the first.
+	conns  int
+	cycles int
+	burst  int
+	idle   time.Duration
+	// keepalive, if positive, is the interval of the pings sent while idle.
+	keepalive time.Duration
+	// callTimeout is how long a call may take before it is counted as hung.
+	callTimeout time.Duration
+	tl          *timeline
+}
+
+// idleStats collects the outcomes of an idle run. The latencies of the first
+// bursts, sent on fresh connections, are kept apart from those sent after an
+// idle gap, so that any cost of waking an idle connection shows.
+type idleStats struct {
+	succeeded atomic.Int64
+	hung      atomic.Int64
+	pings     atomic.Int64
+	failed    atomic.Int64
+	mu        sync.Mutex
+	fresh     []time.Duration
+	woken     []time.Duration
+}
+
+func (s *idleStats) record(afterIdle bool, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if afterIdle {
+		s.woken = append(s.woken, d)
+	} else {
+		s.fresh = append(s.fresh, d)
+	}
+}
+
+// runIdle holds cfg.conns connections open, sending a short burst of calls on
+// each, with long idle gaps between bursts. Constant load never leaves a
+// connection idle, so never exercises how either end, or anything between
+// them, treats one that is: every call after a gap must succeed on the same
+// connection, which must not have been closed while it idled.
+func runIdle(ctx context.Context, cfg idleConfig) error {
+	var stats idleStats
+	infof("idle: %d connections, %d bursts of %d calls, %v apart", cfg.conns, cfg.cycles, cfg.burst, cfg.idle)
+	cfg.tl.record("idle", "start", "%d connections, %d cycles", cfg.conns, cfg.cycles)
+	start := time.Now()
+	eg, egCtx := errgroup.WithContext(ctx)
+	for i := 0; i < cfg.conns; i++ {
+		i := i
+		eg.Go(func() error { return runIdleConn(egCtx, cfg, i, &stats) })
+	}
+	err := eg.Wait()
+	sortDurations(stats.fresh)
+	sortDurations(stats.woken)
+	infof("idle: %d calls succeeded, %d hung (>%v), in %v", stats.succeeded.Load(), stats.hung.Load(), cfg.callTimeout, time.Since(start).Round(time.Millisecond))
+	infof("idle: latency on fresh connections p50 %v, max %v; after idling p50 %v, max %v",
+		percentile(stats.fresh, 50).Round(time.Microsecond), percentile(stats.fresh, 100).Round(time.Microsecond),
+		percentile(stats.woken, 50).Round(time.Microsecond), percentile(stats.woken, 100).Round(time.Microsecond))
+	if cfg.keepalive > 0 {
+		infof("idle: keepalive pings: %d succeeded, %d failed", stats.pings.Load(), stats.failed.Load())
+	}
+	if n := stats.hung.Load(); err == nil && n > 0 {
+		err = withExit(exitStalled, fmt.Errorf("%d calls hung for more than %v", n, cfg.callTimeout))
+	}
+	return err
+}
+
+// runIdleConn runs connection i of the run: its bursts, and the gaps between them.
+func runIdleConn(ctx context.Context, cfg idleConfig, i int, stats *idleStats) error {
+	name := fmt.Sprintf("idle-%d", i)
+	cfg.tl.record(name, "dial", "%s", cfg.addr)
+	conn, err := cfg.dial()
+	if err != nil {
+		cfg.tl.record(name, "error", "dial: %s", err)
+		return withExit(exitTransport, fmt.Errorf("connection %d: %w", i, err))
+	}
+	cfg.tl.record(name, "connected", "%s", conn.RemoteAddr())
+	// closed is when the connection closed without the run closing it.
+	var closing atomic.Bool
+	var closed atomic.Int64
+	client := ttrpc.NewClient(cfg.tl.wrapConn(conn, name), ttrpc.WithOnClose(func() {
+		if !closing.Load() {
+			closed.Store(time.Now().UnixNano())
+		}
+	}))
+	defer func() {
+		closing.Store(true)
+		client.Close()
+	}()
+	for c := 0; c < cfg.cycles; c++ {
+		if c > 0 {
+			idleSince := time.Now()
+			cfg.tl.record(name, "idle", "for %v", cfg.idle)
+			if err := idleGap(ctx, cfg, client, stats); err != nil {
+				return err
+			}
+			if at := closed.Load(); at != 0 {
+				into := time.Unix(0, at).Sub(idleSince).Round(time.Millisecond)
+				cfg.tl.record(name, "error", "closed %v into the idle gap", into)
+				return withExit(exitTransport, fmt.Errorf("connection %d: closed %v into the idle gap before burst %d", i, into, c))
+			}
+		}
+		if err := idleBurst(ctx, cfg, client, i, c, stats); err != nil {
+			if errors.Is(err, ttrpc.ErrClosed) {
+				return withExit(exitTransport, fmt.Errorf("connection %d burst %d: %w", i, c, err))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// idleGap waits out an idle gap, pinging the connection meanwhile with
+// keepalive.
+func idleGap(ctx context.Context, cfg idleConfig, client *ttrpc.Client, stats *idleStats) error {
+	gapCtx, cancel := context.WithTimeout(ctx, cfg.idle)
+	defer cancel()
+	if cfg.keepalive > 0 {
+		ok, failed := keepalive(gapCtx, func() *ttrpc.Client { return client }, cfg.keepalive, cfg.tl)
+		stats.pings.Add(int64(ok))
+		stats.failed.Add(int64(failed))
+	} else {
+		<-gapCtx.Done()
+	}
+	return ctx.Err()
+}
+
+// idleBurst sends burst c of connection i, and checks that every call came back
+// with its own response.
+func idleBurst(ctx context.Context, cfg idleConfig, client *ttrpc.Client, i, c int, stats *idleStats) error {
+	var g errgroup.Group
+	for k := 0; k < cfg.burst; k++ {
+		id := uint32((i*cfg.cycles+c)*cfg.burst + k)
+		g.Go(func() error {
+			callCtx, cancel := context.WithTimeout(ctx, cfg.callTimeout)
+			defer cancel()
+			resp := &payload{}
+			start := time.Now()
+			err := client.Call(callCtx, serviceName, methodEcho, &payload{Value: id}, resp)
+			switch {
+			case err == nil && resp.Value != id:
+				return withExit(exitMismatch, fmt.Errorf("connection %d burst %d: expected return value %d but got %d", i, c, id, resp.Value))
+			case err == nil:
+				stats.succeeded.Add(1)
+				stats.record(c > 0, time.Since(start))
+			case isTimeout(err) && ctx.Err() == nil:
+				cfg.tl.record(fmt.Sprintf("idle-%d", i), "error", "request %d hung", id)
+				errorf("connection %d burst %d request %d: no response or error within %v", i, c, id, cfg.callTimeout)
+				stats.hung.Add(1)
+			case errors.Is(err, ttrpc.ErrClosed):
+				return err
+			default:
+				return withExit(callExit(err), fmt.Errorf("connection %d burst %d request %d: %w", i, c, id, err))
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
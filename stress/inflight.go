@@ -0,0 +1,122 @@
+package stress
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// inflightCall describes a single outstanding call: the request it was sent
+// for, and the value it carried, which is what the server logs at -v.
+type inflightCall struct {
+	worker int
+	id     uint32
+	value  uint32
+	start  time.Time
+}
+
+// inflightShards is the number of independently locked shards of an
+// inflightTracker, so that tracking does not serialize the workers on one lock.
+const inflightShards = 64
+
+// inflightTracker records the start time of every outstanding call, so that a
+// stall can be traced back to the specific requests that are stuck.
+//
+// A nil *inflightTracker is valid and tracks nothing.
+type inflightTracker struct {
+	seq    atomic.Uint64
+	shards [inflightShards]struct {
+		mu    sync.Mutex
+		calls map[uint64]inflightCall
+	}
+}
+
+func newInflightTracker() *inflightTracker {
+	t := &inflightTracker{}
+	for i := range t.shards {
+		t.shards[i].calls = make(map[uint64]inflightCall)
+	}
+	return t
+}
+
+// begin records the start of a call and returns a token to pass to end. The
+// token is distinct from id, since the same value may be in flight more than once.
+func (t *inflightTracker) begin(worker int, id, value uint32) uint64 {
+	if t == nil {
+		return 0
+	}
+	token := t.seq.Add(1)
+	s := &t.shards[token%inflightShards]
+	s.mu.Lock()
+	s.calls[token] = inflightCall{worker: worker, id: id, value: value, start: time.Now()}
+	s.mu.Unlock()
+	return token
+}
+
+func (t *inflightTracker) end(token uint64) {
+	if t == nil {
+		return
+	}
+	s := &t.shards[token%inflightShards]
+	s.mu.Lock()
+	delete(s.calls, token)
+	s.mu.Unlock()
+}
+
+// snapshot returns the outstanding calls, oldest first.
+func (t *inflightTracker) snapshot() []inflightCall {
+	if t == nil {
+		return nil
+	}
+	var calls []inflightCall
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.Lock()
+		for _, c := range s.calls {
+			calls = append(calls, c)
+		}
+		s.mu.Unlock()
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].start.Before(calls[j].start) })
+	return calls
+}
+
+// waiting returns the workers with a call outstanding since before t, which
+// are stuck, rather than short of work, if they have completed nothing since.
+func (t *inflightTracker) waiting(before time.Time) map[int]bool {
+	if t == nil {
+		return nil
+	}
+	workers := make(map[int]bool)
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.mu.Lock()
+		for _, c := range s.calls {
+			if c.start.Before(before) {
+				workers[c.worker] = true
+			}
+		}
+		s.mu.Unlock()
+	}
+	return workers
+}
+
+// maxListedCalls bounds how many outstanding calls listInflight lists, as a
+// stall under goroutine-per-call dispatch can leave many thousands.
+const maxListedCalls = 200
+
+// listInflight writes calls, as returned by snapshot, to stderr.
+func listInflight(calls []inflightCall) {
+	now := time.Now()
+	for i, c := range calls {
+		if i == maxListedCalls {
+			fmt.Fprintf(os.Stderr, "... and %d more\n", len(calls)-i)
+			break
+		}
+		fmt.Fprintf(os.Stderr, "worker %d request %d (value %d): sent %s, outstanding for %v\n",
+			c.worker, c.id, c.value, c.start.Format(time.RFC3339Nano), now.Sub(c.start).Round(time.Millisecond))
+	}
+}
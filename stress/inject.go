@@ -0,0 +1,105 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// injectedErrorCode is the default status code of the errors injected by
+// -error-rate.
+const injectedErrorCode = codes.Aborted
+
+// injectedErrorFormat is the message of an injected error. It carries the value
+// of the request that failed, so the client can tell that the error came back
+// for the call that caused it, whatever its code.
+const injectedErrorFormat = "request %d: injected error"
+
+// injectedError returns the error injected for the request with value id.
+func injectedError(code codes.Code, id uint32) error {
+	return status.Errorf(code, injectedErrorFormat, id)
+}
+
+// injectedErrorValue returns the request value carried by err, if it is an
+// injected error.
+func injectedErrorValue(err error) (uint32, bool) {
+	s, ok := status.FromError(err)
+	if !ok || s.Code() == codes.OK {
+		return 0, false
+	}
+	var id uint32
+	if _, err := fmt.Sscanf(s.Message(), injectedErrorFormat, &id); err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// injectedPanicFormat is the value a handler chosen by -panic-rate panics
+// with. Like injectedErrorFormat, it carries the value of the request, so the
+// client can tell that the error reporting the panic came back for the call
+// that caused it.
+const injectedPanicFormat = "request %d: injected panic"
+
+// injectedPanicPattern finds an injected panic's value in the message of the
+// error reporting it, which whatever recovered the panic may have wrapped in
+// text of its own.
+var injectedPanicPattern = regexp.MustCompile(`request (\d+): injected panic`)
+
+// injectedPanicValue returns the request value of the injected panic that err
+// reports, if it reports one.
+func injectedPanicValue(err error) (uint32, bool) {
+	s, ok := status.FromError(err)
+	if !ok || s.Code() == codes.OK {
+		return 0, false
+	}
+	m := injectedPanicPattern.FindStringSubmatch(s.Message())
+	if m == nil {
+		return 0, false
+	}
+	id, perr := strconv.ParseUint(m[1], 10, 32)
+	return uint32(id), perr == nil
+}
+
+// recoverInterceptor fails a call whose handler panics with an Internal error
+// reporting the panic, as a service's own recovery middleware would, and
+// counts it in recovered. Without it, a panic is left to ttrpc, which may or
+// may not recover it, depending on its version.
+func recoverInterceptor(recovered *atomic.Int64) ttrpc.UnaryServerInterceptor {
+	return func(ctx context.Context, unmarshal ttrpc.Unmarshaler, info *ttrpc.UnaryServerInfo, method ttrpc.Method) (resp interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				recovered.Add(1)
+				resp, err = nil, status.Errorf(codes.Internal, "%s: recovered from panic: %v", info.FullMethod, p)
+			}
+		}()
+		return method(ctx, unmarshal)
+	}
+}
+
+// parseErrorCodes parses a comma-separated list of status code names, such as
+// "Aborted,NotFound", ignoring case. OK is not an error, so is rejected.
+func parseErrorCodes(s string) ([]codes.Code, error) {
+	var list []codes.Code
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for c := codes.Canceled; c <= codes.Unauthenticated; c++ {
+			if strings.EqualFold(name, c.String()) {
+				list = append(list, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown error status code %q", name)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,67 @@
+package stress
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// inprocListener is an in-memory net.Listener. Each dial creates a synchronous,
+// unbuffered net.Pipe, which (like a zero-buffer named pipe) makes the writer wait
+// for the reader.
+type inprocListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type inprocAddr struct{}
+
+func (inprocAddr) Network() string { return "inproc" }
+func (inprocAddr) String() string  { return "inproc" }
+
+// inprocConn reports inproc addresses rather than those of the underlying net.Pipe.
+type inprocConn struct {
+	net.Conn
+}
+
+func (inprocConn) LocalAddr() net.Addr  { return inprocAddr{} }
+func (inprocConn) RemoteAddr() net.Addr { return inprocAddr{} }
+
+func newInprocListener() *inprocListener {
+	return &inprocListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *inprocListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *inprocListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *inprocListener) Addr() net.Addr {
+	return inprocAddr{}
+}
+
+// dial connects to the listener, blocking until the connection is accepted.
+func (l *inprocListener) dial() (net.Conn, error) {
+	server, client := net.Pipe()
+	select {
+	case l.conns <- inprocConn{server}:
+		return inprocConn{client}, nil
+	case <-l.done:
+		server.Close()
+		client.Close()
+		return nil, errors.New("inproc listener closed")
+	}
+}
@@ -0,0 +1,448 @@
+package stress
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+	"github.com/kevpar/test/ttrpcstress/protogo"
+	"github.com/kevpar/test/ttrpcstress/protogogo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func interopCommand(fs *flag.FlagSet) func(context.Context) error {
+	var (
+		cfg            interopConfig
+		loopback       bool
+		loopbackVia    string
+		connectTimeout time.Duration
+	)
+	fs.StringVar(&cfg.addr, "addr", "", "Address of a server with default response settings to connect to (tcp://HOST:PORT, unix://PATH, npipe://./pipe/NAME, hvsock://VMID:SERVICE, or vsock://CID:PORT; required unless -loopback)")
+	fs.DurationVar(&connectTimeout, "connect-timeout", 10*time.Second, "Give up on connecting after this long (0 to wait as long as the OS allows)")
+	fs.BoolVar(&loopback, "loopback", false, "Run against a server started in this process, which decodes with this build's "+payloadVariant+" payload, rather than connecting to -addr")
+	fs.StringVar(&loopbackVia, "loopback-transport", "inproc", "Transport for -loopback: \"inproc\", \"tcp\", \"unix\", or on Windows \"npipe\", each on a private address")
+	fs.StringVar(&cfg.encode, "encode", otherPayloadVariant(), fmt.Sprintf("Encode requests, and decode responses, as the code from this generator variant would (%s), whatever this build uses", strings.Join(payloadCodecNames(), " or ")))
+	fs.DurationVar(&cfg.callTimeout, "call-timeout", 10*time.Second, "Count a call that gets no response within this long as hung")
+	timelinePath := timelineFlag(fs)
+	return func(ctx context.Context) error {
+		switch {
+		case cfg.addr == "" && !loopback:
+			return usageErrorf("-addr or -loopback is required")
+		case cfg.addr != "" && loopback:
+			return usageErrorf("-addr cannot be combined with -loopback")
+		case loopbackVia != "inproc" && !loopback:
+			return usageErrorf("-loopback-transport requires -loopback")
+		case payloadCodecs[cfg.encode].marshal == nil:
+			return usageErrorf("-encode must be %s, got %q", strings.Join(payloadCodecNames(), " or "), cfg.encode)
+		case cfg.callTimeout <= 0:
+			return usageErrorf("-call-timeout must be positive, got %v", cfg.callTimeout)
+		case connectTimeout < 0:
+			return usageErrorf("-connect-timeout must not be negative, got %v", connectTimeout)
+		}
+		tl, err := newTimeline(*timelinePath)
+		if err != nil {
+			return err
+		}
+		defer tl.close()
+		cfg.tl = tl
+		stopServer := func() error { return nil }
+		if loopback {
+			cfg.dial, stopServer, err = startLoopback(ctx, loopbackVia, protocolTTRPC, tl)
+			if err != nil {
+				return err
+			}
+		} else {
+			addr := cfg.addr
+			cfg.dial = func() (net.Conn, error) { return dial(addr, connectTimeout) }
+		}
+		err = runInterop(ctx, cfg)
+		if serverErr := stopServer(); err == nil {
+			err = serverErr
+		}
+		return err
+	}
+}
+
+// interopConfig holds the settings for a codec interop run.
+type interopConfig struct {
+	addr string
+	dial func() (net.Conn, error)
+	// encode names the entry of payloadCodecs used for requests and responses.
+	encode      string
+	callTimeout time.Duration
+	tl          *timeline
+}
+
+// payloadFields are the fields of a payload, independent of the code generated
+// for it.
+type payloadFields struct {
+	value    uint32
+	data     []byte
+	checksum uint32
+}
+
+func (f payloadFields) equal(g payloadFields) bool {
+	return f.value == g.value && bytes.Equal(f.data, g.data) && f.checksum == g.checksum
+}
+
+// payloadCodec encodes and decodes payloads with the code from one of the two
+// generators. Both are linked into every build, whichever the build tags
+// choose for payload, so that either can be sent to a server built with the
+// other.
+type payloadCodec struct {
+	marshal   func(payloadFields) ([]byte, error)
+	unmarshal func([]byte) (payloadFields, error)
+}
+
+var payloadCodecs = map[string]payloadCodec{
+	"protogo": {
+		marshal: func(f payloadFields) ([]byte, error) {
+			return proto.MarshalOptions{Deterministic: true}.Marshal(&protogo.Payload{Value: f.value, Data: f.data, Checksum: f.checksum})
+		},
+		unmarshal: func(b []byte) (payloadFields, error) {
+			p := &protogo.Payload{}
+			err := proto.Unmarshal(b, p)
+			return payloadFields{p.Value, p.Data, p.Checksum}, err
+		},
+	},
+	"protogogo": {
+		marshal: func(f payloadFields) ([]byte, error) {
+			return gogoproto.Marshal(&protogogo.Payload{Value: f.value, Data: f.data, Checksum: f.checksum})
+		},
+		unmarshal: func(b []byte) (payloadFields, error) {
+			p := &protogogo.Payload{}
+			err := gogoproto.Unmarshal(b, p)
+			return payloadFields{p.Value, p.Data, p.Checksum}, err
+		},
+	},
+}
+
+func payloadCodecNames() []string {
+	var names []string
+	for name := range payloadCodecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// otherPayloadVariant is the generator variant this build does not use for
+// payload, so that by default an interop run crosses the two.
+func otherPayloadVariant() string {
+	for _, name := range payloadCodecNames() {
+		if name != payloadVariant {
+			return name
+		}
+	}
+	return payloadVariant
+}
+
+// interopCase is a request to send encoded by the variant under test. With
+// reordered, its fields are encoded one at a time and sent last field first,
+// and with unknown, a field the schema does not have is appended; a decoder
+// must accept both.
+type interopCase struct {
+	name               string
+	fields             payloadFields
+	reordered, unknown bool
+}
+
+// interopUnknownField is the field number used for interopCase.unknown.
+const interopUnknownField = 15
+
+func interopCases() []interopCase {
+	sized := func(v uint32, n int) payloadFields {
+		d := filler(n)
+		return payloadFields{value: v, data: d, checksum: checksum(d)}
+	}
+	return []interopCase{
+		{name: "empty", fields: sized(0, 0)},
+		{name: "value 1", fields: sized(1, 0)},
+		{name: "value 127", fields: sized(127, 0)},
+		{name: "value 128", fields: sized(128, 0)},
+		{name: "value 16384", fields: sized(16384, 0)},
+		{name: "value max", fields: sized(math.MaxUint32, 0)},
+		{name: "data 1 byte", fields: sized(1, 1)},
+		{name: "data 128 bytes", fields: sized(1, 128)},
+		{name: "data 64 KiB", fields: sized(1, 64<<10)},
+		{name: "data 1 MiB", fields: sized(1, 1<<20)},
+		{name: "reordered fields", fields: sized(128, 128), reordered: true},
+		{name: "unknown field", fields: sized(128, 128), unknown: true},
+	}
+}
+
+func (c interopCase) encode(codec payloadCodec) ([]byte, error) {
+	if !c.reordered {
+		b, err := codec.marshal(c.fields)
+		if err != nil || !c.unknown {
+			return b, err
+		}
+		b = protowire.AppendTag(b, interopUnknownField, protowire.VarintType)
+		return protowire.AppendVarint(b, 1), nil
+	}
+	var b []byte
+	for _, f := range []payloadFields{{checksum: c.fields.checksum}, {data: c.fields.data}, {value: c.fields.value}} {
+		part, err := codec.marshal(f)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, part...)
+	}
+	return b, nil
+}
+
+// interopConn sends requests over a connection as raw ttrpc frames, so that
+// their payloads are exactly the bytes given, rather than being encoded by
+// ttrpc's codec with this build's payload type.
+type interopConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	nextID uint32
+}
+
+// errNoResponse is returned by interopConn.call for a request that got no
+// response within the call timeout.
+var errNoResponse = errors.New("no response")
+
+// call sends payload to method and waits up to timeout for the response. A
+// non-nil status is the server's failure of the call.
+func (c *interopConn) call(service, method string, payload []byte, timeout time.Duration) (status *interopStatus, resp []byte, err error) {
+	id := c.nextID
+	c.nextID += 2
+	frame := appendFrame(nil, id, messageTypeRequest, 0, requestMessage(service, method, payload))
+
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := c.conn.Write(frame); err != nil {
+		return nil, nil, interopConnErr(err)
+	}
+	for {
+		var hb [frameHeaderLength]byte
+		if _, err := io.ReadFull(c.r, hb[:]); err != nil {
+			return nil, nil, interopConnErr(err)
+		}
+		h := parseFrameHeader(hb[:])
+		body := make([]byte, h.length)
+		if _, err := io.ReadFull(c.r, body); err != nil {
+			return nil, nil, interopConnErr(err)
+		}
+		if h.typ == messageTypeResponse && h.streamID == id {
+			return parseResponse(body)
+		}
+	}
+}
+
+// requestMessage encodes a ttrpc Request for method of service, carrying
+// payload.
+func requestMessage(service, method string, payload []byte) []byte {
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendString(req, service)
+	req = protowire.AppendTag(req, 2, protowire.BytesType)
+	req = protowire.AppendString(req, method)
+	req = protowire.AppendTag(req, 3, protowire.BytesType)
+	return protowire.AppendBytes(req, payload)
+}
+
+func interopConnErr(err error) error {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return errNoResponse
+	}
+	return withExit(exitTransport, err)
+}
+
+// interopStatus is the status of a failed call, as carried in a ttrpc response.
+type interopStatus struct {
+	code    codes.Code
+	message string
+}
+
+// parseResponse decodes a ttrpc Response message, which carries a
+// google.rpc.Status in field 1 and the response payload in field 2.
+func parseResponse(b []byte) (*interopStatus, []byte, error) {
+	var (
+		st      *interopStatus
+		payload []byte
+	)
+	err := consumeFields(b, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 1:
+			st = &interopStatus{}
+			return consumeFields(v, func(num protowire.Number, v []byte) error {
+				switch num {
+				case 1:
+					code, n := protowire.ConsumeVarint(v)
+					if n < 0 {
+						return protowire.ParseError(n)
+					}
+					st.code = codes.Code(code)
+				case 2:
+					st.message = string(v)
+				}
+				return nil
+			})
+		case 2:
+			payload = v
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding response: %w", err)
+	}
+	if st != nil && st.code == codes.OK {
+		st = nil
+	}
+	return st, payload, nil
+}
+
+// consumeFields calls fn with the number and value of each field in b. The
+// value is the field's contents for a length-delimited field, and its encoding
+// otherwise.
+func consumeFields(b []byte, fn func(protowire.Number, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v []byte
+		if typ == protowire.BytesType {
+			v, n = protowire.ConsumeBytes(b)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n >= 0 {
+				v = b[:n]
+			}
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runInterop sends each of interopCases to the echo method, encoded by the
+// cfg.encode variant, and decodes the response with it. Against a server whose
+// payload is the other variant, each case shows whether the mismatch goes
+// unnoticed, fails the call, hangs it, or silently corrupts the payload.
+func runInterop(ctx context.Context, cfg interopConfig) error {
+	codec := payloadCodecs[cfg.encode]
+	infof("encoding payloads as %s", cfg.encode)
+	var (
+		conn                            *interopConn
+		dials                           int
+		passed, failed, hung, corrupted int
+		dropped                         int
+		differ                          int
+	)
+	defer func() {
+		if conn != nil {
+			conn.conn.Close()
+		}
+	}()
+	cases := interopCases()
+	for _, c := range cases {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if conn == nil {
+			name := fmt.Sprintf("interop-%d", dials)
+			dials++
+			cfg.tl.record(name, "dial", "%s", cfg.addr)
+			nc, err := cfg.dial()
+			if err != nil {
+				cfg.tl.record(name, "error", "dial: %s", err)
+				return withExit(exitTransport, err)
+			}
+			nc = cfg.tl.wrapConn(nc, name)
+			conn = &interopConn{conn: nc, r: bufio.NewReader(nc), nextID: 1}
+		}
+		req, err := c.encode(codec)
+		if err != nil {
+			return fmt.Errorf("%s: encoding as %s: %w", c.name, cfg.encode, err)
+		}
+		// Where the generators encode a case differently, a server that only
+		// compares bytes would see a mismatch even if decoding is sound.
+		note := ""
+		for _, other := range payloadCodecNames() {
+			if other == cfg.encode {
+				continue
+			}
+			if b, err := c.encode(payloadCodecs[other]); err == nil && !bytes.Equal(b, req) {
+				differ++
+				note = fmt.Sprintf(" (%s encodes it differently)", other)
+			}
+		}
+		st, resp, err := conn.call(serviceName, methodEcho, req, cfg.callTimeout)
+		var outcome string
+		switch {
+		case errors.Is(err, errNoResponse):
+			hung++
+			outcome = fmt.Sprintf("HANG: no response within %v", cfg.callTimeout)
+		case err != nil:
+			dropped++
+			outcome = fmt.Sprintf("DROPPED: %s", err)
+		case st != nil:
+			failed++
+			outcome = fmt.Sprintf("ERROR: %s: %s", st.code, st.message)
+		default:
+			got, err := codec.unmarshal(resp)
+			switch {
+			case err != nil:
+				corrupted++
+				outcome = fmt.Sprintf("CORRUPT: response does not decode as %s: %s", cfg.encode, err)
+			case !got.equal(c.fields):
+				corrupted++
+				outcome = fmt.Sprintf("CORRUPT: response has value %d, %d bytes of data, checksum %08x; sent value %d, %d bytes of data, checksum %08x",
+					got.value, len(got.data), got.checksum, c.fields.value, len(c.fields.data), c.fields.checksum)
+			default:
+				passed++
+				outcome = "ok"
+			}
+		}
+		line := fmt.Sprintf("%-16s %7d bytes: %s%s", c.name, len(req), outcome, note)
+		if outcome == "ok" {
+			infof("%s", line)
+		} else {
+			errorf("%s", line)
+		}
+		// A connection that failed or hung a call cannot be trusted with the
+		// next; a response to the hung call may yet arrive.
+		if err != nil {
+			conn.conn.Close()
+			conn = nil
+		}
+	}
+	infof("%d cases: %d ok, %d failed, %d hung, %d dropped the connection, %d corrupted", len(cases), passed, failed, hung, dropped, corrupted)
+	if differ > 0 {
+		infof("%d cases encode to different bytes under each variant", differ)
+	}
+	switch {
+	case corrupted > 0:
+		return withExit(exitMismatch, fmt.Errorf("%d cases encoded as %s were silently corrupted", corrupted, cfg.encode))
+	case hung > 0:
+		return withExit(exitStalled, fmt.Errorf("%d cases encoded as %s hung for more than %v", hung, cfg.encode, cfg.callTimeout))
+	case dropped > 0:
+		return withExit(exitTransport, fmt.Errorf("%d cases encoded as %s dropped the connection", dropped, cfg.encode))
+	case failed > 0:
+		return withExit(exitCall, fmt.Errorf("%d cases encoded as %s failed", failed, cfg.encode))
+	}
+	infof("PASS: every case encoded as %s round tripped intact", cfg.encode)
+	return nil
+}
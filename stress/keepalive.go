@@ -0,0 +1,113 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/ttrpc"
+)
+
+// idleConn closes the underlying connection if no reads or writes complete
+// within timeout.
+type idleConn struct {
+	net.Conn
+	timeout   time.Duration
+	name      string
+	tl        *timeline
+	last      atomic.Int64
+	timer     *time.Timer
+	closeOnce sync.Once
+}
+
+func newIdleConn(c net.Conn, timeout time.Duration, name string, tl *timeline) *idleConn {
+	ic := &idleConn{Conn: c, timeout: timeout, name: name, tl: tl}
+	ic.touch()
+	ic.timer = time.AfterFunc(timeout, ic.check)
+	return ic
+}
+
+func (c *idleConn) touch() {
+	c.last.Store(time.Now().UnixNano())
+}
+
+func (c *idleConn) check() {
+	idle := time.Since(time.Unix(0, c.last.Load()))
+	if idle < c.timeout {
+		c.timer.Reset(c.timeout - idle)
+		return
+	}
+	infof("closing %s after %v idle", c.name, idle.Round(time.Millisecond))
+	c.tl.record(c.name, "idle-close", "idle for %v", idle.Round(time.Millisecond))
+	c.Close()
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.touch()
+	return n, err
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.touch()
+	return n, err
+}
+
+func (c *idleConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.timer.Stop()
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// idleListener wraps each accepted connection in an idleConn.
+type idleListener struct {
+	net.Listener
+	timeout time.Duration
+	tl      *timeline
+	n       atomic.Int64
+}
+
+func (l *idleListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("conn-%d", l.n.Add(1))
+	return newIdleConn(c, l.timeout, name, l.tl), nil
+}
+
+// keepalive sends a PING call every interval until ctx is cancelled, so that an
+// otherwise idle connection is kept active. client returns the connection to
+// ping, which changes if it is re-dialed. It returns the number of pings that
+// succeeded and failed.
+func keepalive(ctx context.Context, client func() *ttrpc.Client, interval time.Duration, tl *timeline) (ok, failed int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ok, failed
+		case <-ticker.C:
+		}
+		pctx, cancel := context.WithTimeout(ctx, interval)
+		err := client().Call(pctx, serviceName, methodPing, &payload{}, &payload{})
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ok, failed
+			}
+			failed++
+			errorf("keepalive ping failed: %s", err)
+			tl.record("client", "error", "ping: %s", err)
+			continue
+		}
+		ok++
+	}
+}
@@ -0,0 +1,152 @@
+package stress
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// leakSettle is how long to wait after a run before counting resources, so
+	// that goroutines already on their way out have time to exit.
+	leakSettle = 500 * time.Millisecond
+	// leakSlack is the default for how many more goroutines or open files there
+	// may be after a run than before it before a leak is reported, allowing for
+	// runtime and library goroutines that start lazily.
+	leakSlack = 5
+	// leakHeap is the default for how much the live heap may grow over a run
+	// before a leak is reported, allowing for buffers that are kept for reuse.
+	leakHeap = 16 << 20
+)
+
+// leakConfig holds the -leak-check settings, shared by the commands that run
+// traffic.
+type leakConfig struct {
+	enabled bool
+	// slack is how many goroutines or open files may be left behind, and heap
+	// how many bytes the live heap may grow by.
+	slack int
+	heap  int64
+	// sample, if positive, is the interval at which resources are logged
+	// while the run is in progress.
+	sample time.Duration
+}
+
+// leakFlags registers the -leak-check flags. what names the point after which
+// resources are counted, such as "shutdown".
+func leakFlags(fs *flag.FlagSet, what string) *leakConfig {
+	c := &leakConfig{}
+	fs.BoolVar(&c.enabled, "leak-check", false, fmt.Sprintf("Exit with status %d if more than -leak-slack goroutines or open files, or -leak-heap bytes of heap, are left behind after %s", exitLeak, what))
+	fs.IntVar(&c.slack, "leak-slack", leakSlack, "Goroutines or open files that -leak-check allows to be left behind")
+	fs.Int64Var(&c.heap, "leak-heap", leakHeap, "Bytes of live heap growth that -leak-check allows")
+	fs.DurationVar(&c.sample, "leak-sample", 0, "With -leak-check, log goroutines, open files, and heap at this interval while running, to show a leak building up (0 to disable)")
+	return c
+}
+
+func (c *leakConfig) validate() error {
+	switch {
+	case c.slack < 0:
+		return usageErrorf("-leak-slack must not be negative, got %d", c.slack)
+	case c.heap < 0:
+		return usageErrorf("-leak-heap must not be negative, got %d", c.heap)
+	case c.sample < 0:
+		return usageErrorf("-leak-sample must not be negative, got %v", c.sample)
+	}
+	return nil
+}
+
+// resourceCount is a count of the resources a leak in ttrpc would consume.
+type resourceCount struct {
+	goroutines int
+	// files is the number of open file descriptors, or -1 where this cannot
+	// be determined (anywhere without /proc).
+	files int
+	// heap is the live heap, in bytes.
+	heap int64
+}
+
+func countResources() resourceCount {
+	// A second collection frees what the first moved to sync.Pool victim caches,
+	// such as ttrpc's message buffers.
+	runtime.GC()
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	c := resourceCount{goroutines: runtime.NumGoroutine(), files: -1, heap: int64(ms.HeapAlloc)}
+	if fds, err := os.ReadDir("/proc/self/fd"); err == nil {
+		c.files = len(fds)
+	}
+	return c
+}
+
+// sampleResources logs resource counts against before every interval until ctx
+// is cancelled, so that a soak run shows a leak growing rather than only
+// failing at the end.
+func sampleResources(ctx context.Context, role string, before resourceCount, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		now := countResources()
+		infof("%s resources: goroutines %d (%+d), open files %d (%+d), heap %d bytes (%+d)",
+			role, now.goroutines, now.goroutines-before.goroutines, now.files, now.files-before.files, now.heap, now.heap-before.heap)
+	}
+}
+
+// begin counts resources at the start of a run, and with -leak-sample, logs
+// them periodically. It returns a function to call once the run is over, which
+// compares resource counts then against those at the start, and fails if they
+// have grown beyond the configured bounds. A goroutine, file, or buffer leaked
+// per call or per connection will not deadlock a run, but would eventually
+// exhaust a long-lived process.
+func (c *leakConfig) begin(role string) func() error {
+	if !c.enabled {
+		return func() error { return nil }
+	}
+	before := countResources()
+	ctx, cancel := context.WithCancel(context.Background())
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		if c.sample > 0 {
+			sampleResources(ctx, role, before, c.sample)
+		}
+	}()
+	return func() error {
+		cancel()
+		<-sampled
+		return c.check(role, before)
+	}
+}
+
+func (c *leakConfig) check(role string, before resourceCount) error {
+	time.Sleep(leakSettle)
+	after := countResources()
+	infof("%s leak check: goroutines %d before, %d after", role, before.goroutines, after.goroutines)
+	if after.files >= 0 {
+		infof("%s leak check: open files %d before, %d after", role, before.files, after.files)
+	}
+	infof("%s leak check: heap %d bytes before, %d after", role, before.heap, after.heap)
+	var leaks []string
+	if d := after.goroutines - before.goroutines; d > c.slack {
+		leaks = append(leaks, fmt.Sprintf("%d goroutines", d))
+	}
+	if d := after.files - before.files; d > c.slack {
+		leaks = append(leaks, fmt.Sprintf("%d open files", d))
+	}
+	if d := after.heap - before.heap; d > c.heap {
+		leaks = append(leaks, fmt.Sprintf("%d bytes of heap", d))
+	}
+	if len(leaks) == 0 {
+		return nil
+	}
+	return withExit(exitLeak, fmt.Errorf("leaked %s", strings.Join(leaks, ", ")))
+}
@@ -0,0 +1,150 @@
+package stress
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ledgerExamples is the number of sequence numbers given as examples of each
+// kind of discrepancy a responseLedger reports.
+const ledgerExamples = 10
+
+// responseLedger accounts for every unary call a client makes, by the sequence
+// number its request carries. Each must end exactly once: answered by a
+// response echoing its sequence number, or failed. A response that reaches the
+// wrong call fails that call's verification, but the ledger also shows which
+// call it was meant for, whether that call was answered twice, and whether any
+// call was never answered at all.
+//
+// A nil *responseLedger accounts for nothing.
+type responseLedger struct {
+	issued atomic.Uint64
+	mu     sync.Mutex
+	// done has a bit for each sequence number from base upwards, set once its
+	// call has ended. Every call below base has ended; done is trimmed from the
+	// front as its bits fill, so that a long run does not hold one for every
+	// call it has made.
+	base uint64
+	done []uint64
+	// duplicates are the sequence numbers that ended more than once, and
+	// unknown those of responses to requests never sent, with counts.
+	duplicates, unknown  []uint64
+	nDuplicate, nUnknown int64
+}
+
+func newResponseLedger() *responseLedger {
+	return &responseLedger{base: 1}
+}
+
+// issue returns the sequence number for a new call. Sequence numbers start at
+// 1, so that zero means a message carries none.
+func (l *responseLedger) issue() uint64 {
+	if l == nil {
+		return 0
+	}
+	return l.issued.Add(1)
+}
+
+// answered records that a response carrying seq arrived.
+func (l *responseLedger) answered(seq uint64) {
+	l.end(seq)
+}
+
+// failed records that the call seq ended without a response.
+func (l *responseLedger) failed(seq uint64) {
+	l.end(seq)
+}
+
+func (l *responseLedger) end(seq uint64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if seq == 0 || seq > l.issued.Load() {
+		l.nUnknown++
+		if len(l.unknown) < ledgerExamples {
+			l.unknown = append(l.unknown, seq)
+		}
+		return
+	}
+	if seq < l.base {
+		l.duplicate(seq)
+		return
+	}
+	i := seq - l.base
+	for uint64(len(l.done)) <= i/64 {
+		l.done = append(l.done, 0)
+	}
+	bit := uint64(1) << (i % 64)
+	if l.done[i/64]&bit != 0 {
+		l.duplicate(seq)
+		return
+	}
+	l.done[i/64] |= bit
+	for len(l.done) > 0 && l.done[0] == ^uint64(0) {
+		l.done = l.done[1:]
+		l.base += 64
+	}
+}
+
+func (l *responseLedger) duplicate(seq uint64) {
+	l.nDuplicate++
+	if len(l.duplicates) < ledgerExamples {
+		l.duplicates = append(l.duplicates, seq)
+	}
+}
+
+// check fails if any call ended more than once, any response was for a
+// request never sent, or any call never ended. It is only meaningful once
+// every call has returned.
+func (l *responseLedger) check() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var (
+		missing  []uint64
+		nMissing int64
+	)
+	for seq := l.base; seq <= l.issued.Load(); seq++ {
+		i := seq - l.base
+		if i/64 < uint64(len(l.done)) && l.done[i/64]&(uint64(1)<<(i%64)) != 0 {
+			continue
+		}
+		nMissing++
+		if len(missing) < ledgerExamples {
+			missing = append(missing, seq)
+		}
+	}
+	var problems []string
+	if l.nDuplicate > 0 {
+		problems = append(problems, fmt.Sprintf("%d calls answered or failed more than once (sequence %s)", l.nDuplicate, sequenceList(l.duplicates, l.nDuplicate)))
+	}
+	if l.nUnknown > 0 {
+		problems = append(problems, fmt.Sprintf("%d responses for requests never sent (sequence %s)", l.nUnknown, sequenceList(l.unknown, l.nUnknown)))
+	}
+	if nMissing > 0 {
+		problems = append(problems, fmt.Sprintf("%d calls never answered (sequence %s)", nMissing, sequenceList(missing, nMissing)))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return withExit(exitMismatch, fmt.Errorf("response ledger: %s", strings.Join(problems, "; ")))
+}
+
+// sequenceList formats the examples of n sequence numbers.
+func sequenceList(examples []uint64, n int64) string {
+	parts := make([]string, len(examples))
+	for i, seq := range examples {
+		parts[i] = fmt.Sprint(seq)
+	}
+	s := strings.Join(parts, ", ")
+	if n > int64(len(examples)) {
+		s += ", ..."
+	}
+	return s
+}
@@ -0,0 +1,110 @@
+package stress
+
+import "testing"
+
+func TestResponseLedger(t *testing.T) {
+	seqs := func(from, to uint64) []uint64 {
+		var s []uint64
+		for seq := from; seq <= to; seq++ {
+			s = append(s, seq)
+		}
+		return s
+	}
+	reversed := seqs(1, 130)
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	tests := []struct {
+		name   string
+		issued int
+		ended  []uint64
+		want   string
+	}{
+		{
+			name:   "all ended",
+			issued: 3,
+			ended:  []uint64{2, 1, 3},
+		},
+		{
+			name:   "ended out of order across words",
+			issued: 130,
+			ended:  reversed,
+		},
+		{
+			name:   "duplicate",
+			issued: 2,
+			ended:  []uint64{1, 1, 2},
+			want:   "response ledger: 1 calls answered or failed more than once (sequence 1)",
+		},
+		{
+			name:   "duplicate below the trimmed base",
+			issued: 65,
+			ended:  append(seqs(1, 65), 5),
+			want:   "response ledger: 1 calls answered or failed more than once (sequence 5)",
+		},
+		{
+			name:   "never sent",
+			issued: 1,
+			ended:  []uint64{1, 0, 7},
+			want:   "response ledger: 2 responses for requests never sent (sequence 0, 7)",
+		},
+		{
+			name:   "never answered",
+			issued: 3,
+			ended:  []uint64{2},
+			want:   "response ledger: 2 calls never answered (sequence 1, 3)",
+		},
+		{
+			name:   "more never answered than examples",
+			issued: ledgerExamples + 2,
+			want:   "response ledger: 12 calls never answered (sequence 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, ...)",
+		},
+		{
+			name:   "every kind",
+			issued: 3,
+			ended:  []uint64{1, 1, 9},
+			want:   "response ledger: 1 calls answered or failed more than once (sequence 1); 1 responses for requests never sent (sequence 9); 2 calls never answered (sequence 2, 3)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newResponseLedger()
+			for i := 0; i < tt.issued; i++ {
+				if seq, want := l.issue(), uint64(i+1); seq != want {
+					t.Fatalf("issue() = %d, want %d", seq, want)
+				}
+			}
+			for i, seq := range tt.ended {
+				if i%2 == 0 {
+					l.answered(seq)
+				} else {
+					l.failed(seq)
+				}
+			}
+			err := l.check()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("check() = %v, want nil", err)
+			case tt.want == "":
+			case err == nil:
+				t.Fatalf("check() = nil, want %q", tt.want)
+			case err.Error() != tt.want:
+				t.Fatalf("check() = %q, want %q", err, tt.want)
+			case exitCode(err) != exitMismatch:
+				t.Fatalf("exitCode(check()) = %d, want %d", exitCode(err), exitMismatch)
+			}
+		})
+	}
+}
+
+func TestNilResponseLedger(t *testing.T) {
+	var l *responseLedger
+	if seq := l.issue(); seq != 0 {
+		t.Errorf("issue() = %d, want 0", seq)
+	}
+	l.answered(1)
+	l.failed(2)
+	if err := l.check(); err != nil {
+		t.Errorf("check() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,44 @@
+package stress
+
+import (
+	"context"
+	"net"
+)
+
+// startLoopback starts a server with default settings on a private listener of
+// the named local transport, speaking protocol, for a client in the same process
+// to run against.
+// It returns a function to dial the server, and a stop function that shuts the
+// server down, cleans up, and returns the server's error.
+func startLoopback(ctx context.Context, transport, protocol string, tl *timeline) (dial func() (net.Conn, error), stop func() error, err error) {
+	var t *localTransport
+	var names []string
+	for _, lt := range localTransports() {
+		lt := lt
+		if lt.name == transport {
+			t = &lt
+		}
+		names = append(names, lt.name)
+	}
+	if t == nil {
+		return nil, nil, usageErrorf("unknown loopback transport %q, expected one of %v", transport, names)
+	}
+	l, dial, cleanup, err := t.listen()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	serverErr := make(chan error, 1)
+	go func() {
+		cfg := ServerOptions{Protocol: protocol}.config(transport)
+		cfg.tl = tl
+		serverErr <- serve(ctx, l, cfg)
+	}()
+	return dial, func() error {
+		cancel()
+		err := <-serverErr
+		l.Close()
+		cleanup()
+		return err
+	}, nil
+}
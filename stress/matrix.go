@@ -0,0 +1,339 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// matrixStartTimeout bounds how long a server may take to start accepting
+	// connections.
+	matrixStartTimeout = 10 * time.Second
+	// matrixStopTimeout bounds how long a server may take to exit once asked to,
+	// before it is killed.
+	matrixStopTimeout = 10 * time.Second
+)
+
+func matrixCommand(fs *flag.FlagSet) func(context.Context) error {
+	var (
+		cfg                    matrixConfig
+		binaries               string
+		clients, servers       string
+		clientArgs, serverArgs string
+	)
+	fs.StringVar(&binaries, "binaries", "", "Comma-separated list of ttrpcstress binaries, each built against a different ttrpc version, to run as both clients and servers")
+	fs.StringVar(&clients, "clients", "", "Comma-separated list of binaries to run as clients (default -binaries)")
+	fs.StringVar(&servers, "servers", "", "Comma-separated list of binaries to run as servers (default -binaries)")
+	fs.StringVar(&clientArgs, "client-args", "-iters 100000 -workers 100", "Flags to pass to each client, after -addr, separated by spaces")
+	fs.StringVar(&serverArgs, "server-args", "", "Flags to pass to each server, after -addr, separated by spaces")
+	fs.StringVar(&cfg.transport, "transport", defaultMatrixTransport(), "Transport to connect each pair over: \"tcp\", \"unix\", or on Windows \"npipe\", each on a private address")
+	fs.DurationVar(&cfg.timeout, "timeout", 2*time.Minute, "Kill a client still running after this long, and count the pair as deadlocked")
+	fs.StringVar(&cfg.logDir, "log-dir", "", "Write each client's and server's output to a file in this directory (default to discard it)")
+	return func(ctx context.Context) error {
+		if clients == "" {
+			clients = binaries
+		}
+		if servers == "" {
+			servers = binaries
+		}
+		cfg.clients, cfg.servers = splitList(clients), splitList(servers)
+		cfg.clientArgs, cfg.serverArgs = strings.Fields(clientArgs), strings.Fields(serverArgs)
+		switch {
+		case len(cfg.clients) == 0 || len(cfg.servers) == 0:
+			return usageErrorf("-binaries, or -clients and -servers, are required")
+		case cfg.transport != "tcp" && cfg.transport != "unix" && !(cfg.transport == "npipe" && runtime.GOOS == "windows"):
+			return usageErrorf("-transport must be tcp, unix, or on Windows npipe, got %q", cfg.transport)
+		case cfg.timeout <= 0:
+			return usageErrorf("-timeout must be positive, got %v", cfg.timeout)
+		}
+		for _, b := range append(append([]string{}, cfg.clients...), cfg.servers...) {
+			if _, err := exec.LookPath(b); err != nil {
+				return usageErrorf("%s", err)
+			}
+		}
+		if cfg.logDir != "" {
+			if err := os.MkdirAll(cfg.logDir, 0o755); err != nil {
+				return err
+			}
+		}
+		return runMatrix(ctx, cfg)
+	}
+}
+
+// matrixConfig holds the settings for a version matrix run.
+type matrixConfig struct {
+	clients, servers       []string
+	clientArgs, serverArgs []string
+	transport              string
+	timeout                time.Duration
+	logDir                 string
+}
+
+func defaultMatrixTransport() string {
+	if runtime.GOOS == "windows" {
+		return "npipe"
+	}
+	return "unix"
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// runMatrix runs every client binary against every server binary in turn, and
+// prints a table of how each pair fared. This automates building ttrpcstress
+// against several ttrpc versions and comparing them, including mismatched
+// versions between client and server, as the known issues call for.
+func runMatrix(ctx context.Context, cfg matrixConfig) error {
+	dir, err := os.MkdirTemp("", "ttrpcstress-matrix")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	clientNames, serverNames := binaryNames(cfg.clients), binaryNames(cfg.servers)
+	outcomes := make([][]string, len(cfg.clients))
+	var failed, n int
+	for i, client := range cfg.clients {
+		outcomes[i] = make([]string, len(cfg.servers))
+		for j, server := range cfg.servers {
+			pair := fmt.Sprintf("%s -> %s", clientNames[i], serverNames[j])
+			addr, err := matrixAddr(cfg.transport, dir, n)
+			if err != nil {
+				return err
+			}
+			logName := fmt.Sprintf("%02d-%s-%s", n, clientNames[i], serverNames[j])
+			n++
+			start := time.Now()
+			outcome, detail, err := runMatrixPair(ctx, cfg, client, server, addr, logName)
+			if err != nil {
+				return fmt.Errorf("%s: %w", pair, err)
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			outcomes[i][j] = outcome
+			line := fmt.Sprintf("%s: %s in %v", pair, detail, time.Since(start).Round(time.Millisecond))
+			if outcome == "pass" {
+				infof("%s", line)
+			} else {
+				failed++
+				errorf("%s", line)
+			}
+		}
+	}
+	printMatrix(os.Stdout, clientNames, serverNames, outcomes)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d client/server pairs did not pass", failed, n)
+	}
+	infof("PASS: all %d client/server pairs passed", n)
+	return nil
+}
+
+// runMatrixPair starts server listening on addr, runs client against it, and
+// stops the server. The outcome is "pass", "deadlock" if the client reported
+// stalled calls or had to be killed, or otherwise "fail" with the reason for
+// the client's exit status; detail describes it for the log. An error is
+// returned only if the pair could not be run at all.
+func runMatrixPair(ctx context.Context, cfg matrixConfig, client, server, addr, logName string) (outcome, detail string, err error) {
+	serverLog, closeServerLog, err := matrixLog(cfg.logDir, logName+"-server.log")
+	if err != nil {
+		return "", "", err
+	}
+	defer closeServerLog()
+	srv, err := startMatrixServer(server, append([]string{"server", "-addr", addr}, cfg.serverArgs...), serverLog)
+	if err != nil {
+		return "", "", err
+	}
+	defer srv.stop()
+	if err := srv.waitReady(ctx, addr); err != nil {
+		return "fail: server", fmt.Sprintf("FAIL: server did not start: %s", err), nil
+	}
+
+	clientLog, closeClientLog, err := matrixLog(cfg.logDir, logName+"-client.log")
+	if err != nil {
+		return "", "", err
+	}
+	defer closeClientLog()
+	cctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(cctx, client, append([]string{"client", "-addr", addr}, cfg.clientArgs...)...)
+	cmd.Stdout, cmd.Stderr = clientLog, clientLog
+	err = cmd.Run()
+	var eerr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return "", "", ctx.Err()
+	case cctx.Err() != nil:
+		return "deadlock", fmt.Sprintf("DEADLOCK: client killed after %v", cfg.timeout), nil
+	case err == nil:
+		return "pass", "pass", nil
+	case !errors.As(err, &eerr):
+		return "", "", err
+	case eerr.ExitCode() == exitStalled:
+		return "deadlock", fmt.Sprintf("DEADLOCK: client exited with status %d (%s)", exitStalled, exitReason(exitStalled)), nil
+	}
+	reason := exitReason(eerr.ExitCode())
+	return "fail: " + reason, fmt.Sprintf("FAIL: client exited with status %d (%s)", eerr.ExitCode(), reason), nil
+}
+
+// matrixAddr returns a private address on transport for the nth pair.
+func matrixAddr(transport, dir string, n int) (string, error) {
+	switch transport {
+	case "tcp":
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", err
+		}
+		defer l.Close()
+		return "tcp://" + l.Addr().String(), nil
+	case "npipe":
+		return fmt.Sprintf("npipe://./pipe/ttrpcstress-matrix-%d-%d", os.Getpid(), n), nil
+	}
+	return "unix://" + filepath.Join(dir, fmt.Sprintf("%d.sock", n)), nil
+}
+
+// matrixLog returns a writer for a process's output, to a file named name in
+// dir, or discarding it if dir is empty.
+func matrixLog(dir, name string) (io.Writer, func(), error) {
+	if dir == "" {
+		return io.Discard, func() {}, nil
+	}
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { f.Close() }, nil
+}
+
+// matrixServer is a server process run for a pair.
+type matrixServer struct {
+	cmd *exec.Cmd
+	// done is closed once the process has exited, with err its result.
+	done chan struct{}
+	err  error
+}
+
+func startMatrixServer(path string, args []string, log io.Writer) (*matrixServer, error) {
+	s := &matrixServer{cmd: exec.Command(path, args...), done: make(chan struct{})}
+	s.cmd.Stdout, s.cmd.Stderr = log, log
+	if err := s.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		s.err = s.cmd.Wait()
+		close(s.done)
+	}()
+	return s, nil
+}
+
+// waitReady waits until addr accepts connections, failing if the server exits
+// first or takes longer than matrixStartTimeout.
+func (s *matrixServer) waitReady(ctx context.Context, addr string) error {
+	deadline := time.Now().Add(matrixStartTimeout)
+	for {
+		conn, err := dial(addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not accepting connections after %v: %w", matrixStartTimeout, err)
+		}
+		select {
+		case <-s.done:
+			if s.err == nil {
+				return errors.New("exited")
+			}
+			return s.err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// stop asks the server to shut down, as on SIGTERM, and kills it if it has not
+// exited within matrixStopTimeout. Where signals cannot be sent, as on Windows,
+// it is killed at once.
+func (s *matrixServer) stop() {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	if err := s.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		s.cmd.Process.Kill()
+	}
+	select {
+	case <-s.done:
+		return
+	case <-time.After(matrixStopTimeout):
+	}
+	warnf("server %s did not exit within %v, killing it", s.cmd.Path, matrixStopTimeout)
+	s.cmd.Process.Kill()
+	<-s.done
+}
+
+// binaryNames returns a short name for each binary: its base name, or the
+// path as given where base names collide.
+func binaryNames(paths []string) []string {
+	count := make(map[string]int)
+	for _, p := range paths {
+		count[filepath.Base(p)]++
+	}
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		names[i] = filepath.Base(p)
+		if count[names[i]] > 1 {
+			names[i] = p
+		}
+	}
+	return names
+}
+
+// printMatrix writes a table of outcomes, with a row for each client and a
+// column for each server.
+func printMatrix(w io.Writer, clients, servers []string, outcomes [][]string) {
+	const corner = "client \\ server"
+	widths := make([]int, len(servers)+1)
+	widths[0] = len(corner)
+	for _, c := range clients {
+		widths[0] = max(widths[0], len(c))
+	}
+	for j, s := range servers {
+		widths[j+1] = len(s)
+		for i := range clients {
+			widths[j+1] = max(widths[j+1], len(outcomes[i][j]))
+		}
+	}
+	row := func(cells []string) {
+		for k, c := range cells {
+			if k == len(cells)-1 {
+				fmt.Fprintln(w, c)
+			} else {
+				fmt.Fprintf(w, "%-*s  ", widths[k], c)
+			}
+		}
+	}
+	row(append([]string{corner}, servers...))
+	for i, c := range clients {
+		row(append([]string{c}, outcomes[i]...))
+	}
+}
@@ -0,0 +1,320 @@
+package stress
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func maxSizeCommand(fs *flag.FlagSet) func(context.Context) error {
+	var (
+		cfg            maxSizeConfig
+		loopback       bool
+		loopbackVia    string
+		connectTimeout time.Duration
+	)
+	fs.StringVar(&cfg.addr, "addr", "", "Address of a server with default response settings to connect to (tcp://HOST:PORT, unix://PATH, npipe://./pipe/NAME, hvsock://VMID:SERVICE, or vsock://CID:PORT; required unless -loopback)")
+	fs.DurationVar(&connectTimeout, "connect-timeout", 10*time.Second, "Give up on connecting after this long (0 to wait as long as the OS allows)")
+	fs.BoolVar(&loopback, "loopback", false, "Run against a server started in this process, rather than connecting to -addr")
+	fs.StringVar(&loopbackVia, "loopback-transport", "inproc", "Transport for -loopback: \"inproc\", \"tcp\", \"unix\", or on Windows \"npipe\", each on a private address")
+	fs.IntVar(&cfg.limit, "limit", ttrpcMaxMessageSize, "Message size limit to test around, in bytes; released ttrpc versions fix it at 4 MiB, but a build with a different limit can be tested by giving it here")
+	fs.DurationVar(&cfg.callTimeout, "call-timeout", 10*time.Second, "Count a call that neither completes nor fails within this long as hung")
+	timelinePath := timelineFlag(fs)
+	return func(ctx context.Context) error {
+		switch {
+		case cfg.addr == "" && !loopback:
+			return usageErrorf("-addr or -loopback is required")
+		case cfg.addr != "" && loopback:
+			return usageErrorf("-addr cannot be combined with -loopback")
+		case loopbackVia != "inproc" && !loopback:
+			return usageErrorf("-loopback-transport requires -loopback")
+		case cfg.limit < maxSizeMinLimit:
+			return usageErrorf("-limit must be at least %d, got %d", maxSizeMinLimit, cfg.limit)
+		case cfg.callTimeout <= 0:
+			return usageErrorf("-call-timeout must be positive, got %v", cfg.callTimeout)
+		case connectTimeout < 0:
+			return usageErrorf("-connect-timeout must not be negative, got %v", connectTimeout)
+		}
+		tl, err := newTimeline(*timelinePath)
+		if err != nil {
+			return err
+		}
+		defer tl.close()
+		cfg.tl = tl
+		stopServer := func() error { return nil }
+		if loopback {
+			cfg.dial, stopServer, err = startLoopback(ctx, loopbackVia, protocolTTRPC, tl)
+			if err != nil {
+				return err
+			}
+		} else {
+			addr := cfg.addr
+			cfg.dial = func() (net.Conn, error) { return dial(addr, connectTimeout) }
+		}
+		err = runMaxSize(ctx, cfg)
+		if serverErr := stopServer(); err == nil {
+			err = serverErr
+		}
+		return err
+	}
+}
+
+// maxSizeMinLimit is the smallest -limit accepted. Below it, the calibration
+// calls made a little under the limit would have shorter length prefixes than
+// those at the limit, and so a different framing overhead.
+const maxSizeMinLimit = 1 << 22
+
+// maxSizeConfig holds the settings for a message size boundary run.
+type maxSizeConfig struct {
+	addr  string
+	dial  func() (net.Conn, error)
+	limit int
+	// callTimeout is how long a call may take before it is counted as hung.
+	callTimeout time.Duration
+	tl          *timeline
+}
+
+// frameConn wraps a client connection, recording the length of the last
+// request frame sent and the last response frame received. Frames over the
+// limit are recorded too, as the receiver reads their header before it
+// discards them.
+type frameConn struct {
+	net.Conn
+	mu                sync.Mutex
+	sent, received    frameScanner
+	lastReq, lastResp atomic.Int64
+}
+
+func (c *frameConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.mu.Lock()
+	c.sent.scan(b[:n], func(_ int, h frameHeader, _ []byte) {
+		if h.typ == messageTypeRequest {
+			c.lastReq.Store(int64(h.length))
+		}
+	})
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *frameConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	c.received.scan(b[:n], func(_ int, h frameHeader, _ []byte) {
+		if h.typ == messageTypeResponse {
+			c.lastResp.Store(int64(h.length))
+		}
+	})
+	c.mu.Unlock()
+	return n, err
+}
+
+// maxSizeConn is a connection under test, redialed if a case leaves it
+// unusable.
+type maxSizeConn struct {
+	cfg    maxSizeConfig
+	fc     *frameConn
+	client *ttrpc.Client
+	dials  int
+}
+
+func (c *maxSizeConn) redial() error {
+	if c.client != nil {
+		c.client.Close()
+	}
+	name := fmt.Sprintf("maxsize-%d", c.dials)
+	c.dials++
+	c.cfg.tl.record(name, "dial", "%s", c.cfg.addr)
+	conn, err := c.cfg.dial()
+	if err != nil {
+		c.cfg.tl.record(name, "error", "dial: %s", err)
+		return withExit(exitTransport, err)
+	}
+	c.fc = &frameConn{Conn: c.cfg.tl.wrapConn(conn, name)}
+	c.client = ttrpc.NewClient(c.fc)
+	return nil
+}
+
+// errHung is returned by maxSizeConn.call for a call that did not return
+// within the call timeout.
+var errHung = errors.New("hung")
+
+// call sends req to method and waits up to the call timeout for it to return.
+// The call is given no deadline of its own, which would add a timeout field of
+// varying size to the request frame; a hung call is abandoned, and the
+// connection with it.
+func (c *maxSizeConn) call(ctx context.Context, service, method string, req, resp *payload) error {
+	done := make(chan error, 1)
+	go func() { done <- c.client.Call(ctx, service, method, req, resp) }()
+	t := time.NewTimer(c.cfg.callTimeout)
+	defer t.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-t.C:
+		return errHung
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runMaxSize sends requests, and asks for responses, whose frames are one byte
+// under, at, and one byte over cfg.limit. Those within the limit must round
+// trip intact, and those over it must fail with codes.ResourceExhausted, in
+// either case without hanging and leaving the connection usable. The framing
+// overhead is measured with a call a little under the limit, so that the frames
+// hit each size exactly whatever ttrpc version or payload encoding is in use.
+func runMaxSize(ctx context.Context, cfg maxSizeConfig) error {
+	c := &maxSizeConn{cfg: cfg}
+	if err := c.redial(); err != nil {
+		return err
+	}
+	defer func() { c.client.Close() }()
+	base := filler(cfg.limit + 1024)
+
+	// Calibrate with an echo, for the request framing, and a fill, for the
+	// response framing.
+	probe := base[:cfg.limit-1024]
+	probeReq := &payload{Value: 1, Data: probe, Checksum: checksum(probe)}
+	if err := c.call(ctx, serviceName, methodEcho, probeReq, &payload{}); err != nil {
+		return fmt.Errorf("calibrating request framing: %s", maxSizeOutcome(err))
+	}
+	reqOverhead := int(c.fc.lastReq.Load()) - payloadSize(probeReq)
+	n := uint32(cfg.limit - 1024)
+	if err := c.call(ctx, auxServiceName, methodFill, &payload{Value: n}, &payload{}); err != nil {
+		return fmt.Errorf("calibrating response framing: %s", maxSizeOutcome(err))
+	}
+	respOverhead := int(c.fc.lastResp.Load()) - payloadSize(fillResponse(&payload{Value: n}, base[:n]))
+	infof("framing overhead: %d bytes per request, %d per response", reqOverhead, respOverhead)
+
+	var unexpected, hung int
+	for _, dir := range []string{"request", "response"} {
+		for _, delta := range []int{-1, 0, 1} {
+			size := cfg.limit + delta
+			var (
+				err  error
+				got  int64
+				bad  error
+				resp = &payload{}
+			)
+			if dir == "request" {
+				req, found := sizedRequest(base, size-reqOverhead)
+				if !found {
+					return fmt.Errorf("no request payload encodes to %d bytes", size-reqOverhead)
+				}
+				err = c.call(ctx, serviceName, methodEcho, req, resp)
+				got = c.fc.lastReq.Load()
+				if err == nil && (resp.Value != req.Value || !bytes.Equal(resp.Data, req.Data)) {
+					bad = fmt.Errorf("response does not echo the request")
+				}
+			} else {
+				req, found := sizedFill(base, size-respOverhead)
+				if !found {
+					return fmt.Errorf("no response payload encodes to %d bytes", size-respOverhead)
+				}
+				n := req.Value
+				err = c.call(ctx, auxServiceName, methodFill, req, resp)
+				got = c.fc.lastResp.Load()
+				if err == nil && (resp.Value != n || !bytes.Equal(resp.Data, base[:n])) {
+					bad = fmt.Errorf("response does not carry the %d bytes of data asked for", n)
+				}
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			healthy := true
+			switch {
+			case errors.Is(err, errHung):
+				hung++
+				healthy = false
+			case got != int64(size):
+				bad = fmt.Errorf("frame was %d bytes, not %d", got, size)
+			case bad != nil:
+			case delta <= 0 && err != nil:
+				bad = fmt.Errorf("expected success")
+			case delta > 0 && status.Code(err) != codes.ResourceExhausted:
+				bad = fmt.Errorf("expected %s", codes.ResourceExhausted)
+			}
+			// The connection must survive, whatever became of the call.
+			if healthy {
+				if err := c.call(ctx, serviceName, methodPing, &payload{}, &payload{}); err != nil {
+					if bad == nil {
+						bad = fmt.Errorf("connection unusable afterwards: %s", maxSizeOutcome(err))
+					}
+					healthy = false
+				}
+			}
+			line := fmt.Sprintf("%-8s frame %d (limit%+d): %s", dir, size, delta, maxSizeOutcome(err))
+			if bad != nil {
+				unexpected++
+				errorf("%s; %s", line, bad)
+			} else {
+				infof("%s", line)
+			}
+			if !healthy {
+				if err := c.redial(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	switch {
+	case hung > 0:
+		return withExit(exitStalled, fmt.Errorf("%d calls hung for more than %v", hung, cfg.callTimeout))
+	case unexpected > 0:
+		return withExit(exitMismatch, fmt.Errorf("%d cases had unexpected outcomes", unexpected))
+	}
+	infof("PASS: messages up to %d bytes succeeded, and larger ones failed cleanly", cfg.limit)
+	return nil
+}
+
+// maxSizeOutcome describes how a call ended.
+func maxSizeOutcome(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	if s, isStatus := status.FromError(err); isStatus {
+		return fmt.Sprintf("%s: %s", s.Code(), s.Message())
+	}
+	return err.Error()
+}
+
+// sizedRequest returns an echo request whose encoding is exactly size bytes,
+// with data taken from the start of base. A few request values are tried, as
+// the varint encodings of the value and checksum can skip a size.
+func sizedRequest(base []byte, size int) (*payload, bool) {
+	for _, v := range []uint32{1, 1 << 7, 1 << 14, 1 << 21, 1 << 28} {
+		for d := max(size-16, 0); d <= size && d <= len(base); d++ {
+			p := &payload{Value: v, Data: base[:d], Checksum: checksum(base[:d])}
+			if payloadSize(p) == size {
+				return p, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// sizedFill returns a methodFill request whose response encodes to exactly size
+// bytes. The response echoes the request's sequence, and hashes it with the
+// data into a varint that can skip a size, so a few sequences are tried.
+func sizedFill(base []byte, size int) (*payload, bool) {
+	for seq := uint64(0); seq < 64; seq++ {
+		for n := max(size-32, 0); n <= size && n <= len(base); n++ {
+			req := &payload{Value: uint32(n), Sequence: seq}
+			if payloadSize(fillResponse(req, base[:n])) == size {
+				return req, true
+			}
+		}
+	}
+	return nil, false
+}
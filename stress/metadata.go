@@ -0,0 +1,103 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The metadata attached to calls with the client's -metadata-keys. Each call
+// carries that many entries named metadataPrefix followed by an index, and
+// metadataShapeKey giving their number and size as "KEYSxBYTES", so that the
+// server can tell dropped, duplicated, truncated, or corrupted entries, and
+// entries from another call, apart.
+const (
+	metadataPrefix   = "ttrpcstress-md-"
+	metadataShapeKey = metadataPrefix + "shape"
+)
+
+// callMetadata returns the metadata sent with the request carrying value: keys
+// entries, each the value followed by filler up to size bytes. Metadata is
+// carried in protobuf strings, so the filler is printable.
+func callMetadata(value uint32, keys, size int) ttrpc.MD {
+	md := make(ttrpc.MD, keys+1)
+	md.Set(metadataShapeKey, fmt.Sprintf("%dx%d", keys, size))
+	for i := 0; i < keys; i++ {
+		md.Set(metadataPrefix+strconv.Itoa(i), metadataValue(value, i, size))
+	}
+	return md
+}
+
+func metadataValue(value uint32, key, size int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d:%d:", value, key)
+	for b.Len() < size {
+		b.WriteByte('a' + byte(b.Len()%26))
+	}
+	return b.String()
+}
+
+// checkMetadata verifies that md is what callMetadata sent with the request
+// carrying value.
+func checkMetadata(md ttrpc.MD, value uint32) error {
+	shape, ok := md.Get(metadataShapeKey)
+	if !ok || len(shape) != 1 {
+		return fmt.Errorf("expected one %s entry, got %d", metadataShapeKey, len(shape))
+	}
+	var keys, size int
+	if _, err := fmt.Sscanf(shape[0], "%dx%d", &keys, &size); err != nil || keys < 0 {
+		return fmt.Errorf("bad %s %q", metadataShapeKey, shape[0])
+	}
+	found := 0
+	for key, values := range md {
+		if !strings.HasPrefix(key, metadataPrefix) || key == metadataShapeKey {
+			continue
+		}
+		i, err := strconv.Atoi(strings.TrimPrefix(key, metadataPrefix))
+		if err != nil || i < 0 || i >= keys {
+			return fmt.Errorf("unexpected key %q", key)
+		}
+		if len(values) != 1 {
+			return fmt.Errorf("expected one value for %q, got %d", key, len(values))
+		}
+		if want := metadataValue(value, i, size); values[0] != want {
+			return fmt.Errorf("value of %q begins %.32q, expected %.32q", key, values[0], want)
+		}
+		found++
+	}
+	if found != keys {
+		return fmt.Errorf("expected %d entries, got %d", keys, found)
+	}
+	return nil
+}
+
+// metadataInterceptor verifies the metadata of calls that carry it, and counts
+// them in checked. The request is decoded once here for its value, and again by
+// the method. A call whose metadata is wrong fails with codes.DataLoss, as one
+// whose data is.
+func metadataInterceptor(checked *atomic.Int64) ttrpc.UnaryServerInterceptor {
+	return func(ctx context.Context, unmarshal ttrpc.Unmarshaler, info *ttrpc.UnaryServerInfo, method ttrpc.Method) (interface{}, error) {
+		md, ok := ttrpc.GetMetadata(ctx)
+		if !ok {
+			return method(ctx, unmarshal)
+		}
+		if _, ok := md.Get(metadataShapeKey); !ok {
+			return method(ctx, unmarshal)
+		}
+		req := &payload{}
+		if err := unmarshal(req); err != nil {
+			return nil, err
+		}
+		checked.Add(1)
+		if err := checkMetadata(md, req.Value); err != nil {
+			return nil, status.Errorf(codes.DataLoss, "request %d: metadata: %s", req.Value, err)
+		}
+		return method(ctx, unmarshal)
+	}
+}
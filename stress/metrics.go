@@ -0,0 +1,191 @@
+package stress
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/ttrpc"
+)
+
+// metricsWriter writes metrics in the Prometheus text exposition format, so a
+// long soak run can be scraped, graphed, and alerted on while it runs. It is
+// written by hand, as a handful of counters does not justify the dependency on
+// the Prometheus client library.
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (m *metricsWriter) header(name, help, typ string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one sample of name. labels, if not empty, is a list of
+// label="value" pairs without the enclosing braces.
+func (m *metricsWriter) sample(name, labels string, v float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	fmt.Fprintf(m.w, "%s %s\n", name, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (m *metricsWriter) counter(name, help string, v int64) {
+	m.header(name, help, "counter")
+	m.sample(name, "", float64(v))
+}
+
+func (m *metricsWriter) gauge(name, help string, v float64) {
+	m.header(name, help, "gauge")
+	m.sample(name, "", v)
+}
+
+// histogram writes h as a histogram of durations in seconds.
+func (m *metricsWriter) histogram(name, help string, h *latencyHistogram) {
+	m.header(name, help, "histogram")
+	var cumulative int64
+	for i, upper := range latencyBuckets {
+		cumulative += h.counts[i].Load()
+		m.sample(name+"_bucket", fmt.Sprintf("le=%q", strconv.FormatFloat(upper.Seconds(), 'g', -1, 64)), float64(cumulative))
+	}
+	cumulative += h.counts[len(latencyBuckets)].Load()
+	m.sample(name+"_bucket", `le="+Inf"`, float64(cumulative))
+	m.sample(name+"_sum", "", time.Duration(h.sum.Load()).Seconds())
+	m.sample(name+"_count", "", float64(h.count.Load()))
+}
+
+// latencyBuckets are the upper bounds of a latencyHistogram's buckets, in the
+// same 1-2-5 series as the latency histogram reported at the end of a run.
+var latencyBuckets = func() []time.Duration {
+	var b []time.Duration
+	for decade := 10 * time.Microsecond; decade < 10*time.Second; decade *= 10 {
+		b = append(b, decade, 2*decade, 5*decade)
+	}
+	return append(b, 10*time.Second)
+}()
+
+// latencyHistogram counts durations into latencyBuckets as they are observed,
+// unlike latencyRecorder, which keeps every sample for the end of the run.
+//
+// A nil *latencyHistogram is valid and counts nothing.
+type latencyHistogram struct {
+	// counts holds one count per bucket, not cumulative, and a last for
+	// durations above every bucket.
+	counts []atomic.Int64
+	sum    atomic.Int64
+	count  atomic.Int64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make([]atomic.Int64, len(latencyBuckets)+1)}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	if h == nil {
+		return
+	}
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+	h.count.Add(1)
+}
+
+// reset discards the durations observed so far.
+func (h *latencyHistogram) reset() {
+	if h == nil {
+		return
+	}
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+	h.sum.Store(0)
+	h.count.Store(0)
+}
+
+// startMetrics serves the metrics written by write at /metrics on addr, in the
+// background until the returned function is called. An empty addr does
+// nothing.
+func startMetrics(addr string, write func(m *metricsWriter)) (func(), error) {
+	if addr == "" {
+		return func() {}, nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	infof("metrics listening on http://%s/metrics", l.Addr())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m := &metricsWriter{w: bufio.NewWriter(w)}
+		write(m)
+		m.w.Flush()
+	})
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			errorf("metrics server failed: %s", err)
+		}
+	}()
+	return func() { srv.Close() }, nil
+}
+
+// writeClientMetrics writes the metrics of a client run.
+func writeClientMetrics(m *metricsWriter, r *clientRun) {
+	const calls = "ttrpcstress_client_calls_total"
+	m.header(calls, "Calls finished, by outcome.", "counter")
+	for _, o := range []struct {
+		outcome string
+		n       *atomic.Int64
+	}{
+		{"succeeded", &r.completed},
+		{"failed", &r.failed},
+		{"timed_out", &r.timedOut},
+		{"injected_error", &r.injected},
+		{"cancelled", &r.cancelled},
+		{"server_closed", &r.closed},
+	} {
+		m.sample(calls, fmt.Sprintf("outcome=%q", o.outcome), float64(o.n.Load()))
+	}
+	m.gauge("ttrpcstress_client_calls_in_flight", "Calls sent and not yet finished.", float64(r.active.Load()))
+	m.gauge("ttrpcstress_client_calls_in_flight_peak", "The most unary calls outstanding at once.", float64(r.peakActive.Load()))
+	m.counter("ttrpcstress_client_reconnects_total", "Dropped connections replaced with -reconnect.", r.reconnects.Load())
+	m.counter("ttrpcstress_client_lost_calls_total", "Calls failed by a dropped connection and retried with -reconnect.", r.lost.Load())
+	m.gauge("ttrpcstress_client_last_progress_timestamp_seconds", "When a call last completed, as a Unix time; a stall shows as this falling behind.", float64(r.progress.last.Load())/1e9)
+	m.histogram("ttrpcstress_client_call_duration_seconds", "Latency of successful calls.", r.latency.hist)
+}
+
+// serverMetrics holds the server's metrics that its handlers do not already
+// count: the requests being handled, and how long handling took.
+type serverMetrics struct {
+	active  atomic.Int64
+	latency *latencyHistogram
+}
+
+// interceptor tracks the unary requests being handled, and their latency.
+func (s *serverMetrics) interceptor(ctx context.Context, unmarshal ttrpc.Unmarshaler, _ *ttrpc.UnaryServerInfo, method ttrpc.Method) (interface{}, error) {
+	s.active.Add(1)
+	defer s.active.Add(-1)
+	start := time.Now()
+	resp, err := method(ctx, unmarshal)
+	s.latency.observe(time.Since(start))
+	return resp, err
+}
+
+// writeServerMetrics writes the metrics of a server.
+func writeServerMetrics(m *metricsWriter, s *serverMetrics, limit *handlerLimit, served, badRequests, injected, withMetadata *atomic.Int64) {
+	m.counter("ttrpcstress_server_requests_total", "Requests served, including stream messages.", served.Load())
+	m.counter("ttrpcstress_server_bad_requests_total", "Requests that failed to unmarshal.", badRequests.Load())
+	m.counter("ttrpcstress_server_injected_errors_total", "Requests failed with an injected error.", injected.Load())
+	m.counter("ttrpcstress_server_metadata_verified_total", "Requests whose metadata was verified.", withMetadata.Load())
+	m.gauge("ttrpcstress_server_requests_in_flight", "Unary requests being handled.", float64(s.active.Load()))
+	m.gauge("ttrpcstress_server_requests_queued", "Unary requests waiting for a slot under -max-concurrent.", float64(limit.depth()))
+	m.histogram("ttrpcstress_server_handle_duration_seconds", "Time taken to handle unary requests, including any response delay.", s.latency)
+}
@@ -0,0 +1,90 @@
+package stress
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mixMethods are the methods that a -mix can name, by the name it uses.
+var mixMethods = map[string]string{
+	"echo":  methodEcho,
+	"ping":  methodPing,
+	"large": methodLarge,
+	"slow":  methodSlow,
+	"error": methodFail,
+}
+
+// maxMixWeight bounds the total of the weights in a -mix, once reduced to
+// lowest terms, so that the table parseMix builds stays small.
+const maxMixWeight = 10000
+
+// parseMix parses a weighted call mix, such as "echo=80,slow=15,error=5", into
+// a table of methods indexed by request value as clientRun.methods is. Each
+// method appears in proportion to its weight, at positions shuffled with a
+// fixed seed, so that the methods are interleaved rather than called in runs,
+// and a request value is sent to the same method on every run.
+func parseMix(s string) ([]string, error) {
+	weights := make(map[string]int)
+	for _, pair := range strings.Split(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not of the form NAME=WEIGHT", pair)
+		}
+		method, ok := mixMethods[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown method %q; expected echo, ping, large, slow, or error", name)
+		}
+		if _, dup := weights[method]; dup {
+			return nil, fmt.Errorf("method %q is given more than once", name)
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("weight of %q must be a non-negative integer, got %q", name, weight)
+		}
+		weights[method] = w
+	}
+	g := 0
+	for _, w := range weights {
+		g = gcd(g, w)
+	}
+	if g == 0 {
+		return nil, fmt.Errorf("at least one weight must be positive")
+	}
+	// The methods are added in a fixed order, as map iteration would otherwise
+	// defeat the fixed shuffle.
+	methods := make([]string, 0, len(weights))
+	for method := range weights {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	var table []string
+	for _, method := range methods {
+		for i := 0; i < weights[method]/g; i++ {
+			table = append(table, method)
+		}
+		if len(table) > maxMixWeight {
+			return nil, fmt.Errorf("weights total more than %d, even in lowest terms", maxMixWeight)
+		}
+	}
+	rng := rand.New(rand.NewSource(1))
+	rng.Shuffle(len(table), func(i, j int) { table[i], table[j] = table[j], table[i] })
+	return table, nil
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// serviceFor returns the service that method is registered on.
+func serviceFor(method string) string {
+	if method == methodSlow || method == methodFail {
+		return auxServiceName
+	}
+	return serviceName
+}
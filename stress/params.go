@@ -0,0 +1,51 @@
+package stress
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// ttrpcMaxMessageSize is the message size limit enforced by all released ttrpc
+// versions. It is not exported or negotiated, so it is recorded here for reference.
+const ttrpcMaxMessageSize = 4 << 20
+
+// connParam is a single named connection parameter.
+type connParam struct {
+	name  string
+	value interface{}
+}
+
+// ttrpcVersion returns the version of github.com/containerd/ttrpc this binary was
+// built with, if known.
+func ttrpcVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/containerd/ttrpc" {
+			if dep.Replace != nil {
+				return dep.Version + " => " + dep.Replace.Path + " " + dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
+// logConnParams logs a block describing the conditions of a run, so that results
+// can be compared across ttrpc version, transport, and buffering permutations.
+func logConnParams(role string, extra ...connParam) {
+	params := []connParam{
+		{"role", role},
+		{"ttrpc version", ttrpcVersion()},
+		{"go version", runtime.Version()},
+		{"payload variant", payloadVariant},
+		{"max message size", ttrpcMaxMessageSize},
+	}
+	params = append(params, extra...)
+	infof("connection parameters:")
+	for _, p := range params {
+		infof("  %-20s %v", p.name+":", p.value)
+	}
+}
@@ -0,0 +1,122 @@
+package stress
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	parityIters   = 10000
+	parityWorkers = 16
+	// parityTimeout bounds each transport's run; exceeding it is treated as a deadlock.
+	parityTimeout = time.Minute
+	// paritySlowdown is how many times slower than the median a transport may be
+	// before it is flagged.
+	paritySlowdown = 10
+)
+
+type parityResult struct {
+	name    string
+	elapsed time.Duration
+	err     error
+}
+
+func parityCommand(fs *flag.FlagSet) func(context.Context) error {
+	timelinePath := timelineFlag(fs)
+	return func(ctx context.Context) error {
+		tl, err := newTimeline(*timelinePath)
+		if err != nil {
+			return err
+		}
+		defer tl.close()
+		if err := runParity(ctx, tl); err != nil {
+			return fmt.Errorf("FAIL: %w", err)
+		}
+		return nil
+	}
+}
+
+// runParity runs an identical small workload over each local transport in turn,
+// and flags any transport that fails, deadlocks, or is dramatically slower than
+// the others. This guards the harness's own transports, and shows whether a
+// ttrpc bug is transport-specific.
+func runParity(ctx context.Context, tl *timeline) error {
+	defer quietRequests()()
+
+	var results []parityResult
+	for _, t := range localTransports() {
+		r := parityResult{name: t.name}
+		r.elapsed, r.err = runParityTransport(ctx, t, tl)
+		if r.err != nil {
+			infof("%-7s FAIL: %s", t.name, r.err)
+		} else {
+			infof("%-7s ok: elapsed time %v (%.0f req/s)", t.name, r.elapsed, float64(parityIters)/r.elapsed.Seconds())
+		}
+		results = append(results, r)
+	}
+
+	var passed []time.Duration
+	for _, r := range results {
+		if r.err == nil {
+			passed = append(passed, r.elapsed)
+		}
+	}
+	sort.Slice(passed, func(i, j int) bool { return passed[i] < passed[j] })
+	var failed []string
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			failed = append(failed, r.name)
+		case r.elapsed > paritySlowdown*passed[len(passed)/2]:
+			infof("%-7s is more than %dx slower than the median transport", r.name, paritySlowdown)
+			failed = append(failed, r.name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("transports behaved differently: %v", failed)
+	}
+	infof("PASS: all %d transports behaved consistently", len(results))
+	return nil
+}
+
+func runParityTransport(ctx context.Context, t localTransport, tl *timeline) (time.Duration, error) {
+	l, dial, cleanup, err := t.listen()
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, parityTimeout)
+	defer cancel()
+	serverCtx, stopServer := context.WithCancel(ctx)
+	defer stopServer()
+	serverErr := make(chan error, 1)
+	// The server and client are those smoke runs, with the same defaults.
+	go func() { serverErr <- Serve(serverCtx, tl.wrapListener(l), ServerOptions{}) }()
+
+	res, err := RunClient(ctx, ClientOptions{
+		Dial: func() (net.Conn, error) {
+			c, err := dial()
+			if err != nil {
+				return nil, err
+			}
+			return tl.wrapConn(c, "client"), nil
+		},
+		Iters:   parityIters,
+		Workers: parityWorkers,
+	})
+	elapsed := time.Duration(res.ElapsedMs * float64(time.Millisecond))
+	if ctx.Err() != nil {
+		return elapsed, fmt.Errorf("did not complete within %v, possible deadlock", parityTimeout)
+	}
+	if err != nil {
+		return elapsed, err
+	}
+	stopServer()
+	return elapsed, <-serverErr
+}
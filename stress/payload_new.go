@@ -0,0 +1,24 @@
+//go:build protogo
+
+package stress
+
+import (
+	"github.com/kevpar/test/ttrpcstress/protogo"
+	"google.golang.org/protobuf/proto"
+)
+
+type payload = protogo.Payload
+
+const payloadVariant = "protogo"
+
+func payloadSize(p *payload) int {
+	return proto.Size(p)
+}
+
+func marshalPayload(p *payload) ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.Marshal(p)
+}
+
+func unmarshalPayload(b []byte, p *payload) error {
+	return proto.Unmarshal(b, p)
+}
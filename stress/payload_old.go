@@ -0,0 +1,24 @@
+//go:build protogogo
+
+package stress
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/kevpar/test/ttrpcstress/protogogo"
+)
+
+type payload = protogogo.Payload
+
+const payloadVariant = "protogogo"
+
+func payloadSize(p *payload) int {
+	return proto.Size(p)
+}
+
+func marshalPayload(p *payload) ([]byte, error) {
+	return proto.Marshal(p)
+}
+
+func unmarshalPayload(b []byte, p *payload) error {
+	return proto.Unmarshal(b, p)
+}
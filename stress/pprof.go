@@ -0,0 +1,39 @@
+package stress
+
+import (
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"runtime"
+)
+
+// The sampling rates for the mutex and block profiles, which are empty unless
+// enabled. Contention on one of every pprofMutexFraction mutexes is recorded,
+// and on average one blocking event per pprofBlockRate nanoseconds spent
+// blocked, which keeps the overhead small next to the calls being measured.
+const (
+	pprofMutexFraction = 100
+	pprofBlockRate     = 10000
+)
+
+// startPprof serves the net/http/pprof handlers on addr in the background, so
+// goroutine, heap, CPU, mutex, and block profiles can be captured at the moment
+// a stall forms. An empty addr does nothing.
+func startPprof(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	runtime.SetMutexProfileFraction(pprofMutexFraction)
+	runtime.SetBlockProfileRate(pprofBlockRate)
+	infof("pprof listening on http://%s/debug/pprof/", l.Addr())
+	go func() {
+		if err := http.Serve(l, nil); err != nil {
+			errorf("pprof server failed: %s", err)
+		}
+	}()
+	return nil
+}
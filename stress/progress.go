@@ -0,0 +1,121 @@
+package stress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultProgressBarInterval is how often the progress bar is redrawn when
+// -progress is not given.
+const defaultProgressBarInterval = time.Second
+
+// progressBarWidth is the number of cells in the bar of a run of known length.
+const progressBarWidth = 30
+
+// progressBar draws a run's progress on a single line of a terminal, redrawn
+// in place every interval, rather than logging a line each time.
+type progressBar struct {
+	mu sync.Mutex
+	w  io.Writer
+	// drawn is the length of the line last drawn, which is blanked before
+	// anything is written over it.
+	drawn int
+}
+
+// activeBar is the progress bar being drawn, if any. Log lines are written
+// above it, rather than over it; see logf.
+var activeBar atomic.Pointer[progressBar]
+
+// isTerminal reports whether f is a terminal, or console, to draw a progress
+// bar on. Character devices that are not, such as /dev/null, are taken to be,
+// but lose nothing by it.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+// startProgressBar starts drawing to w, and returns a function that clears the
+// bar, for the run's summary to follow.
+func startProgressBar(w io.Writer) (b *progressBar, stop func()) {
+	b = &progressBar{w: w}
+	activeBar.Store(b)
+	return b, func() {
+		activeBar.CompareAndSwap(b, nil)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.clear()
+	}
+}
+
+// draw replaces the line with s.
+func (b *progressBar) draw(s string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pad := max(b.drawn-len(s), 0)
+	fmt.Fprint(b.w, "\r"+s+strings.Repeat(" ", pad))
+	b.drawn = len(s)
+}
+
+// above runs write, which logs a line, with the bar cleared. The bar comes back
+// at the next draw.
+func (b *progressBar) above(write func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	write()
+}
+
+func (b *progressBar) clear() {
+	if b.drawn > 0 {
+		fmt.Fprint(b.w, "\r"+strings.Repeat(" ", b.drawn)+"\r")
+		b.drawn = 0
+	}
+}
+
+// formatProgress describes a run's progress: completed calls, of total if it
+// is known, the rate over the last interval and over the whole run, so that a
+// run slowing down shows as the first falling below the second, and, if the
+// run's end can be told, the time left. A zero end means it cannot. With bar
+// set, a run of known length is drawn as a bar as well.
+func formatProgress(completed int64, total int, rate, mean float64, eta time.Duration, bar bool) string {
+	var b strings.Builder
+	if total > 0 {
+		frac := min(float64(completed)/float64(total), 1)
+		if bar {
+			filled := int(frac * progressBarWidth)
+			fmt.Fprintf(&b, "[%s%s] ", strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled))
+		}
+		fmt.Fprintf(&b, "completed %d/%d (%.1f%%)", completed, total, 100*frac)
+	} else {
+		fmt.Fprintf(&b, "completed %d", completed)
+	}
+	fmt.Fprintf(&b, ", %.0f req/s (mean %.0f)", rate, mean)
+	switch {
+	case eta > 0:
+		fmt.Fprintf(&b, ", ETA %v", eta.Round(time.Second))
+	case eta < 0:
+		b.WriteString(", ETA unknown")
+	}
+	return b.String()
+}
+
+// progressETA estimates the time left in a run, from the mean rate for a run
+// of total calls, or from end for one of fixed duration. It is zero if the run
+// has neither, and negative if calls are not completing to estimate from.
+func progressETA(now, end time.Time, completed int64, total int, mean float64) time.Duration {
+	switch {
+	case !end.IsZero():
+		return max(end.Sub(now), time.Second)
+	case total == 0:
+		return 0
+	case mean <= 0:
+		return -1
+	}
+	left := max(float64(int64(total)-completed), 0)
+	return max(time.Duration(left/mean*float64(time.Second)), time.Second)
+}
@@ -0,0 +1,297 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+func proxyCommand(fs *flag.FlagSet) func(context.Context) error {
+	var cfg proxyConfig
+	fs.StringVar(&cfg.listen, "listen", "", "Address to accept clients on (any -addr form accepted by the server; required)")
+	fs.StringVar(&cfg.target, "target", "", "Address of the server to relay to (any -addr form accepted by the client; required)")
+	fs.DurationVar(&cfg.connectTimeout, "connect-timeout", 10*time.Second, "Give up connecting to -target after this long (0 to wait forever)")
+	fs.StringVar(&cfg.direction, "direction", "both", "Data to inject faults into: \"both\", \"requests\" (client to server), or \"responses\" (server to client)")
+	fs.DurationVar(&cfg.delay, "delay", 0, "Wait this long before relaying each chunk of data")
+	fs.DurationVar(&cfg.jitter, "jitter", 0, "Wait up to this long more, chosen at random, before relaying each chunk")
+	fs.Float64Var(&cfg.stallRate, "stall-rate", 0, "Fraction of chunks to hold for -stall before relaying, as a peer that stops reading would")
+	fs.DurationVar(&cfg.stall, "stall", time.Second, "How long each stall chosen by -stall-rate lasts")
+	fs.Float64Var(&cfg.partialRate, "partial-rate", 0, "Fraction of chunks to relay in two writes with a pause between, splitting frames across reads")
+	fs.Float64Var(&cfg.truncateRate, "truncate-rate", 0, "Fraction of chunks to relay only a random prefix of before dropping the connection")
+	fs.Float64Var(&cfg.resetRate, "reset-rate", 0, "Fraction of chunks at which to drop the connection rather than relay them")
+	fs.Uint64Var(&cfg.seed, "seed", 0, "Seed for the random faults and jitter, to repeat the choices an earlier run made for each chunk of each connection, when reads return the same chunks (0 to pick one, which is logged)")
+	timelinePath := timelineFlag(fs)
+	return func(ctx context.Context) error {
+		switch {
+		case cfg.listen == "":
+			return usageErrorf("-listen is required")
+		case cfg.target == "":
+			return usageErrorf("-target is required")
+		case cfg.connectTimeout < 0:
+			return usageErrorf("-connect-timeout must not be negative, got %v", cfg.connectTimeout)
+		case cfg.direction != "both" && cfg.direction != "requests" && cfg.direction != "responses":
+			return usageErrorf("-direction must be \"both\", \"requests\", or \"responses\", got %q", cfg.direction)
+		case cfg.delay < 0:
+			return usageErrorf("-delay must not be negative, got %v", cfg.delay)
+		case cfg.jitter < 0:
+			return usageErrorf("-jitter must not be negative, got %v", cfg.jitter)
+		case cfg.stall < 0:
+			return usageErrorf("-stall must not be negative, got %v", cfg.stall)
+		}
+		for _, r := range []struct {
+			name string
+			rate float64
+		}{
+			{"-stall-rate", cfg.stallRate},
+			{"-partial-rate", cfg.partialRate},
+			{"-truncate-rate", cfg.truncateRate},
+			{"-reset-rate", cfg.resetRate},
+		} {
+			if r.rate < 0 || r.rate > 1 {
+				return usageErrorf("%s must be between 0 and 1, got %v", r.name, r.rate)
+			}
+		}
+		if _, _, err := parseAddr(cfg.target); err != nil {
+			return usageErrorf("-target: %s", err)
+		}
+		tl, err := newTimeline(*timelinePath)
+		if err != nil {
+			return err
+		}
+		defer tl.close()
+		cfg.tl = tl
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return runProxy(ctx, cfg)
+	}
+}
+
+// proxyConfig holds the settings for a proxy run. Faults are injected per chunk,
+// where a chunk is whatever a single read from one side returned, so they fall
+// at arbitrary points within and between frames.
+type proxyConfig struct {
+	listen, target string
+	connectTimeout time.Duration
+	// direction selects which side's data faults are injected into.
+	direction string
+	// delay and jitter hold each chunk before it is relayed. The relay does not
+	// read ahead while it waits, so the sender is held back as well.
+	delay, jitter time.Duration
+	// stallRate is the fraction of chunks held for stall.
+	stallRate float64
+	stall     time.Duration
+	// partialRate is the fraction of chunks written in two parts.
+	partialRate float64
+	// truncateRate is the fraction of chunks cut short, after which the
+	// connection is dropped, leaving the receiver with an incomplete frame.
+	truncateRate float64
+	// resetRate is the fraction of chunks at which the connection is dropped.
+	resetRate float64
+	// seed makes the random choices above, in a sequence of its own for each
+	// direction of each connection.
+	seed uint64
+	tl   *timeline
+}
+
+// partialPause is the gap between the two writes of a chunk split by
+// -partial-rate, long enough for the receiver to see the first part on its own.
+const partialPause = time.Millisecond
+
+// errInjectedDrop is returned by a relay that dropped its connection on purpose.
+var errInjectedDrop = errors.New("connection dropped by fault injection")
+
+// proxy relays connections from clients to a server, injecting faults.
+type proxy struct {
+	cfg                                 proxyConfig
+	conns                               atomic.Int64
+	stalls, partials, truncates, resets atomic.Int64
+}
+
+// runProxy accepts connections on cfg.listen until ctx is cancelled, relaying
+// each to a new connection to cfg.target.
+func runProxy(ctx context.Context, cfg proxyConfig) error {
+	l, err := listen(cfg.listen, defaultPipeConfig)
+	if err != nil {
+		return err
+	}
+	cfg.seed = resolveSeed(cfg.seed)
+	logConnParams("proxy",
+		connParam{"transport", l.Addr().Network()},
+		connParam{"address", l.Addr()},
+		connParam{"target", cfg.target},
+		connParam{"direction", cfg.direction},
+		connParam{"delay", cfg.delay},
+		connParam{"jitter", cfg.jitter},
+		connParam{"stall rate", cfg.stallRate},
+		connParam{"stall", cfg.stall},
+		connParam{"partial rate", cfg.partialRate},
+		connParam{"truncate rate", cfg.truncateRate},
+		connParam{"reset rate", cfg.resetRate},
+		connParam{"seed", cfg.seed})
+	cfg.tl.record("listener", "listen", "%s", l.Addr())
+	p := &proxy{cfg: cfg}
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+	var wg sync.WaitGroup
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			wg.Wait()
+			return err
+		}
+		id := p.conns.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.handle(ctx, id, c)
+		}()
+	}
+	wg.Wait()
+	infof("relayed %d connections, injected %d stalls, %d partial writes, %d truncated writes, %d resets",
+		p.conns.Load(), p.stalls.Load(), p.partials.Load(), p.truncates.Load(), p.resets.Load())
+	return nil
+}
+
+// handle relays between client connection c, the id'th accepted, and a new
+// connection to the target until either side closes or fails, then closes
+// both. Each side is recorded on the timeline as its own connection.
+func (p *proxy) handle(ctx context.Context, id int64, c net.Conn) {
+	name := fmt.Sprintf("proxy-%d", id)
+	p.cfg.tl.record(name+"-client", "accept", "remote %s", c.RemoteAddr())
+	p.cfg.tl.record(name+"-server", "dial", "%s", p.cfg.target)
+	s, err := dial(p.cfg.target, p.cfg.connectTimeout)
+	if err != nil {
+		p.cfg.tl.record(name+"-server", "error", "dial: %s", err)
+		warnf("%s: connecting to %s: %s", name, p.cfg.target, err)
+		c.Close()
+		return
+	}
+	p.cfg.tl.record(name+"-server", "connected", "%s", s.RemoteAddr())
+	cw := p.cfg.tl.wrapConn(c, name+"-client")
+	sw := p.cfg.tl.wrapConn(s, name+"-server")
+	stop := context.AfterFunc(ctx, func() {
+		cw.Close()
+		sw.Close()
+	})
+	defer stop()
+	errc := make(chan error, 2)
+	go func() { errc <- p.relay(ctx, sw, cw, p.cfg.direction != "responses", p.rand(id, 0)) }()
+	go func() { errc <- p.relay(ctx, cw, sw, p.cfg.direction != "requests", p.rand(id, 1)) }()
+	err = <-errc
+	if errors.Is(err, errInjectedDrop) {
+		p.cfg.tl.record(name+"-client", "error", "%s", err)
+		resetConn(c)
+		resetConn(s)
+	}
+	cw.Close()
+	sw.Close()
+	<-errc
+	debugf("%s: closed: %v", name, err)
+}
+
+// rand returns the source of random choices for direction dir, 0 for requests
+// and 1 for responses, of the id'th connection.
+func (p *proxy) rand(id int64, dir uint32) *rand.Rand {
+	return rand.New(rand.NewSource(int64(randomValue(p.cfg.seed^drawProxy<<48^uint64(id)<<1, dir))))
+}
+
+// relay copies src to dst, injecting faults chosen with rng if inject is set,
+// until either fails.
+func (p *proxy) relay(ctx context.Context, dst, src net.Conn, inject bool, rng *rand.Rand) error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if inject {
+				err = p.forward(ctx, dst, buf[:n], rng)
+			} else {
+				_, err = dst.Write(buf[:n])
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// forward writes chunk b to dst, with whichever faults are chosen for it.
+func (p *proxy) forward(ctx context.Context, dst net.Conn, b []byte, rng *rand.Rand) error {
+	d := p.cfg.delay
+	if p.cfg.jitter > 0 {
+		d += time.Duration(rng.Int63n(int64(p.cfg.jitter)))
+	}
+	if !wait(ctx, d) {
+		return ctx.Err()
+	}
+	if chance(rng, p.cfg.resetRate) {
+		p.resets.Add(1)
+		return errInjectedDrop
+	}
+	if chance(rng, p.cfg.truncateRate) {
+		p.truncates.Add(1)
+		if _, err := dst.Write(b[:rng.Intn(len(b))]); err != nil {
+			return err
+		}
+		return errInjectedDrop
+	}
+	if chance(rng, p.cfg.stallRate) {
+		p.stalls.Add(1)
+		if !wait(ctx, p.cfg.stall) {
+			return ctx.Err()
+		}
+	}
+	if len(b) > 1 && chance(rng, p.cfg.partialRate) {
+		p.partials.Add(1)
+		k := 1 + rng.Intn(len(b)-1)
+		if _, err := dst.Write(b[:k]); err != nil {
+			return err
+		}
+		if !wait(ctx, partialPause) {
+			return ctx.Err()
+		}
+		b = b[k:]
+	}
+	_, err := dst.Write(b)
+	return err
+}
+
+// chance reports true with probability rate, drawing from rng.
+func chance(rng *rand.Rand, rate float64) bool {
+	return rate > 0 && rng.Float64() < rate
+}
+
+// wait sleeps for d, returning false if ctx is cancelled first.
+func wait(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// resetConn arranges for c to be reset rather than closed gracefully, where
+// the transport supports it, so that the peer sees the drop as an error rather
+// than the end of the stream.
+func resetConn(c net.Conn) {
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+}
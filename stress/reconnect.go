@@ -0,0 +1,132 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/grpc"
+)
+
+// reconnectTimeout bounds how long a dropped connection is re-dialed for with
+// -reconnect before the run fails.
+const reconnectTimeout = 30 * time.Second
+
+// connSlot holds one of the client's connections, which is replaced if it drops
+// and reconnecting is enabled.
+type connSlot struct {
+	name   string
+	mu     sync.Mutex
+	client *ttrpc.Client
+	// grpc is set in place of client with -protocol grpc. It re-dials by
+	// itself, so is never replaced.
+	grpc *grpc.ClientConn
+}
+
+func (s *connSlot) current() *ttrpc.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client
+}
+
+func (s *connSlot) close() {
+	if s.grpc != nil {
+		s.grpc.Close()
+		return
+	}
+	s.current().Close()
+}
+
+// call sends req to method on the worker's connection. If reconnecting is
+// enabled and the connection has dropped, it is re-dialed and the call retried,
+// so the request is eventually answered on some connection. Each retry counts
+// as a lost call.
+func (r *clientRun) call(ctx context.Context, worker int, method string, req, resp *payload) error {
+	for {
+		slot := r.slotFor(worker)
+		if slot.grpc != nil {
+			return slot.grpc.Invoke(ctx, grpcMethod(serviceFor(method), method), req, resp)
+		}
+		client := slot.current()
+		err := client.Call(ctx, serviceFor(method), method, req, resp)
+		if !r.reconnect || !errors.Is(err, ttrpc.ErrClosed) || ctx.Err() != nil {
+			return err
+		}
+		r.lost.Add(1)
+		if err := r.redial(ctx, slot, client); err != nil {
+			return err
+		}
+	}
+}
+
+// redial replaces failed, the client in slot, with a new connection. It retries
+// with backoff for up to reconnectTimeout. When several workers see the same
+// connection drop, only the first re-dials; the rest find it already replaced.
+func (r *clientRun) redial(ctx context.Context, slot *connSlot, failed *ttrpc.Client) error {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	if slot.client != failed {
+		return nil
+	}
+	failed.Close()
+	warnf("%s: connection closed, reconnecting", slot.name)
+	start := time.Now()
+	deadline := start.Add(reconnectTimeout)
+	backoff := 10 * time.Millisecond
+	for {
+		client, err := r.connect(slot.name)
+		if err == nil {
+			slot.client = client
+			r.reconnects.Add(1)
+			took := time.Since(start)
+			r.recovery.add(took)
+			infof("%s: reconnected after %v", slot.name, took.Round(time.Microsecond))
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: reconnecting for %v: %w", slot.name, reconnectTimeout, err)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(2*backoff, time.Second)
+	}
+}
+
+// recoveryStats accumulates how long each dropped connection took to replace.
+type recoveryStats struct {
+	mu      sync.Mutex
+	n       int
+	total   time.Duration
+	longest time.Duration
+}
+
+func (s *recoveryStats) add(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	s.total += d
+	s.longest = max(s.longest, d)
+}
+
+func (s *recoveryStats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n, s.total, s.longest = 0, 0, 0
+}
+
+// summary returns the mean and longest recovery time, or zeros if no
+// connection was replaced.
+func (s *recoveryStats) summary() (mean, longest time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.n == 0 {
+		return 0, 0
+	}
+	return s.total / time.Duration(s.n), s.longest
+}
@@ -0,0 +1,87 @@
+package stress
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reorderer holds each even-valued request until a number of odd-valued
+// requests that arrived after it have completed, so that responses go out in a
+// different order from the requests. The client's demultiplexing of responses
+// onto calls is only stressed by that; an instant response, or one delayed by
+// the same amount as every other, comes back in order.
+//
+// A request held for maxHold without enough odd-valued requests completing, as
+// when a client sends one call at a time, is released anyway.
+//
+// A nil *reorderer holds nothing.
+type reorderer struct {
+	after   int64
+	maxHold time.Duration
+	mu      sync.Mutex
+	// odd counts the odd-valued requests completed, and changed is closed, and
+	// replaced, each time it grows.
+	odd     int64
+	changed chan struct{}
+	// held counts the requests held, and expired those released after maxHold.
+	held    atomic.Int64
+	expired atomic.Int64
+}
+
+func newReorderer(after int, maxHold time.Duration) *reorderer {
+	if after <= 0 {
+		return nil
+	}
+	return &reorderer{after: int64(after), maxHold: maxHold, changed: make(chan struct{})}
+}
+
+// wait holds the request carrying value, if it is even, until enough odd-valued
+// requests have completed, maxHold passes, or ctx is cancelled.
+func (r *reorderer) wait(ctx context.Context, value uint32) error {
+	if r == nil || value%2 != 0 {
+		return nil
+	}
+	r.held.Add(1)
+	r.mu.Lock()
+	target := r.odd + r.after
+	r.mu.Unlock()
+	t := time.NewTimer(r.maxHold)
+	defer t.Stop()
+	for {
+		r.mu.Lock()
+		odd, changed := r.odd, r.changed
+		r.mu.Unlock()
+		if odd >= target {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-t.C:
+			r.expired.Add(1)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// complete records that the request carrying value has been handled.
+func (r *reorderer) complete(value uint32) {
+	if r == nil || value%2 == 0 {
+		return
+	}
+	r.mu.Lock()
+	r.odd++
+	close(r.changed)
+	r.changed = make(chan struct{})
+	r.mu.Unlock()
+}
+
+func (r *reorderer) report() {
+	if r == nil {
+		return
+	}
+	infof("held %d even-valued requests for reordering, %d of them released after %v", r.held.Load(), r.expired.Load(), r.maxHold)
+}
@@ -0,0 +1,173 @@
+package stress
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"runtime"
+	"time"
+)
+
+// ClientResult is the machine-readable outcome of a client run, written with
+// -output json or -json so results can be compared across ttrpc versions without
+// scraping log text, and returned by RunClient. Latencies are in milliseconds. Runs of a fixed duration
+// report DurationMs in place of Iters.
+type ClientResult struct {
+	TTRPCVersion   string  `json:"ttrpc_version"`
+	GoVersion      string  `json:"go_version"`
+	PayloadVariant string  `json:"payload_variant"`
+	Protocol       string  `json:"protocol"`
+	Mode           string  `json:"mode"`
+	Method         string  `json:"method"`
+	Mix            string  `json:"mix,omitempty"`
+	Iters          int     `json:"iters"`
+	DurationMs     float64 `json:"duration_ms,omitempty"`
+	// Warmup is the number of calls sent before the run, which are excluded
+	// from every other field.
+	Warmup int `json:"warmup,omitempty"`
+	// Seed is the seed that pseudo-random choices were made with, to repeat
+	// the run with -seed.
+	Seed           uint64  `json:"seed"`
+	Workers        int     `json:"workers"`
+	Conns          int     `json:"conns"`
+	ElapsedMs      float64 `json:"elapsed_ms"`
+	Succeeded      int64   `json:"succeeded"`
+	Errors         int64   `json:"errors"`
+	Timeouts       int64   `json:"timeouts"`
+	InjectedErrors int64   `json:"injected_errors"`
+	// Panics counts calls failed by a server handler's recovered panic.
+	Panics    int64 `json:"panics"`
+	Cancelled int64 `json:"cancelled"`
+	// BatchMissed counts calls cut off by the -batch-deadline of their batch,
+	// which are not counted in Errors.
+	BatchMissed int64 `json:"batch_missed,omitempty"`
+	// OnewaySent counts messages sent with no response, with -oneway-rate,
+	// and OnewayConfirmed those the server confirmed receiving.
+	OnewaySent      int64 `json:"oneway_sent"`
+	OnewayConfirmed int64 `json:"oneway_confirmed"`
+	// ServerClosed counts calls failed by the server shutting down, with
+	// -expect-shutdown.
+	ServerClosed int64 `json:"server_closed"`
+	Reconnects   int64 `json:"reconnects"`
+	// LostCalls counts calls failed by a dropped connection and retried with
+	// -reconnect; RecoveryMeanMs and RecoveryMaxMs are how long replacing a
+	// dropped connection took.
+	LostCalls      int64   `json:"lost_calls"`
+	RecoveryMeanMs float64 `json:"recovery_mean_ms"`
+	RecoveryMaxMs  float64 `json:"recovery_max_ms"`
+	// PeakOutstanding is the most unary calls outstanding at once.
+	PeakOutstanding int64   `json:"peak_outstanding"`
+	RequestsPerSec  float64 `json:"requests_per_sec"`
+	LatencyMinMs    float64 `json:"latency_min_ms"`
+	LatencyMeanMs   float64 `json:"latency_mean_ms"`
+	LatencyP50Ms    float64 `json:"latency_p50_ms"`
+	LatencyP90Ms    float64 `json:"latency_p90_ms"`
+	LatencyP99Ms    float64 `json:"latency_p99_ms"`
+	LatencyMaxMs    float64 `json:"latency_max_ms"`
+	// LatencyHistogram counts latencies by bucket; see histogram.
+	LatencyHistogram []resultBucket `json:"latency_histogram"`
+	// Throughput is sampled every second of the run; a deadlock confined to
+	// some workers shows up as their calls stopping while the others continue.
+	Throughput []resultThroughput `json:"throughput"`
+	// WorkerSucceeded counts the calls completed by each worker of a pool.
+	WorkerSucceeded []int64 `json:"worker_succeeded,omitempty"`
+	// ExitCode is the status the process exits with, and ExitReason names it.
+	ExitCode   int    `json:"exit_code"`
+	ExitReason string `json:"exit_reason"`
+	// Error is the error the run failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// resultThroughput is the throughput over the interval ending ElapsedMs into
+// the run. IdleWorkers lists the workers still running that completed calls
+// earlier but none within the interval.
+type resultThroughput struct {
+	ElapsedMs      float64 `json:"elapsed_ms"`
+	RequestsPerSec float64 `json:"requests_per_sec"`
+	IdleWorkers    []int   `json:"idle_workers,omitempty"`
+}
+
+// resultBucket is a latency histogram bucket, counting the latencies below
+// UpperMs and at or above the previous bucket's.
+type resultBucket struct {
+	UpperMs float64 `json:"upper_ms"`
+	Count   int     `json:"count"`
+}
+
+func newClientResult(cfg clientConfig, r *clientRun, elapsed time.Duration, err error) ClientResult {
+	s := summarizeLatency(r.latency.sorted())
+	res := ClientResult{
+		TTRPCVersion:    ttrpcVersion(),
+		GoVersion:       runtime.Version(),
+		PayloadVariant:  payloadVariant,
+		Protocol:        cfg.protocol,
+		Mode:            cfg.mode,
+		Method:          cfg.method,
+		Mix:             cfg.mix,
+		Iters:           cfg.iters,
+		Warmup:          cfg.warmup,
+		Seed:            cfg.seed,
+		Workers:         cfg.workers,
+		Conns:           len(r.conns),
+		ElapsedMs:       ms(elapsed),
+		Succeeded:       r.completed.Load(),
+		Errors:          r.failed.Load(),
+		Timeouts:        r.timedOut.Load(),
+		InjectedErrors:  r.injected.Load(),
+		Panics:          r.panicked.Load(),
+		Cancelled:       r.cancelled.Load(),
+		BatchMissed:     r.batchMissed.Load(),
+		OnewaySent:      r.onewaySent.Load(),
+		OnewayConfirmed: r.onewayConfirmed.Load(),
+		ServerClosed:    r.closed.Load(),
+		Reconnects:      r.reconnects.Load(),
+		LostCalls:       r.lost.Load(),
+		PeakOutstanding: r.peakActive.Load(),
+		RequestsPerSec:  float64(s.count) / elapsed.Seconds(),
+		LatencyMinMs:    ms(s.min),
+		LatencyMeanMs:   ms(s.mean),
+		LatencyP50Ms:    ms(s.p50),
+		LatencyP90Ms:    ms(s.p90),
+		LatencyP99Ms:    ms(s.p99),
+		LatencyMaxMs:    ms(s.max),
+		Throughput:      []resultThroughput{},
+		WorkerSucceeded: r.perWorker.snapshot(),
+	}
+	mean, longest := r.recovery.summary()
+	res.RecoveryMeanMs, res.RecoveryMaxMs = ms(mean), ms(longest)
+	for _, t := range r.throughput {
+		res.Throughput = append(res.Throughput, resultThroughput{ElapsedMs: ms(t.elapsed), RequestsPerSec: t.rate, IdleWorkers: t.idle})
+	}
+	for _, b := range s.histogram {
+		res.LatencyHistogram = append(res.LatencyHistogram, resultBucket{UpperMs: ms(b.upper), Count: b.count})
+	}
+	if cfg.duration > 0 {
+		res.Iters = 0
+		res.DurationMs = ms(cfg.duration)
+	}
+	res.ExitReason = exitReason(0)
+	if err != nil {
+		res.ExitCode = exitCode(err)
+		res.ExitReason = exitReason(res.ExitCode)
+		res.Error = err.Error()
+	}
+	return res
+}
+
+func (res ClientResult) write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+func (res ClientResult) writeFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := res.write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,107 @@
+package stress
+
+import (
+	"bufio"
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The outcomes of a call recorded in a sample, as the run counts them.
+const (
+	callOK          = "ok"
+	callInjected    = "injected"
+	callPanicked    = "panic"
+	callTimedOut    = "timeout"
+	callCancelled   = "cancelled"
+	callBatchMissed = "batch-missed"
+	callClosed      = "closed"
+	callFailed      = "error"
+	callMismatch    = "mismatch"
+)
+
+// sample is the record of a single call.
+type sample struct {
+	worker  int
+	id      uint32
+	start   time.Time
+	latency time.Duration
+	outcome string
+	err     error
+}
+
+// sampleWriter writes a record of every call to a CSV file, for analysis that
+// the summary cannot support, such as spotting periodic spikes, or one worker
+// stalling while the others progress. Columns are only ever added at the end,
+// so that scripts reading earlier files keep working. Samples
+// are handed to a single writer goroutine over a buffered channel, so workers
+// neither contend on the file nor wait on its writes unless the buffer fills.
+//
+// A nil *sampleWriter is valid and discards all samples.
+type sampleWriter struct {
+	ch   chan sample
+	done chan error
+}
+
+func newSampleWriter(path string) (*sampleWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &sampleWriter{ch: make(chan sample, 4096), done: make(chan error, 1)}
+	go func() {
+		w.done <- w.write(f)
+	}()
+	return w, nil
+}
+
+func (w *sampleWriter) write(f *os.File) error {
+	bw := bufio.NewWriterSize(f, 1<<16)
+	cw := csv.NewWriter(bw)
+	cw.Write([]string{"worker", "request", "dispatch_time", "latency_ms", "error", "receive_time", "status"})
+	var werr error
+	for s := range w.ch {
+		errStr := ""
+		if s.err != nil {
+			errStr = s.err.Error()
+		}
+		// Keep draining after a write error so that workers never block.
+		if werr == nil {
+			werr = cw.Write([]string{
+				strconv.Itoa(s.worker),
+				strconv.FormatUint(uint64(s.id), 10),
+				s.start.Format(time.RFC3339Nano),
+				strconv.FormatFloat(ms(s.latency), 'f', 3, 64),
+				errStr,
+				s.start.Add(s.latency).Format(time.RFC3339Nano),
+				s.outcome,
+			})
+		}
+	}
+	cw.Flush()
+	if werr == nil {
+		werr = cw.Error()
+	}
+	if err := f.Close(); werr == nil {
+		werr = err
+	}
+	return werr
+}
+
+func (w *sampleWriter) record(worker int, id uint32, start, end time.Time, outcome string, err error) {
+	if w == nil {
+		return
+	}
+	w.ch <- sample{worker: worker, id: id, start: start, latency: end.Sub(start), outcome: outcome, err: err}
+}
+
+// close writes out any buffered samples and closes the file. No samples may be
+// recorded after close.
+func (w *sampleWriter) close() error {
+	if w == nil {
+		return nil
+	}
+	close(w.ch)
+	return <-w.done
+}
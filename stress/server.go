@@ -0,0 +1,646 @@
+package stress
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func serverCommand(fs *flag.FlagSet) func(context.Context) error {
+	var (
+		cfg                       serverConfig
+		inputBuffer, outputBuffer int
+		tlsCert, tlsKey           string
+		errorCodes                string
+		background                bool
+		logFile, service          string
+	)
+	fs.StringVar(&cfg.addr, "addr", "", "Address to listen on (tcp://HOST:PORT, unix://PATH, npipe://./pipe/NAME, hvsock://VMID:SERVICE, or vsock://CID:PORT; required)")
+	fs.StringVar(&cfg.protocol, "protocol", protocolTTRPC, "Protocol to serve: \"ttrpc\", or \"grpc\" to serve the same unary methods over gRPC, for a client run with the same -protocol")
+	fs.Float64Var(&cfg.duplicateRate, "duplicate-rate", 0, "Fraction of responses to send a second, duplicate copy of")
+	fs.Float64Var(&cfg.errorRate, "error-rate", 0, "Fraction of "+methodEcho+" requests to fail with an error rather than echo")
+	fs.StringVar(&errorCodes, "error-code", injectedErrorCode.String(), "Status codes of the -error-rate errors, as a comma-separated list to choose from at random (e.g. Aborted,NotFound,Internal)")
+	fs.Float64Var(&cfg.panicRate, "panic-rate", 0, "Fraction of "+methodEcho+" handlers to panic, to see whether ttrpc recovers them and fails only their calls")
+	fs.BoolVar(&cfg.recoverPanics, "recover-panics", false, "Recover handler panics in an interceptor, failing their calls with Internal errors, as a service's own middleware would, rather than leaving them to ttrpc")
+	fs.IntVar(&cfg.maxConcurrent, "max-concurrent", 0, "Run at most this many unary handlers at once, queueing the requests beyond them, as a service with a fixed pool of workers would (0 for no limit)")
+	fs.DurationVar(&cfg.idleTimeout, "idle-timeout", 0, "Close connections that are idle for this long (0 to disable)")
+	fs.IntVar(&cfg.responseBytes, "response-bytes", -1, "Bytes of data to return in each response (-1 to echo the request's data)")
+	fs.DurationVar(&cfg.responseDelay, "response-delay", 0, "Wait this long before responding to each request, to simulate a slow server")
+	fs.DurationVar(&cfg.responseJitter, "response-jitter", 0, "Wait up to this long more, chosen at random, before responding to each request")
+	fs.DurationVar(&cfg.responseDelay, "delay", 0, "The same as -response-delay")
+	fs.DurationVar(&cfg.responseJitter, "jitter", 0, "The same as -response-jitter")
+	fs.IntVar(&cfg.reorder, "reorder", 0, "Hold each even-valued "+methodEcho+" request until this many odd-valued ones that arrived after it have been answered, so responses complete out of order (0 to disable)")
+	fs.DurationVar(&cfg.reorderHold, "reorder-hold", defaultReorderHold, "Release a request held by -reorder after this long even if too few odd-valued requests have been answered")
+	fs.Uint64Var(&cfg.seed, "seed", 0, "Seed for -error-rate, -error-code, -panic-rate, -response-jitter, -duplicate-rate, and -shutdown-within, to repeat the choices an earlier run made about each request (0 to pick one, which is logged)")
+	fs.DurationVar(&cfg.slowDelay, "slow-delay", defaultSlowDelay, "Wait this long before responding to each request to the "+auxServiceName+" "+methodSlow+" method, which a client -mix calls as \"slow\"")
+	fs.IntVar(&inputBuffer, "input-buffer", pipeInputBufferSize, "Named pipe input buffer size in bytes (npipe:// only)")
+	fs.IntVar(&outputBuffer, "output-buffer", pipeOutputBufferSize, "Named pipe output buffer size in bytes (npipe:// only)")
+	fs.IntVar(&inputBuffer, "in-buffer", pipeInputBufferSize, "The same as -input-buffer")
+	fs.IntVar(&outputBuffer, "out-buffer", pipeOutputBufferSize, "The same as -output-buffer")
+	fs.BoolVar(&cfg.pipe.messageMode, "message-mode", false, "Create the named pipe in message mode rather than byte mode (npipe:// only)")
+	fs.StringVar(&cfg.pipe.securityDescriptor, "security-descriptor", "", "SDDL security descriptor controlling who may connect to the named pipe, e.g. D:P(A;;GA;;;WD) to allow everyone (npipe:// only; default the creator and administrators)")
+	fs.StringVar(&tlsCert, "tls-cert", "", "Serve TLS with this PEM certificate, over any transport (requires -tls-key)")
+	fs.StringVar(&tlsKey, "tls-key", "", "PEM private key for -tls-cert")
+	leak := leakFlags(fs, "shutdown")
+	writes := writeChunkFlags(fs)
+	fs.DurationVar(&cfg.watchdog, "watchdog", 0, "Report a stall if a response write is blocked for this long, as when the client stops reading (0 to disable)")
+	fs.BoolVar(&cfg.watchdogExit, "watchdog-exit", false, fmt.Sprintf("Exit with status %d once the watchdog has reported a stall", exitStalled))
+	fs.DurationVar(&cfg.drainTimeout, "drain-timeout", defaultDrainTimeout, "On SIGINT or SIGTERM, wait this long for in-flight requests to complete before closing connections (0 to close them at once)")
+	fs.DurationVar(&cfg.shutdownWithin, "shutdown-within", 0, "Shut down at a random time within this long of the first connection, while clients may still be sending (0 to serve until interrupted)")
+	fs.StringVar(&cfg.shutdownMode, "shutdown-mode", "graceful", "How -shutdown-within shuts down: \"graceful\" as on SIGINT, waiting up to -drain-timeout for in-flight requests, or \"close\" to close every connection at once")
+	timelinePath := timelineFlag(fs)
+	capturePath := captureFlag(fs)
+	pprofAddr := pprofFlag(fs)
+	metricsAddr := metricsFlag(fs)
+	otlpEndpoint := otlpFlag(fs)
+	fs.StringVar(&cfg.pidfile, "pidfile", "", "Write the server's process ID to this file once it is listening, for \"ttrpcstress stop\", and remove it on exit")
+	fs.BoolVar(&background, "detach", false, "Run in the background, detached from this session so that it outlives it, once listening (requires -pidfile and -log-file)")
+	fs.StringVar(&logFile, "log-file", "", "Append output to this file rather than writing it to stderr")
+	fs.StringVar(&service, "service", "", "Run as the Windows service of this name, stopped by the service control manager as SIGINT would (Windows only; with -log-file, as a service has no console)")
+	return func(ctx context.Context) error {
+		if cfg.addr == "" {
+			return usageErrorf("-addr is required")
+		}
+		if err := validateProtocol(cfg.protocol); err != nil {
+			return err
+		}
+		if cfg.duplicateRate < 0 || cfg.duplicateRate > 1 {
+			return usageErrorf("-duplicate-rate must be between 0 and 1, got %v", cfg.duplicateRate)
+		}
+		// Both duplicate ttrpc frames, which gRPC does not send.
+		if cfg.protocol == protocolGRPC && (cfg.duplicateRate > 0 || *capturePath != "") {
+			return usageErrorf("-protocol grpc cannot be combined with -duplicate-rate or -capture")
+		}
+		if cfg.watchdogExit && cfg.watchdog == 0 {
+			return usageErrorf("-watchdog-exit requires -watchdog")
+		}
+		if cfg.errorRate < 0 || cfg.errorRate > 1 {
+			return usageErrorf("-error-rate must be between 0 and 1, got %v", cfg.errorRate)
+		}
+		if cfg.panicRate < 0 || cfg.panicRate > 1 {
+			return usageErrorf("-panic-rate must be between 0 and 1, got %v", cfg.panicRate)
+		}
+		if cfg.maxConcurrent < 0 {
+			return usageErrorf("-max-concurrent must not be negative, got %d", cfg.maxConcurrent)
+		}
+		list, err := parseErrorCodes(errorCodes)
+		if err != nil {
+			return usageErrorf("-error-code: %s", err)
+		}
+		cfg.errorCodes = list
+		if cfg.responseDelay < 0 {
+			return usageErrorf("-response-delay must not be negative, got %v", cfg.responseDelay)
+		}
+		if cfg.responseJitter < 0 {
+			return usageErrorf("-response-jitter must not be negative, got %v", cfg.responseJitter)
+		}
+		if cfg.shutdownWithin < 0 {
+			return usageErrorf("-shutdown-within must not be negative, got %v", cfg.shutdownWithin)
+		}
+		if cfg.shutdownMode != "graceful" && cfg.shutdownMode != "close" {
+			return usageErrorf("-shutdown-mode must be \"graceful\" or \"close\", got %q", cfg.shutdownMode)
+		}
+		if cfg.reorder < 0 {
+			return usageErrorf("-reorder must not be negative, got %d", cfg.reorder)
+		}
+		if cfg.reorderHold <= 0 {
+			return usageErrorf("-reorder-hold must be positive, got %v", cfg.reorderHold)
+		}
+		if cfg.slowDelay < 0 {
+			return usageErrorf("-slow-delay must not be negative, got %v", cfg.slowDelay)
+		}
+		if inputBuffer < 0 || inputBuffer > math.MaxInt32 {
+			return usageErrorf("-input-buffer must be between 0 and %d, got %d", math.MaxInt32, inputBuffer)
+		}
+		if outputBuffer < 0 || outputBuffer > math.MaxInt32 {
+			return usageErrorf("-output-buffer must be between 0 and %d, got %d", math.MaxInt32, outputBuffer)
+		}
+		cfg.pipe.input, cfg.pipe.output = int32(inputBuffer), int32(outputBuffer)
+		// Ignoring them would leave a sweep over buffer sizes silently measuring nothing.
+		if cfg.pipe != defaultPipeConfig {
+			if scheme, _, _ := parseAddr(cfg.addr); scheme != "npipe" {
+				return usageErrorf("-input-buffer, -output-buffer, -message-mode, and -security-descriptor are only supported for npipe:// addresses")
+			}
+		}
+		if (tlsCert == "") != (tlsKey == "") {
+			return usageErrorf("-tls-cert and -tls-key must be given together")
+		}
+		if tlsCert != "" {
+			tlsConfig, err := serverTLSConfig(tlsCert, tlsKey)
+			if err != nil {
+				return err
+			}
+			cfg.tls = tlsConfig
+		}
+		if err := leak.validate(); err != nil {
+			return err
+		}
+		if err := writes.validate(); err != nil {
+			return err
+		}
+		cfg.writeChunks = *writes
+		if background && (cfg.pidfile == "" || logFile == "") {
+			return usageErrorf("-detach requires -pidfile and -log-file")
+		}
+		if service != "" && runtime.GOOS != "windows" {
+			return usageErrorf("-service is only supported on Windows; use -detach")
+		}
+		if background && service != "" {
+			return usageErrorf("-detach cannot be combined with -service")
+		}
+		// A detached server is started with the same flags, and does the rest.
+		if os.Getenv(detachedEnv) == "" {
+			if background {
+				return detach(cfg.pidfile, logFile)
+			}
+			if logFile != "" {
+				restore, err := redirectLog(logFile)
+				if err != nil {
+					return err
+				}
+				defer restore()
+			}
+		}
+		if err := startPprof(*pprofAddr); err != nil {
+			return err
+		}
+		cfg.metricsAddr = *metricsAddr
+		cfg.otlpEndpoint = *otlpEndpoint
+		tl, err := newTimeline(*timelinePath)
+		if err != nil {
+			return err
+		}
+		defer tl.close()
+		cfg.tl = tl
+		if cfg.capture, err = newCapture(*capturePath); err != nil {
+			return err
+		}
+		defer cfg.capture.close()
+		// SIGTERM is never delivered on Windows, but is harmless to ask for.
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		run := func(ctx context.Context) error {
+			checkLeaks := leak.begin("server")
+			err := runServer(ctx, cfg)
+			if leakErr := checkLeaks(); err == nil {
+				err = leakErr
+			}
+			return err
+		}
+		if service != "" {
+			return runService(ctx, service, run)
+		}
+		return run(ctx)
+	}
+}
+
+// serverConfig holds the settings for a server run.
+type serverConfig struct {
+	addr string
+	// protocol is protocolTTRPC, or protocolGRPC to serve the unary methods
+	// over gRPC instead. Empty means protocolTTRPC.
+	protocol string
+	// duplicateRate is the fraction of responses that are sent twice.
+	duplicateRate float64
+	// errorRate is the fraction of methodEcho requests that fail with an
+	// injectedError, of a code chosen at random from errorCodes.
+	errorRate  float64
+	errorCodes []codes.Code
+	// panicRate is the fraction of methodEcho handlers that panic, and
+	// recoverPanics recovers them in an interceptor, rather than leaving them
+	// to ttrpc.
+	panicRate     float64
+	recoverPanics bool
+	// maxConcurrent bounds the number of unary handlers running at once. Zero
+	// means no limit.
+	maxConcurrent int
+	// idleTimeout closes connections with no traffic for this long. Zero disables it.
+	idleTimeout time.Duration
+	// responseBytes is the size of the data returned in each response. If
+	// negative, the request's data is echoed back.
+	responseBytes int
+	// responseDelay is how long each request is held before its response is
+	// returned, which widens the window for the client to fall behind on reading
+	// responses.
+	responseDelay time.Duration
+	// responseJitter adds a uniformly random delay of up to this long to
+	// responseDelay, so that responses complete out of order.
+	responseJitter time.Duration
+	// reorder, if positive, holds each even-valued methodEcho request until
+	// that many odd-valued ones have completed, or for at most reorderHold.
+	reorder     int
+	reorderHold time.Duration
+	// slowDelay is how long methodSlow holds each request.
+	slowDelay time.Duration
+	// seed makes the random choices above, of which requests fail, how long
+	// each is delayed, and which responses are duplicated, along with when
+	// shutdownWithin shuts down. Zero picks one.
+	seed uint64
+	// watchdog reports a stall when a response write is blocked for this long.
+	// Zero disables it.
+	watchdog time.Duration
+	// watchdogExit exits the process once a stall has been reported.
+	watchdogExit bool
+	// drainTimeout is how long to wait for in-flight requests to complete once
+	// ctx is cancelled. Zero closes connections immediately.
+	drainTimeout time.Duration
+	// shutdownWithin, if positive, has the server shut down by itself at a
+	// random time within this long of its first connection, gracefully as on
+	// cancellation, or if shutdownMode is "close", by closing every connection.
+	shutdownWithin time.Duration
+	shutdownMode   string
+	// tls, if set, wraps accepted connections with TLS.
+	tls *tls.Config
+	// pipe configures the listener when listening on a named pipe.
+	pipe pipeConfig
+	// metricsAddr is the address to serve Prometheus metrics on, and
+	// otlpEndpoint the collector to export traces to, if any.
+	metricsAddr  string
+	otlpEndpoint string
+	// transportParams describes transport-specific settings, for logging.
+	transportParams []connParam
+	tl              *timeline
+	// writeChunks splits each write to a connection into chunks.
+	writeChunks writeChunks
+	// capture, if set, records the bytes sent and received on each connection.
+	capture *capture
+	// pidfile, if set, is written with the process ID once listening.
+	pidfile string
+}
+
+func runServer(ctx context.Context, cfg serverConfig) error {
+	l, err := listen(cfg.addr, cfg.pipe)
+	if err != nil {
+		return err
+	}
+	if scheme, _, _ := parseAddr(cfg.addr); scheme == "npipe" {
+		cfg.transportParams = append(cfg.transportParams,
+			connParam{"input buffer size", cfg.pipe.input},
+			connParam{"output buffer size", cfg.pipe.output},
+			connParam{"message mode", cfg.pipe.messageMode})
+		if cfg.pipe.securityDescriptor != "" {
+			cfg.transportParams = append(cfg.transportParams, connParam{"security descriptor", cfg.pipe.securityDescriptor})
+		}
+	}
+	if cfg.tls != nil {
+		l = tls.NewListener(l, cfg.tls)
+		cfg.transportParams = append(cfg.transportParams, connParam{"tls", true})
+	}
+	if cfg.pidfile != "" {
+		var stopListening func()
+		ctx, stopListening, err = listenForStop(ctx)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		defer stopListening()
+		remove, err := writePidfile(cfg.pidfile)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		defer remove()
+	}
+	return serve(ctx, l, cfg)
+}
+
+// The methods registered by the server. Each has a different response shape, so
+// that a response routed to the wrong call is detectable.
+const (
+	serviceName = "MYSERVICE"
+	// methodEcho echoes the request's value and data.
+	methodEcho = "MYMETHOD"
+	// methodPing returns an empty payload.
+	methodPing = "PING"
+	// methodLarge echoes the request's value with largeResponseBytes of data.
+	methodLarge = "LARGE"
+	// auxServiceName is a second service, so that calls are spread across
+	// services as well as methods.
+	auxServiceName = "AUXSERVICE"
+	// methodSlow echoes like methodEcho, after the server's slowDelay.
+	methodSlow = "SLOW"
+	// methodFail always fails with an injectedError.
+	methodFail = "FAIL"
+	// methodFill returns as many bytes of filler data as the request's value,
+	// for responses of a chosen size.
+	methodFill = "FILL"
+	// methodDuplex has the server run a workload of its own back to the
+	// client, as a daemon calls into a shim that calls it; see duplexHandler.
+	methodDuplex = "DUPLEX"
+)
+
+// defaultReorderHold is the default longest time -reorder holds a request.
+const defaultReorderHold = 100 * time.Millisecond
+
+// defaultDrainTimeout is how long in-flight requests are given to complete once
+// the server is shut down, by default.
+const defaultDrainTimeout = 5 * time.Second
+
+// defaultSlowDelay is how long methodSlow holds each request by default.
+const defaultSlowDelay = 10 * time.Millisecond
+
+// largeResponseBytes is the size of the data returned by methodLarge. It is well
+// beyond typical pipe and socket buffer sizes, but within ttrpcMaxMessageSize.
+const largeResponseBytes = 1 << 20
+
+// fillResponse is methodFill's response to req, carrying data, which is the
+// req.Value bytes of filler asked for. The maxsize command sizes responses with
+// it, so the two must agree.
+func fillResponse(req *payload, data []byte) *payload {
+	return &payload{Value: req.Value, Data: data, Checksum: checksum(req.Data), Worker: req.Worker, Sequence: req.Sequence, DataHash: dataHash(req.Sequence, data)}
+}
+
+// serve runs the TTRPC or gRPC server on l until it fails, or until ctx is cancelled.
+// On cancellation the listener is closed and in-flight requests are given up to
+// cfg.drainTimeout to complete before the remaining connections are closed.
+func serve(ctx context.Context, l net.Listener, cfg serverConfig) error {
+	cfg.seed = resolveSeed(cfg.seed)
+	if cfg.protocol == "" {
+		cfg.protocol = protocolTTRPC
+	}
+	params := []connParam{
+		{"protocol", cfg.protocol},
+		{"transport", l.Addr().Network()},
+		{"address", l.Addr()},
+	}
+	params = append(params, cfg.transportParams...)
+	params = append(params,
+		connParam{"duplicate rate", cfg.duplicateRate},
+		connParam{"error rate", cfg.errorRate},
+		connParam{"error codes", cfg.errorCodes},
+		connParam{"panic rate", cfg.panicRate},
+		connParam{"recover panics", cfg.recoverPanics},
+		connParam{"max concurrent", cfg.maxConcurrent},
+		connParam{"idle timeout", cfg.idleTimeout},
+		connParam{"write chunk", cfg.writeChunks.size},
+		connParam{"write pause", cfg.writeChunks.pause},
+		connParam{"response bytes", cfg.responseBytes},
+		connParam{"response delay", cfg.responseDelay},
+		connParam{"response jitter", cfg.responseJitter},
+		connParam{"reorder", cfg.reorder},
+		connParam{"reorder hold", cfg.reorderHold},
+		connParam{"slow delay", cfg.slowDelay},
+		connParam{"watchdog", cfg.watchdog},
+		connParam{"drain timeout", cfg.drainTimeout},
+		connParam{"shutdown within", cfg.shutdownWithin},
+		connParam{"shutdown mode", cfg.shutdownMode},
+		connParam{"seed", cfg.seed})
+	logConnParams("server", params...)
+	l = cfg.tl.wrapListener(cfg.capture.wrapListener(cfg.writeChunks.wrapListener(l)))
+	if cfg.watchdog > 0 {
+		w := newWriteWatcher()
+		l = w.wrapListener(l)
+		go serverWatchdog(ctx, cfg.watchdog, cfg.watchdogExit, w, cfg.tl)
+	}
+	if cfg.duplicateRate > 0 {
+		l = &duplicatingListener{Listener: l, rate: cfg.duplicateRate, seed: cfg.seed}
+	}
+	if cfg.idleTimeout > 0 {
+		l = &idleListener{Listener: l, timeout: cfg.idleTimeout, tl: cfg.tl}
+	}
+	aw := newAcceptWatcher(l)
+	l = aw
+	var respData []byte
+	if cfg.responseBytes >= 0 {
+		respData = filler(cfg.responseBytes)
+	}
+	var served, badRequests, injected, withMetadata atomic.Int64
+	var panicked, recovered atomic.Int64
+	metrics := &serverMetrics{}
+	var interceptors []ttrpc.UnaryServerInterceptor
+	if cfg.metricsAddr != "" {
+		metrics.latency = newLatencyHistogram()
+		interceptors = append(interceptors, metrics.interceptor)
+	}
+	// Traced requests are those whose clients sent a trace context; see
+	// tracer.startClient.
+	tr := newTracer(cfg.otlpEndpoint, "ttrpcstress-server")
+	defer tr.close()
+	if tr != nil {
+		interceptors = append(interceptors, tr.interceptor)
+	}
+	// Handlers are limited inside the metrics and tracing interceptors, so that
+	// time spent queued counts towards a request's handling time.
+	limit := newHandlerLimit(cfg.maxConcurrent)
+	if limit != nil {
+		interceptors = append(interceptors, limit.interceptor)
+	}
+	interceptors = append(interceptors, metadataInterceptor(&withMetadata))
+	// Innermost, so that the interceptors outside it see the call fail rather
+	// than the panic.
+	if cfg.recoverPanics {
+		interceptors = append(interceptors, recoverInterceptor(&recovered))
+	}
+	server, err := newRPCServer(cfg.protocol, interceptors)
+	if err != nil {
+		return err
+	}
+	stopMetrics, err := startMetrics(cfg.metricsAddr, func(m *metricsWriter) {
+		writeServerMetrics(m, metrics, limit, &served, &badRequests, &injected, &withMetadata)
+	})
+	if err != nil {
+		l.Close()
+		return err
+	}
+	defer stopMetrics()
+	// However Serve returns, the shutdown below is ended and waited for, so
+	// that it does not outlive the server.
+	ctx, stop := context.WithCancel(ctx)
+	shutdownDone := make(chan struct{})
+	defer func() {
+		stop()
+		<-shutdownDone
+	}()
+	go func() {
+		defer close(shutdownDone)
+		if self, after := waitShutdown(ctx, aw.accepted, cfg.shutdownWithin, cfg.seed); self {
+			infof("shutting down (%s) %v after the first connection", cfg.shutdownMode, after.Round(time.Millisecond))
+			cfg.tl.record("server", "shutdown", "%s", cfg.shutdownMode)
+			if cfg.shutdownMode == "close" {
+				server.Close()
+				return
+			}
+		}
+		// With no time to drain, there is nothing to wait for.
+		if cfg.drainTimeout <= 0 {
+			server.Close()
+			return
+		}
+		dctx, cancel := context.WithTimeout(context.Background(), cfg.drainTimeout)
+		defer cancel()
+		if err := server.Shutdown(dctx); err != nil {
+			warnf("in-flight requests did not drain within %v, closing connections", cfg.drainTimeout)
+			server.Close()
+		}
+	}()
+	largeData := filler(largeResponseBytes)
+	reorder := newReorderer(cfg.reorder, cfg.reorderHold)
+	server.Register(serviceName, map[string]ttrpc.Method{
+		methodEcho: func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+			req := &payload{}
+			if err := unmarshal(req); err != nil {
+				badRequests.Add(1)
+				errorf("failed unmarshalling request: %s", err)
+				return nil, err
+			}
+			id := req.Value
+			served.Add(1)
+			debugf("got request: %d", id)
+			defer reorder.complete(id)
+			if delay := cfg.delay(id); delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			if err := reorder.wait(ctx, id); err != nil {
+				return nil, err
+			}
+			if randomChance(cfg.seed, drawError, id, cfg.errorRate) {
+				injected.Add(1)
+				return nil, injectedError(cfg.errorCode(id), id)
+			}
+			if randomChance(cfg.seed, drawPanic, id, cfg.panicRate) {
+				panicked.Add(1)
+				debugf("request %d: panicking", id)
+				panic(fmt.Sprintf(injectedPanicFormat, id))
+			}
+			return echo(req, respData)
+		},
+		methodPing: func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+			req := &payload{}
+			if err := unmarshal(req); err != nil {
+				return nil, err
+			}
+			return &payload{}, nil
+		},
+		methodLarge: func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+			req := &payload{}
+			if err := unmarshal(req); err != nil {
+				badRequests.Add(1)
+				errorf("failed unmarshalling request: %s", err)
+				return nil, err
+			}
+			served.Add(1)
+			debugf("got large request: %d", req.Value)
+			return echo(req, largeData)
+		},
+		methodDuplex: duplexHandler,
+	})
+	server.Register(auxServiceName, map[string]ttrpc.Method{
+		methodSlow: func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+			req := &payload{}
+			if err := unmarshal(req); err != nil {
+				badRequests.Add(1)
+				errorf("failed unmarshalling request: %s", err)
+				return nil, err
+			}
+			served.Add(1)
+			debugf("got slow request: %d", req.Value)
+			select {
+			case <-time.After(cfg.slowDelay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return echo(req, respData)
+		},
+		methodFail: func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+			req := &payload{}
+			if err := unmarshal(req); err != nil {
+				badRequests.Add(1)
+				errorf("failed unmarshalling request: %s", err)
+				return nil, err
+			}
+			served.Add(1)
+			injected.Add(1)
+			debugf("got failing request: %d", req.Value)
+			return nil, injectedError(cfg.errorCode(req.Value), req.Value)
+		},
+		methodFill: func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+			req := &payload{}
+			if err := unmarshal(req); err != nil {
+				badRequests.Add(1)
+				errorf("failed unmarshalling request: %s", err)
+				return nil, err
+			}
+			served.Add(1)
+			debugf("got fill request: %d", req.Value)
+			// Responses much over the message size limit are refused before
+			// they are allocated.
+			if req.Value > 2*ttrpcMaxMessageSize {
+				return nil, status.Errorf(codes.InvalidArgument, "request %d: fill of more than %d bytes", req.Value, 2*ttrpcMaxMessageSize)
+			}
+			return fillResponse(req, filler(int(req.Value))), nil
+		},
+	})
+	// gRPC serves only the unary methods.
+	if ts, ok := server.(*ttrpc.Server); ok {
+		registerStreams(ts, respData, &served)
+	}
+	if err := server.Serve(ctx, l); err != nil && !errors.Is(err, ttrpc.ErrServerClosed) {
+		return err
+	}
+	<-shutdownDone
+	infof("served %d requests, %d failed to unmarshal", served.Load(), badRequests.Load())
+	reorder.report()
+	limit.report()
+	if n := withMetadata.Load(); n > 0 {
+		infof("verified metadata on %d requests", n)
+	}
+	if n := injected.Load(); n > 0 || cfg.errorRate > 0 {
+		infof("injected %d errors", n)
+	}
+	if cfg.panicRate > 0 {
+		infof("injected %d panics, %d recovered by -recover-panics", panicked.Load(), recovered.Load())
+	}
+	return nil
+}
+
+// delay returns how long to hold the request carrying value before responding
+// to it.
+func (cfg *serverConfig) delay(value uint32) time.Duration {
+	return cfg.responseDelay + randomDuration(cfg.seed, drawJitter, value, cfg.responseJitter)
+}
+
+// errorCode returns the code of the error injected for the request carrying
+// value, chosen from errorCodes.
+func (cfg *serverConfig) errorCode(value uint32) codes.Code {
+	return cfg.errorCodes[int(randomFraction(cfg.seed, drawErrorCode, value)*float64(len(cfg.errorCodes)))]
+}
+
+// echo returns the response to req: its value, and its data unless respData is
+// non-nil. The checksum of req's data is recomputed rather than echoed, so the
+// client can tell that the server received the data intact.
+func echo(req *payload, respData []byte) (*payload, error) {
+	sum := checksum(req.Data)
+	if sum != req.Checksum {
+		return nil, status.Errorf(codes.DataLoss, "request %d: data checksum %08x, expected %08x", req.Value, sum, req.Checksum)
+	}
+	// Requests from clients that predate data hashes carry none.
+	if req.DataHash != 0 {
+		if h := dataHash(req.Sequence, req.Data); h != req.DataHash {
+			return nil, status.Errorf(codes.DataLoss, "request %d: data hash %016x, expected %016x", req.Value, h, req.DataHash)
+		}
+	}
+	resp := &payload{Value: req.Value, Data: req.Data, Checksum: sum, Worker: req.Worker, Sequence: req.Sequence}
+	if respData != nil {
+		resp.Data = respData
+	}
+	resp.DataHash = dataHash(resp.Sequence, resp.Data)
+	return resp, nil
+}
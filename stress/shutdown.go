@@ -0,0 +1,78 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// errServerShutdown ends a client run with -expect-shutdown once a call has
+// been failed by the server closing its connection.
+var errServerShutdown = errors.New("server shut down")
+
+// acceptWatcher is a net.Listener whose accepted channel is closed once it has
+// accepted its first connection.
+type acceptWatcher struct {
+	net.Listener
+	once     sync.Once
+	accepted chan struct{}
+}
+
+func newAcceptWatcher(l net.Listener) *acceptWatcher {
+	return &acceptWatcher{Listener: l, accepted: make(chan struct{})}
+}
+
+func (l *acceptWatcher) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.once.Do(func() { close(l.accepted) })
+	}
+	return c, err
+}
+
+// waitShutdown waits until ctx is cancelled or, if within is positive, until a
+// random time, chosen with seed, within that long of the first connection
+// arriving on accepted.
+// It reports whether the server is to shut down on its own, rather than because
+// ctx was cancelled, and how long after the first connection.
+func waitShutdown(ctx context.Context, accepted <-chan struct{}, within time.Duration, seed uint64) (bool, time.Duration) {
+	if within <= 0 {
+		<-ctx.Done()
+		return false, 0
+	}
+	select {
+	case <-accepted:
+	case <-ctx.Done():
+		return false, 0
+	}
+	after := randomDuration(seed, drawShutdown, 0, within)
+	t := time.NewTimer(after)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true, after
+	case <-ctx.Done():
+		return false, 0
+	}
+}
+
+// drainContext returns a context that is cancelled timeout after parent is, so
+// that work under way when parent is cancelled has that long to finish. It
+// carries parent's values. A timeout of zero returns parent itself.
+func drainContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return parent, func() {}
+	}
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	stop := context.AfterFunc(parent, func() {
+		t := time.AfterFunc(timeout, cancel)
+		<-ctx.Done()
+		t.Stop()
+	})
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,62 @@
+package stress
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDrainContext(t *testing.T) {
+	type key struct{}
+	const timeout = 100 * time.Millisecond
+	tests := []struct {
+		name string
+		// timeout is given to drainContext; cancelParent and cancelDrain say
+		// which of the two cancellations follow.
+		timeout      time.Duration
+		cancelParent bool
+		cancelDrain  bool
+		// withinTimeout is whether the drain context is to be done before
+		// timeout has passed, and afterTimeout whether it is to be by twice that.
+		withinTimeout, afterTimeout bool
+	}{
+		{name: "parent running", timeout: timeout},
+		{name: "parent cancelled", timeout: timeout, cancelParent: true, afterTimeout: true},
+		{name: "cancelled", timeout: timeout, cancelDrain: true, withinTimeout: true, afterTimeout: true},
+		{name: "no timeout, parent running"},
+		{name: "no timeout, parent cancelled", cancelParent: true, withinTimeout: true, afterTimeout: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent, cancelParent := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+			defer cancelParent()
+			ctx, cancel := drainContext(parent, tt.timeout)
+			defer cancel()
+			if v := ctx.Value(key{}); v != "value" {
+				t.Errorf("Value() = %v, want the parent's", v)
+			}
+			if tt.cancelParent {
+				cancelParent()
+			}
+			if tt.cancelDrain {
+				cancel()
+			}
+			if done := doneWithin(ctx, timeout/2); done != tt.withinTimeout {
+				t.Fatalf("done within the timeout = %v, want %v", done, tt.withinTimeout)
+			}
+			if done := doneWithin(ctx, 2*timeout); done != tt.afterTimeout {
+				t.Fatalf("done after the timeout = %v, want %v", done, tt.afterTimeout)
+			}
+		})
+	}
+}
+
+// doneWithin reports whether ctx is done within d.
+func doneWithin(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
@@ -0,0 +1,50 @@
+package stress
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// throttleReads returns c, limited to reading rate bytes per second. A rate of
+// zero returns c unchanged.
+func throttleReads(c net.Conn, rate float64) net.Conn {
+	if rate <= 0 {
+		return c
+	}
+	return &slowReadConn{Conn: c, rate: rate}
+}
+
+// slowReadConn wraps a net.Conn, holding on to each chunk it reads until the
+// read rate allows it to be returned. Meanwhile, responses back up in the
+// socket buffers until the server's writes block, which is the client that
+// does not keep up with responses on which the known deadlocks depend. Many
+// workers only make that likely; a throttled read makes it certain.
+type slowReadConn struct {
+	net.Conn
+	rate float64
+	mu   sync.Mutex
+	// next is the time by which the bytes read so far are due, at rate.
+	next time.Time
+}
+
+func (c *slowReadConn) Read(b []byte) (int, error) {
+	// Read at most a hundredth of a second's worth at once, so that a large
+	// buffered read is fed steadily rather than in a burst after a long wait.
+	if chunk := max(1, int(c.rate/100)); len(b) > chunk {
+		b = b[:chunk]
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		// Time spent idle earns no allowance for a burst later.
+		if now := time.Now(); c.next.Before(now) {
+			c.next = now
+		}
+		c.next = c.next.Add(time.Duration(float64(n) / c.rate * float64(time.Second)))
+		wait := time.Until(c.next)
+		c.mu.Unlock()
+		time.Sleep(wait)
+	}
+	return n, err
+}
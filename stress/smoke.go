@@ -0,0 +1,67 @@
+package stress
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	smokeIters   = 10000
+	smokeWorkers = 16
+)
+
+func smokeCommand(fs *flag.FlagSet) func(context.Context) error {
+	timelinePath := timelineFlag(fs)
+	return func(ctx context.Context) error {
+		tl, err := newTimeline(*timelinePath)
+		if err != nil {
+			return err
+		}
+		defer tl.close()
+		if err := runSmoke(ctx, tl); err != nil {
+			return fmt.Errorf("FAIL: %w", err)
+		}
+		return nil
+	}
+}
+
+// runSmoke is a one-command sanity check of a freshly built binary. It starts
+// an in-process server, runs a short workload against it, and reports PASS/FAIL.
+// Any response mismatch fails the run.
+//
+// It goes through Serve and RunClient, as a Go test embedding the workload
+// would, so that it doubles as an example of them.
+func runSmoke(ctx context.Context, tl *timeline) error {
+	l := newInprocListener()
+	serverCtx, stopServer := context.WithCancel(ctx)
+	serverErr := make(chan error, 1)
+	go func() { serverErr <- Serve(serverCtx, tl.wrapListener(l), ServerOptions{}) }()
+
+	// Per-request logging would drown out the result.
+	defer quietRequests()()
+
+	res, err := RunClient(ctx, ClientOptions{
+		Dial: func() (net.Conn, error) {
+			c, err := l.dial()
+			if err != nil {
+				return nil, err
+			}
+			return tl.wrapConn(c, "client"), nil
+		},
+		Iters:   smokeIters,
+		Workers: smokeWorkers,
+	})
+	stopServer()
+	if serverErr := <-serverErr; err == nil {
+		err = serverErr
+	}
+	if err != nil {
+		return err
+	}
+	elapsed := time.Duration(res.ElapsedMs * float64(time.Millisecond))
+	infof("PASS: %d requests, %d workers, elapsed time: %v (%.0f req/s)", res.Succeeded, res.Workers, elapsed, res.RequestsPerSec)
+	return nil
+}
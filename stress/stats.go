@@ -0,0 +1,148 @@
+package stress
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// percentile returns the p'th percentile (0-100) of sorted, using the
+// nearest-rank method. sorted must be in ascending order.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func sortDurations(d []time.Duration) {
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+}
+
+// latencyShards is the number of independently locked shards in a
+// latencyRecorder. Workers are spread across shards so that recording does not
+// serialize them.
+const latencyShards = 64
+
+// latencyRecorder collects call latencies from many workers. They are also
+// counted in hist, if it is set, for -metrics.
+type latencyRecorder struct {
+	hist   *latencyHistogram
+	shards [latencyShards]struct {
+		mu      sync.Mutex
+		samples []time.Duration
+		// Pad each shard to its own cache line to avoid false sharing.
+		_ [32]byte
+	}
+}
+
+func (r *latencyRecorder) record(worker int, d time.Duration) {
+	s := &r.shards[uint(worker)%latencyShards]
+	s.mu.Lock()
+	s.samples = append(s.samples, d)
+	s.mu.Unlock()
+	r.hist.observe(d)
+}
+
+// sorted returns all recorded samples in ascending order.
+func (r *latencyRecorder) sorted() []time.Duration {
+	var all []time.Duration
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.Lock()
+		all = append(all, s.samples...)
+		s.mu.Unlock()
+	}
+	sortDurations(all)
+	return all
+}
+
+// latencySummary is the distribution of call latencies over a run.
+type latencySummary struct {
+	count                         int
+	min, mean, p50, p90, p99, max time.Duration
+	histogram                     []histogramBucket
+}
+
+// histogramBucket counts the latencies below upper, and at or above the upper
+// bound of the bucket before it.
+type histogramBucket struct {
+	upper time.Duration
+	count int
+}
+
+func summarizeLatency(sorted []time.Duration) latencySummary {
+	s := latencySummary{count: len(sorted)}
+	if len(sorted) == 0 {
+		return s
+	}
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	s.min = sorted[0]
+	s.mean = total / time.Duration(len(sorted))
+	s.p50 = percentile(sorted, 50)
+	s.p90 = percentile(sorted, 90)
+	s.p99 = percentile(sorted, 99)
+	s.max = sorted[len(sorted)-1]
+	s.histogram = histogram(sorted)
+	return s
+}
+
+// histogram buckets sorted by upper bounds in a 1-2-5 series from 1µs, which
+// reads easily and gives similar resolution across orders of magnitude. Empty
+// buckets below the smallest latency are omitted.
+func histogram(sorted []time.Duration) []histogramBucket {
+	var buckets []histogramBucket
+	i := 0
+	for decade := time.Microsecond; i < len(sorted); decade *= 10 {
+		for _, m := range []time.Duration{1, 2, 5} {
+			upper := decade * m
+			n := 0
+			for i < len(sorted) && sorted[i] < upper {
+				i++
+				n++
+			}
+			if n > 0 || len(buckets) > 0 {
+				buckets = append(buckets, histogramBucket{upper: upper, count: n})
+			}
+			if i == len(sorted) {
+				break
+			}
+		}
+	}
+	return buckets
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// report logs the latency distribution and throughput over elapsed.
+func (s latencySummary) report(elapsed time.Duration) {
+	infof("requests:   %d in %v (%.0f req/s)", s.count, elapsed.Round(time.Millisecond), float64(s.count)/elapsed.Seconds())
+	infof("latency ms: min %.3f, mean %.3f, p50 %.3f, p90 %.3f, p99 %.3f, max %.3f",
+		ms(s.min), ms(s.mean), ms(s.p50), ms(s.p90), ms(s.p99), ms(s.max))
+	if len(s.histogram) == 0 {
+		return
+	}
+	const barWidth = 40
+	peak := 0
+	for _, b := range s.histogram {
+		peak = max(peak, b.count)
+	}
+	infof("latency histogram:")
+	for _, b := range s.histogram {
+		bar := strings.Repeat("#", (b.count*barWidth+peak-1)/peak)
+		infof("  < %-8v %10d %s", b.upper, b.count, bar)
+	}
+}
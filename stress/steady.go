@@ -0,0 +1,60 @@
+package stress
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// measureWindow accumulates stats only for calls that both started and finished
+// within a fixed window, so that dial, warm-up, and teardown costs are excluded.
+//
+// A nil *measureWindow is valid and records nothing.
+type measureWindow struct {
+	mu        sync.Mutex
+	start     time.Time
+	end       time.Time
+	open      atomic.Bool
+	completed int64
+	totalNs   int64
+	maxNs     int64
+}
+
+// begin opens the window for the given duration, starting now.
+func (w *measureWindow) begin(d time.Duration) {
+	w.mu.Lock()
+	w.start = time.Now()
+	w.end = w.start.Add(d)
+	w.mu.Unlock()
+	w.open.Store(true)
+}
+
+func (w *measureWindow) record(start, end time.Time) {
+	if w == nil || !w.open.Load() {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if start.Before(w.start) || end.After(w.end) {
+		return
+	}
+	ns := int64(end.Sub(start))
+	w.completed++
+	w.totalNs += ns
+	if ns > w.maxNs {
+		w.maxNs = ns
+	}
+}
+
+func (w *measureWindow) report() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	d := w.end.Sub(w.start)
+	var mean time.Duration
+	if w.completed > 0 {
+		mean = time.Duration(w.totalNs / w.completed)
+	}
+	infof("steady-state window: %s to %s (%v)", w.start.Format(time.RFC3339Nano), w.end.Format(time.RFC3339Nano), d)
+	infof("steady-state: %d calls completed in window (%.0f req/s), latency mean %v, max %v",
+		w.completed, float64(w.completed)/d.Seconds(), mean, time.Duration(w.maxNs))
+}
@@ -0,0 +1,380 @@
+//go:build protogo
+
+package stress
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/ttrpc"
+)
+
+// Streams are registered as their own service, as ttrpc does not allow a service
+// name to be registered twice.
+const (
+	streamService = "MYSTREAMSERVICE"
+	// streamMethod echoes each message sent on a bidirectional stream.
+	streamMethod = "MYSTREAM"
+	// serverStreamMethod sends as many messages as the request's value, each
+	// echoing the request with its value replaced by the message's index.
+	serverStreamMethod = "MYSERVERSTREAM"
+	// clientStreamMethod receives messages until the client closes its side,
+	// then responds with their count as the value and their streamDigest as
+	// the checksum.
+	clientStreamMethod = "MYCLIENTSTREAM"
+)
+
+// streamingSupported reports whether this build of ttrpc supports streams, which
+// were introduced in v1.2.0.
+const streamingSupported = true
+
+// registerStreams registers the stream methods on server. Echoed data is replaced
+// with respData if it is non-nil. Each message is counted in served.
+func registerStreams(server *ttrpc.Server, respData []byte, served *atomic.Int64) {
+	server.RegisterService(streamService, &ttrpc.ServiceDesc{
+		Streams: map[string]ttrpc.Stream{
+			streamMethod: {
+				Handler: func(ctx context.Context, ss ttrpc.StreamServer) (interface{}, error) {
+					for {
+						req := &payload{}
+						if err := ss.RecvMsg(req); err != nil {
+							if errors.Is(err, io.EOF) {
+								return nil, nil
+							}
+							return nil, err
+						}
+						served.Add(1)
+						debugf("got stream message: %d", req.Value)
+						resp, err := echo(req, respData)
+						if err != nil {
+							return nil, err
+						}
+						if err := ss.SendMsg(resp); err != nil {
+							return nil, err
+						}
+					}
+				},
+				StreamingClient: true,
+				StreamingServer: true,
+			},
+			serverStreamMethod: {
+				Handler: func(ctx context.Context, ss ttrpc.StreamServer) (interface{}, error) {
+					req := &payload{}
+					if err := ss.RecvMsg(req); err != nil {
+						return nil, err
+					}
+					debugf("got server stream request: %d messages", req.Value)
+					resp, err := echo(req, respData)
+					if err != nil {
+						return nil, err
+					}
+					for i := uint32(0); i < req.Value; i++ {
+						resp.Value = i
+						if err := ss.SendMsg(resp); err != nil {
+							return nil, err
+						}
+						served.Add(1)
+					}
+					return nil, nil
+				},
+				StreamingServer: true,
+			},
+			clientStreamMethod: {
+				Handler: func(ctx context.Context, ss ttrpc.StreamServer) (interface{}, error) {
+					var (
+						n      uint32
+						digest uint32
+					)
+					for {
+						req := &payload{}
+						if err := ss.RecvMsg(req); err != nil {
+							if errors.Is(err, io.EOF) {
+								return &payload{Value: n, Checksum: digest}, nil
+							}
+							return nil, err
+						}
+						served.Add(1)
+						debugf("got client stream message: %d", req.Value)
+						if _, err := echo(req, nil); err != nil {
+							return nil, err
+						}
+						n++
+						digest = streamDigest(digest, req)
+					}
+				},
+				StreamingClient: true,
+			},
+		},
+	})
+}
+
+// streamDigest folds message p into digest, so that the single response to a
+// client stream can confirm that every message arrived intact and in order.
+func streamDigest(digest uint32, p *payload) uint32 {
+	var v [4]byte
+	binary.LittleEndian.PutUint32(v[:], p.Value)
+	digest = crc32.Update(digest, crc32.IEEETable, v[:])
+	return crc32.Update(digest, crc32.IEEETable, p.Data)
+}
+
+// onewayStream is a worker's stream of oneway messages.
+type onewayStream struct {
+	s      ttrpc.ClientStream
+	n      uint32
+	digest uint32
+}
+
+// sendOneway sends request id as a message on the worker's oneway stream,
+// opening it first if need be. No response is expected: ttrpc has no oneway
+// calls, so the message goes on a client stream instead, whose messages are not
+// acknowledged. That keeps a long-lived stream open in the client's stream map
+// among the short-lived ones of the unary calls, and interleaves data frames
+// with their requests and responses on the connection.
+func (r *clientRun) sendOneway(ctx context.Context, worker int, id uint32) error {
+	o := r.oneway[worker]
+	if o == nil {
+		s, err := r.slotFor(worker).current().NewStream(ctx, &ttrpc.StreamDesc{StreamingClient: true}, streamService, clientStreamMethod, nil)
+		if err != nil {
+			return withExit(callExit(err), fmt.Errorf("worker %d: opening oneway stream: %w", worker, err))
+		}
+		o = &onewayStream{s: s}
+		r.oneway[worker] = o
+	}
+	req := r.request(id)
+	debugf("worker %d sending oneway message: %d", worker, id)
+	if err := o.s.SendMsg(req); err != nil {
+		r.failed.Add(1)
+		return withExit(callExit(err), fmt.Errorf("worker %d oneway message %d: %w", worker, id, err))
+	}
+	o.n++
+	o.digest = streamDigest(o.digest, req)
+	r.onewaySent.Add(1)
+	r.progress.mark()
+	return nil
+}
+
+// closeOneway closes the worker's oneway stream, if it has one, and checks that
+// the server received every message sent on it.
+func (r *clientRun) closeOneway(worker int) error {
+	o := r.oneway[worker]
+	if o == nil {
+		return nil
+	}
+	r.oneway[worker] = nil
+	if err := o.s.CloseSend(); err != nil {
+		return withExit(callExit(err), fmt.Errorf("worker %d: closing oneway stream: %w", worker, err))
+	}
+	resp := &payload{}
+	if err := o.s.RecvMsg(resp); err != nil {
+		r.failed.Add(1)
+		return withExit(callExit(err), fmt.Errorf("worker %d: oneway stream: receive: %w", worker, err))
+	}
+	if resp.Value != o.n || resp.Checksum != o.digest {
+		r.failed.Add(1)
+		return withExit(exitMismatch, fmt.Errorf("worker %d: server received %d oneway messages with digest %08x, expected %d with digest %08x", worker, resp.Value, resp.Checksum, o.n, o.digest))
+	}
+	r.onewayConfirmed.Add(int64(o.n))
+	return nil
+}
+
+// stream runs the given worker's stream of iters messages, of r.streamType.
+//
+// ttrpc delivers the messages of every stream on a connection from a single
+// loop, so a stream abandoned on failure, once its buffer fills, blocks all the
+// others. The connection is closed on failure so that they fail too, rather
+// than hang.
+func (r *clientRun) stream(ctx context.Context, worker int, iters int) error {
+	var err error
+	switch r.streamType {
+	case "server":
+		err = r.serverStream(ctx, worker, iters)
+	case "client":
+		err = r.clientStream(ctx, worker, iters)
+	default:
+		err = r.bidiStream(ctx, worker, iters)
+	}
+	if err != nil {
+		r.slotFor(worker).current().Close()
+	}
+	return err
+}
+
+// bidiStream opens a bidirectional stream for the given worker and sends iters
+// messages on it, without waiting for each echo before sending the next. The
+// echoes are verified as they arrive, and must come back in the order sent.
+func (r *clientRun) bidiStream(ctx context.Context, worker int, iters int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s, err := r.slotFor(worker).current().NewStream(ctx, &ttrpc.StreamDesc{StreamingClient: true, StreamingServer: true}, streamService, streamMethod, nil)
+	if err != nil {
+		return withExit(callExit(err), fmt.Errorf("worker %d: opening stream: %w", worker, err))
+	}
+
+	// sent holds each message not yet echoed, oldest first.
+	type sentMsg struct {
+		start time.Time
+		token uint64
+	}
+	var (
+		mu   sync.Mutex
+		sent []sentMsg
+	)
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- func() error {
+			for i := 0; i < iters; i++ {
+				debugf("worker %d sending stream message: %d", worker, i)
+				token := r.inflight.begin(worker, uint32(i), r.value(uint32(i)))
+				mu.Lock()
+				sent = append(sent, sentMsg{time.Now(), token})
+				mu.Unlock()
+				r.active.Add(1)
+				if err := s.SendMsg(r.request(uint32(i))); err != nil {
+					return withExit(callExit(err), fmt.Errorf("worker %d stream message %d: send: %w", worker, i, err))
+				}
+			}
+			return s.CloseSend()
+		}()
+	}()
+
+	for i := 0; i < iters; i++ {
+		resp := &payload{}
+		if err := s.RecvMsg(resp); err != nil {
+			r.failed.Add(1)
+			return withExit(callExit(err), fmt.Errorf("worker %d stream message %d: receive: %w", worker, i, err))
+		}
+		end := time.Now()
+		r.active.Add(-1)
+		r.progress.mark()
+		mu.Lock()
+		msg := sent[0]
+		sent = sent[1:]
+		mu.Unlock()
+		r.inflight.end(msg.token)
+		debugf("worker %d got stream message: %d", worker, resp.Value)
+		if err := r.verify(worker, uint32(i), methodEcho, resp); err != nil {
+			r.samples.record(worker, uint32(i), msg.start, end, callMismatch, err)
+			r.failed.Add(1)
+			return withExit(exitMismatch, err)
+		}
+		r.samples.record(worker, uint32(i), msg.start, end, callOK, nil)
+		r.completed.Add(1)
+		r.perWorker.add(worker, 1)
+		r.latency.record(worker, end.Sub(msg.start))
+	}
+	if err := <-sendErr; err != nil {
+		return err
+	}
+	if err := s.RecvMsg(&payload{}); !errors.Is(err, io.EOF) {
+		return withExit(exitMismatch, fmt.Errorf("worker %d: expected end of stream, got %v", worker, err))
+	}
+	return nil
+}
+
+// serverStream opens a stream for the given worker on which the server sends
+// iters messages, each echoing request 0. The latency recorded for each message
+// is the time since the previous one arrived.
+func (r *clientRun) serverStream(ctx context.Context, worker int, iters int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req := r.request(0)
+	req.Value = uint32(iters)
+	token := r.inflight.begin(worker, 0, req.Value)
+	defer r.inflight.end(token)
+	r.active.Add(1)
+	defer r.active.Add(-1)
+	last := time.Now()
+	s, err := r.slotFor(worker).current().NewStream(ctx, &ttrpc.StreamDesc{StreamingServer: true}, streamService, serverStreamMethod, req)
+	if err != nil {
+		return withExit(callExit(err), fmt.Errorf("worker %d: opening stream: %w", worker, err))
+	}
+	want := r.expectedData(0, methodEcho)
+	_, sum := r.requestData(0)
+	for i := 0; i < iters; i++ {
+		resp := &payload{}
+		if err := s.RecvMsg(resp); err != nil {
+			r.failed.Add(1)
+			return withExit(callExit(err), fmt.Errorf("worker %d stream message %d: receive: %w", worker, i, err))
+		}
+		now := time.Now()
+		r.progress.mark()
+		debugf("worker %d got server stream message: %d", worker, resp.Value)
+		err := diffData(want, resp.Data)
+		switch {
+		case err != nil:
+			err = fmt.Errorf("worker %d stream message %d: %w", worker, i, err)
+		case resp.Value != uint32(i):
+			err = fmt.Errorf("worker %d stream message %d: expected message %d but got %d", worker, i, i, resp.Value)
+		case resp.Checksum != sum:
+			err = fmt.Errorf("worker %d stream message %d: server computed request checksum %08x, expected %08x", worker, i, resp.Checksum, sum)
+		}
+		if err != nil {
+			r.samples.record(worker, uint32(i), last, now, callMismatch, err)
+			r.failed.Add(1)
+			return withExit(exitMismatch, err)
+		}
+		r.samples.record(worker, uint32(i), last, now, callOK, nil)
+		r.completed.Add(1)
+		r.perWorker.add(worker, 1)
+		r.latency.record(worker, now.Sub(last))
+		last = now
+	}
+	if err := s.RecvMsg(&payload{}); !errors.Is(err, io.EOF) {
+		return withExit(exitMismatch, fmt.Errorf("worker %d: expected end of stream, got %v", worker, err))
+	}
+	return nil
+}
+
+// clientStream opens a stream for the given worker and sends iters messages on
+// it, then checks that the server's single response accounts for all of them.
+// The latency recorded for each message is the time taken to send it, which
+// grows when the server falls behind on reading.
+func (r *clientRun) clientStream(ctx context.Context, worker int, iters int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	token := r.inflight.begin(worker, 0, 0)
+	defer r.inflight.end(token)
+	r.active.Add(1)
+	defer r.active.Add(-1)
+	s, err := r.slotFor(worker).current().NewStream(ctx, &ttrpc.StreamDesc{StreamingClient: true}, streamService, clientStreamMethod, nil)
+	if err != nil {
+		return withExit(callExit(err), fmt.Errorf("worker %d: opening stream: %w", worker, err))
+	}
+	var digest uint32
+	for i := 0; i < iters; i++ {
+		req := r.request(uint32(i))
+		debugf("worker %d sending client stream message: %d", worker, i)
+		start := time.Now()
+		if err := s.SendMsg(req); err != nil {
+			r.failed.Add(1)
+			return withExit(callExit(err), fmt.Errorf("worker %d stream message %d: send: %w", worker, i, err))
+		}
+		end := time.Now()
+		r.progress.mark()
+		r.samples.record(worker, uint32(i), start, end, callOK, nil)
+		r.latency.record(worker, end.Sub(start))
+		digest = streamDigest(digest, req)
+	}
+	if err := s.CloseSend(); err != nil {
+		return withExit(callExit(err), fmt.Errorf("worker %d: closing stream: %w", worker, err))
+	}
+	resp := &payload{}
+	if err := s.RecvMsg(resp); err != nil {
+		r.failed.Add(1)
+		return withExit(callExit(err), fmt.Errorf("worker %d: receive: %w", worker, err))
+	}
+	if resp.Value != uint32(iters) || resp.Checksum != digest {
+		r.failed.Add(1)
+		return withExit(exitMismatch, fmt.Errorf("worker %d: server received %d messages with digest %08x, expected %d with digest %08x", worker, resp.Value, resp.Checksum, iters, digest))
+	}
+	r.completed.Add(int64(iters))
+	r.perWorker.add(worker, int64(iters))
+	return nil
+}
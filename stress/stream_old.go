@@ -0,0 +1,31 @@
+//go:build protogogo
+
+package stress
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/containerd/ttrpc"
+)
+
+// streamingSupported reports whether this build of ttrpc supports streams, which
+// were introduced in v1.2.0.
+const streamingSupported = false
+
+func registerStreams(server *ttrpc.Server, respData []byte, served *atomic.Int64) {}
+
+func (r *clientRun) stream(ctx context.Context, worker int, iters int) error {
+	return errors.New("streaming requires ttrpc v1.2.0 or later")
+}
+
+type onewayStream struct{}
+
+func (r *clientRun) sendOneway(ctx context.Context, worker int, id uint32) error {
+	return errors.New("oneway messages require ttrpc v1.2.0 or later")
+}
+
+func (r *clientRun) closeOneway(worker int) error {
+	return nil
+}
@@ -0,0 +1,150 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// throughputInterval is the period of the throughput samples kept for the
+// final report.
+const throughputInterval = time.Second
+
+// workerCounts counts the calls completed by each worker of a pool, so that a
+// stall confined to some workers, such as those sharing a connection, shows up
+// while the others carry on. A nil workerCounts discards all counts.
+type workerCounts []workerCount
+
+type workerCount struct {
+	completed atomic.Int64
+	// done is set once the worker has returned, so that it is not taken for
+	// stalled.
+	done atomic.Bool
+}
+
+func newWorkerCounts(workers int) workerCounts {
+	return make(workerCounts, workers)
+}
+
+func (c workerCounts) add(worker int, n int64) {
+	if c != nil {
+		c[worker%len(c)].completed.Add(n)
+	}
+}
+
+func (c workerCounts) finish(worker int) {
+	if c != nil {
+		c[worker%len(c)].done.Store(true)
+	}
+}
+
+func (c workerCounts) reset() {
+	for i := range c {
+		c[i].completed.Store(0)
+	}
+}
+
+func (c workerCounts) snapshot() []int64 {
+	if c == nil {
+		return nil
+	}
+	counts := make([]int64, len(c))
+	for i := range c {
+		counts[i] = c[i].completed.Load()
+	}
+	return counts
+}
+
+// throughputSample is the throughput over one throughputInterval, or the
+// shorter remainder at the end of a run.
+type throughputSample struct {
+	// elapsed is the time since the start of sampling, at the end of the interval.
+	elapsed time.Duration
+	rate    float64
+	// idle is the workers still running that had completed calls before the
+	// interval but none within it, while waiting on a call sent before it.
+	// Workers with nothing to send, under -rate or held by -control, are not
+	// idle.
+	idle []int
+}
+
+// sampleThroughput samples the throughput of r every throughputInterval until
+// ctx is cancelled. It warns when some workers stop completing calls while
+// others continue, once for each change in which workers are idle.
+func sampleThroughput(ctx context.Context, r *clientRun) []throughputSample {
+	ticker := time.NewTicker(throughputInterval)
+	defer ticker.Stop()
+	var (
+		samples       []throughputSample
+		start         = time.Now()
+		last          = start
+		lastCompleted int64
+		lastCounts    = r.perWorker.snapshot()
+		warned        []int
+	)
+	for {
+		var done bool
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-ticker.C:
+		}
+		now := time.Now()
+		completed := r.completed.Load()
+		counts := r.perWorker.snapshot()
+		var waiting map[int]bool
+		if len(counts) > 0 {
+			waiting = r.inflight.waiting(last)
+		}
+		s := throughputSample{
+			elapsed: now.Sub(start),
+			rate:    float64(completed-lastCompleted) / now.Sub(last).Seconds(),
+		}
+		for w := range counts {
+			if counts[w] == lastCounts[w] && lastCounts[w] > 0 && waiting[w] && !r.perWorker[w].done.Load() {
+				s.idle = append(s.idle, w)
+			}
+		}
+		if len(s.idle) > 0 && completed > lastCompleted && !slices.Equal(s.idle, warned) {
+			warnf("workers %v completed nothing in the last %v, while the others completed %d calls",
+				s.idle, now.Sub(last).Round(time.Millisecond), completed-lastCompleted)
+		}
+		if completed > lastCompleted {
+			warned = s.idle
+		}
+		// A final interval too short to say anything is dropped.
+		if !done || now.Sub(last) >= throughputInterval/10 {
+			samples = append(samples, s)
+		}
+		last, lastCompleted, lastCounts = now, completed, counts
+		if done {
+			return samples
+		}
+	}
+}
+
+// reportThroughput logs the range of throughput over the samples, and how
+// evenly calls were spread across workers.
+func reportThroughput(samples []throughputSample, counts []int64) {
+	if len(samples) == 0 {
+		return
+	}
+	lo, hi := samples[0].rate, samples[0].rate
+	idle := 0
+	for _, s := range samples {
+		lo, hi = min(lo, s.rate), max(hi, s.rate)
+		if len(s.idle) > 0 {
+			idle++
+		}
+	}
+	line := fmt.Sprintf("throughput per %v: min %.0f, max %.0f req/s", throughputInterval, lo, hi)
+	if idle > 0 {
+		line += fmt.Sprintf("; %d of %d intervals had idle workers", idle, len(samples))
+	}
+	infof("%s", line)
+	if len(counts) > 1 {
+		infof("calls per worker: min %d, max %d", slices.Min(counts), slices.Max(counts))
+	}
+}
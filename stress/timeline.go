@@ -0,0 +1,136 @@
+package stress
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// timeline records significant connection lifecycle events (dial, accept, first byte,
+// close, errors) with timestamps. It is intentionally separate from per-request logging:
+// when a stall correlates with something happening to the connection, this is the
+// context needed to see it.
+//
+// A nil *timeline is valid and discards all events.
+type timeline struct {
+	mu    sync.Mutex
+	w     io.Writer
+	c     io.Closer
+	start time.Time
+}
+
+// newTimeline creates a timeline writing to the file at path, or to stderr if path is "-".
+// An empty path returns a nil timeline.
+func newTimeline(path string) (*timeline, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-":
+		return &timeline{w: os.Stderr, start: time.Now()}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &timeline{w: f, c: f, start: time.Now()}, nil
+}
+
+// record writes a single event to the timeline.
+func (t *timeline) record(conn string, event string, format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	detail := fmt.Sprintf(format, args...)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "%s +%-12v %-10s %-12s %s\n", now.Format(time.RFC3339Nano), now.Sub(t.start).Round(time.Microsecond), conn, event, detail)
+}
+
+func (t *timeline) close() error {
+	if t == nil || t.c == nil {
+		return nil
+	}
+	return t.c.Close()
+}
+
+// timelineConn wraps a net.Conn, recording the first byte received, any read/write
+// errors, and close on the timeline.
+type timelineConn struct {
+	net.Conn
+	t         *timeline
+	name      string
+	firstRead sync.Once
+	readErr   sync.Once
+	writeErr  sync.Once
+	closed    sync.Once
+}
+
+func (t *timeline) wrapConn(c net.Conn, name string) net.Conn {
+	if t == nil {
+		return c
+	}
+	return &timelineConn{Conn: c, t: t, name: name}
+}
+
+func (c *timelineConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.firstRead.Do(func() { c.t.record(c.name, "first-byte", "read %d bytes", n) })
+	}
+	if err != nil {
+		c.readErr.Do(func() { c.t.record(c.name, "error", "read: %s", err) })
+	}
+	return n, err
+}
+
+func (c *timelineConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		c.writeErr.Do(func() { c.t.record(c.name, "error", "write: %s", err) })
+	}
+	return n, err
+}
+
+func (c *timelineConn) Close() error {
+	err := c.Conn.Close()
+	c.closed.Do(func() {
+		if err != nil {
+			c.t.record(c.name, "close", "error: %s", err)
+		} else {
+			c.t.record(c.name, "close", "")
+		}
+	})
+	return err
+}
+
+// timelineListener wraps a net.Listener, recording each accepted connection on the
+// timeline and wrapping it so its lifecycle is recorded as well.
+type timelineListener struct {
+	net.Listener
+	t *timeline
+	n int
+}
+
+func (t *timeline) wrapListener(l net.Listener) net.Listener {
+	if t == nil {
+		return l
+	}
+	t.record("listener", "listen", "%s", l.Addr())
+	return &timelineListener{Listener: l, t: t}
+}
+
+func (l *timelineListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		l.t.record("listener", "error", "accept: %s", err)
+		return nil, err
+	}
+	l.n++
+	name := fmt.Sprintf("conn-%d", l.n)
+	l.t.record(name, "accept", "remote %s", c.RemoteAddr())
+	return l.t.wrapConn(c, name), nil
+}
@@ -0,0 +1,88 @@
+package stress
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+// writeTimeseries writes a CSV row to path every interval until ctx is
+// cancelled, giving a complete trace of the run over time: ramp-up, steady
+// state, stalls, and recovery. A final row is written on cancellation.
+func writeTimeseries(ctx context.Context, path string, interval time.Duration, r *clientRun) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "timestamp,completed,inflight,qps,errors,timeouts,goroutines")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var (
+		lastCompleted int64
+		lastTime      = time.Now()
+	)
+	for {
+		var done bool
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-ticker.C:
+		}
+		now := time.Now()
+		completed := r.completed.Load()
+		qps := float64(completed-lastCompleted) / now.Sub(lastTime).Seconds()
+		lastCompleted, lastTime = completed, now
+		fmt.Fprintf(w, "%s,%d,%d,%.1f,%d,%d,%d\n", now.Format(time.RFC3339Nano), completed, r.active.Load(), qps, r.failed.Load(), r.timedOut.Load(), runtime.NumGoroutine())
+		if done {
+			return w.Flush()
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// logProgress logs the number of completed calls out of total every interval
+// until ctx is cancelled, so a long run can be seen to be moving, with the time
+// left until the run is done. A total of zero means the run has no fixed
+// number of calls; end, if set, is when a run of fixed duration ends. With bar
+// set, progress is drawn on a single line of stderr, redrawn in place, which is
+// cleared once the run is done.
+func logProgress(ctx context.Context, interval time.Duration, r *clientRun, total int, end time.Time, bar bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var (
+		start         = time.Now()
+		lastCompleted int64
+		lastTime      = start
+	)
+	var b *progressBar
+	if bar {
+		var stop func()
+		b, stop = startProgressBar(os.Stderr)
+		defer stop()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		completed := r.completed.Load()
+		qps := float64(completed-lastCompleted) / now.Sub(lastTime).Seconds()
+		mean := float64(completed) / now.Sub(start).Seconds()
+		lastCompleted, lastTime = completed, now
+		line := formatProgress(completed, total, qps, mean, progressETA(now, end, completed, total, mean), bar)
+		if b != nil {
+			b.draw(line)
+		} else {
+			infof("%s", line)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package stress
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// serverTLSConfig loads the server's certificate and key. TLS may be layered
+// over any transport, as some deployments tunnel ttrpc through it; it adds the
+// buffering of the TLS record layer, and a handshake, between ttrpc and the
+// connection.
+func serverTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// clientTLSConfig returns a config that verifies the server at addr against the
+// CA certificates in caFile, or the system roots if caFile is empty. Its
+// certificate must be for serverName, which defaults to the host of a tcp://
+// address; other addresses have no host name to verify, so one must be given.
+// If skipVerify is set, the server's certificate is not verified at all.
+func clientTLSConfig(addr, caFile, serverName string, skipVerify bool) (*tls.Config, error) {
+	scheme, target, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if serverName == "" && scheme == "tcp" {
+		if serverName, _, err = net.SplitHostPort(target); err != nil {
+			return nil, err
+		}
+	}
+	if serverName == "" && !skipVerify {
+		return nil, usageErrorf("-tls-server-name or -tls-skip-verify is required for TLS over %s://", scheme)
+	}
+	cfg := &tls.Config{ServerName: serverName, InsecureSkipVerify: skipVerify}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	return cfg, nil
+}
+
+// dialTLS connects to addr and completes a TLS handshake, so that certificate
+// problems are reported at dial rather than on the first call. A non-zero timeout
+// bounds both the connect and the handshake.
+func dialTLS(addr string, cfg *tls.Config, timeout time.Duration) (net.Conn, error) {
+	start := time.Now()
+	c, err := dial(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		c.SetDeadline(start.Add(timeout))
+	}
+	tc := tls.Client(c, cfg)
+	if err := tc.Handshake(); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("tls handshake: %w", err)
+	}
+	c.SetDeadline(time.Time{})
+	return tc, nil
+}
@@ -0,0 +1,315 @@
+package stress
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/ttrpc"
+	"google.golang.org/grpc/status"
+)
+
+// traceparentKey is the metadata key carrying a call's trace context from the
+// client to the server, in the W3C Trace Context format.
+const traceparentKey = "traceparent"
+
+// Limits on the spans held for export. Spans are exported every
+// traceExportInterval, or sooner once traceBatchSize are waiting; beyond
+// maxPendingSpans, as when the collector cannot keep up, they are dropped.
+const (
+	traceExportInterval = time.Second
+	traceBatchSize      = 1000
+	maxPendingSpans     = 100000
+)
+
+// The OTLP span kinds and status codes used.
+const (
+	otlpKindServer  = 2
+	otlpKindClient  = 3
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+// span is one traced call, on either side.
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []spanAttr
+	err      error
+}
+
+type spanAttr struct {
+	key   string
+	value any
+}
+
+// tracer exports spans to an OpenTelemetry collector, batched in the OTLP/HTTP
+// JSON encoding, so that a slow call can be followed across the client and
+// server. It is written by hand, as the OpenTelemetry SDK is a heavy dependency
+// for a few fields of JSON.
+//
+// A nil *tracer is valid and traces nothing.
+type tracer struct {
+	url     string
+	service string
+	http    *http.Client
+	mu      sync.Mutex
+	pending []*span
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	// exported and dropped count spans; failures counts failed exports, of
+	// which only the first is logged.
+	exported atomic.Int64
+	dropped  atomic.Int64
+	failures atomic.Int64
+}
+
+// newTracer starts exporting spans for service to the OTLP/HTTP collector at
+// endpoint, such as http://localhost:4318. An empty endpoint returns nil.
+func newTracer(endpoint, service string) *tracer {
+	if endpoint == "" {
+		return nil
+	}
+	t := &tracer{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service: service,
+		http:    &http.Client{Timeout: 10 * time.Second},
+		kick:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	infof("exporting traces to %s", t.url)
+	go t.run()
+	return t
+}
+
+// finish records s, once it has ended, for export.
+func (t *tracer) finish(s *span, err error) {
+	if t == nil || s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	t.mu.Lock()
+	if len(t.pending) >= maxPendingSpans {
+		t.mu.Unlock()
+		t.dropped.Add(1)
+		return
+	}
+	t.pending = append(t.pending, s)
+	n := len(t.pending)
+	t.mu.Unlock()
+	if n >= traceBatchSize {
+		select {
+		case t.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.kick:
+		case <-t.stop:
+			t.export()
+			return
+		}
+		t.export()
+	}
+}
+
+// export sends the pending spans, in batches of up to traceBatchSize.
+func (t *tracer) export() {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	for len(spans) > 0 {
+		n := min(len(spans), traceBatchSize)
+		if err := t.post(spans[:n]); err != nil {
+			if t.failures.Add(1) == 1 {
+				warnf("exporting traces: %s", err)
+			}
+			t.dropped.Add(int64(n))
+		} else {
+			t.exported.Add(int64(n))
+		}
+		spans = spans[n:]
+	}
+}
+
+func (t *tracer) post(spans []*span) error {
+	body, err := json.Marshal(otlpRequest(t.service, spans))
+	if err != nil {
+		return err
+	}
+	resp, err := t.http.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// close exports the spans still pending and reports how many were exported.
+func (t *tracer) close() {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+	infof("traces: %d spans exported, %d dropped, %d failed exports", t.exported.Load(), t.dropped.Load(), t.failures.Load())
+}
+
+// startClient begins the span of a client call, and returns ctx carrying its
+// trace context for the server.
+func (t *tracer) startClient(ctx context.Context, method string, attrs ...spanAttr) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{
+		name:  serviceFor(method) + "/" + method,
+		kind:  otlpKindClient,
+		start: time.Now(),
+		attrs: append(rpcAttrs(serviceFor(method), method), attrs...),
+	}
+	putRandom(s.traceID[:])
+	putRandom(s.spanID[:])
+	// Metadata already attached to the call, as with -metadata-keys, is kept.
+	md, ok := ttrpc.GetMetadata(ctx)
+	if !ok {
+		md = ttrpc.MD{}
+	}
+	md.Set(traceparentKey, fmt.Sprintf("00-%x-%x-01", s.traceID, s.spanID))
+	return ttrpc.WithMetadata(ctx, md), s
+}
+
+// interceptor traces the server's handling of each unary request that carries
+// a trace context, as a child of the client's span.
+func (t *tracer) interceptor(ctx context.Context, unmarshal ttrpc.Unmarshaler, info *ttrpc.UnaryServerInfo, method ttrpc.Method) (interface{}, error) {
+	md, _ := ttrpc.GetMetadata(ctx)
+	tp, ok := md.Get(traceparentKey)
+	if !ok || len(tp) != 1 {
+		return method(ctx, unmarshal)
+	}
+	s := &span{kind: otlpKindServer, start: time.Now()}
+	if !parseTraceparent(tp[0], s) {
+		return method(ctx, unmarshal)
+	}
+	service, name, _ := strings.Cut(strings.TrimPrefix(info.FullMethod, "/"), "/")
+	s.name = service + "/" + name
+	s.attrs = rpcAttrs(service, name)
+	putRandom(s.spanID[:])
+	resp, err := method(ctx, unmarshal)
+	t.finish(s, err)
+	return resp, err
+}
+
+// parseTraceparent sets the trace and parent IDs of s from a traceparent value,
+// reporting whether it was well formed and sampled.
+func parseTraceparent(v string, s *span) bool {
+	parts := strings.Split(v, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return false
+	}
+	if _, err := hex.Decode(s.traceID[:], []byte(parts[1])); err != nil {
+		return false
+	}
+	if _, err := hex.Decode(s.parentID[:], []byte(parts[2])); err != nil {
+		return false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	return err == nil && flags&1 == 1
+}
+
+func rpcAttrs(service, method string) []spanAttr {
+	return []spanAttr{{"rpc.system", "ttrpc"}, {"rpc.service", service}, {"rpc.method", method}}
+}
+
+func putRandom(b []byte) {
+	for i := range b {
+		b[i] = byte(rand.Intn(256))
+	}
+	// An all-zero ID is invalid.
+	b[0] |= 1
+}
+
+// otlpRequest builds an OTLP ExportTraceServiceRequest in its JSON encoding,
+// in which IDs are hex and 64-bit integers are strings.
+func otlpRequest(service string, spans []*span) map[string]any {
+	out := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		j := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttrs(s.attrs),
+			"status":            map[string]any{"code": otlpStatusOK},
+		}
+		if s.parentID != [8]byte{} {
+			j["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.err != nil {
+			msg := s.err.Error()
+			if st, ok := status.FromError(s.err); ok {
+				msg = fmt.Sprintf("%s: %s", st.Code(), st.Message())
+			}
+			j["status"] = map[string]any{"code": otlpStatusError, "message": msg}
+		}
+		out = append(out, j)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttrs([]spanAttr{{"service.name", service}})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "ttrpcstress"},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+func otlpAttrs(attrs []spanAttr) []any {
+	out := make([]any, 0, len(attrs))
+	for _, a := range attrs {
+		var v map[string]any
+		switch x := a.value.(type) {
+		case string:
+			v = map[string]any{"stringValue": x}
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(x)}
+		case uint32:
+			v = map[string]any{"intValue": strconv.FormatUint(uint64(x), 10)}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(x)}
+		}
+		out = append(out, map[string]any{"key": a.key, "value": v})
+	}
+	return out
+}
@@ -0,0 +1,165 @@
+package stress
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 0 buffer sizes for named pipes is important to help deadlock to occur.
+// It can still occur if there is buffering, but it takes more IO volume to hit it;
+// the client's -payload-bytes flag pads each request to generate that volume faster.
+// The server's -input-buffer and -output-buffer flags override these.
+const (
+	pipeInputBufferSize  = 0
+	pipeOutputBufferSize = 0
+)
+
+// pipeConfig is the configuration of a named pipe listener.
+type pipeConfig struct {
+	// input and output are the buffer sizes, in bytes.
+	input, output int32
+	// messageMode creates the pipe in message mode rather than byte mode, so
+	// that each write is read as a separate message. ttrpc frames its own
+	// messages, so it should not notice, but go-winio reads and closes
+	// message-mode pipes differently.
+	messageMode bool
+	// securityDescriptor, if not empty, is an SDDL string controlling who may
+	// connect, in place of the default of the creator and administrators.
+	securityDescriptor string
+}
+
+var defaultPipeConfig = pipeConfig{input: pipeInputBufferSize, output: pipeOutputBufferSize}
+
+// parseAddr splits an address of the form SCHEME://TARGET. Supported schemes are
+// tcp (TARGET is HOST:PORT), unix (TARGET is a socket path), npipe (TARGET is
+// ./pipe/NAME, as in \\.\pipe\NAME), hvsock (TARGET is VMID:SERVICE, see
+// parseHvsockAddr), and vsock (TARGET is CID:PORT, see parseVsockAddr). The
+// last three are only supported on the platforms that have them.
+func parseAddr(addr string) (scheme, target string, err error) {
+	scheme, target, ok := strings.Cut(addr, "://")
+	if !ok {
+		return "", "", fmt.Errorf("address %q has no scheme, expected tcp://, unix://, npipe://, hvsock://, or vsock://", addr)
+	}
+	switch scheme {
+	case "tcp", "unix", "hvsock", "vsock":
+	case "npipe":
+		target = `\\` + strings.ReplaceAll(target, "/", `\`)
+	default:
+		return "", "", fmt.Errorf("address %q has unsupported scheme %q", addr, scheme)
+	}
+	if target == "" {
+		return "", "", fmt.Errorf("address %q is missing a target", addr)
+	}
+	return scheme, target, nil
+}
+
+// listen creates a listener for addr. See parseAddr for the address format. pc
+// is used only for named pipes.
+func listen(addr string, pc pipeConfig) (net.Listener, error) {
+	scheme, target, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch scheme {
+	case "npipe":
+		return listenPipe(target, pc)
+	case "unix":
+		return listenUnix(target)
+	case "hvsock":
+		return listenHvsock(target)
+	case "vsock":
+		return listenVsock(target)
+	}
+	return net.Listen(scheme, target)
+}
+
+// listenUnix listens on the socket at path. A server that is killed, as one
+// stuck in a deadlock often is, leaves its socket file behind, so a stale socket
+// that no longer accepts connections is removed and the listen retried.
+func listenUnix(path string) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err == nil {
+		return l, nil
+	}
+	if fi, serr := os.Lstat(path); serr != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil, err
+	}
+	if c, derr := net.Dial("unix", path); derr == nil {
+		c.Close()
+		return nil, err
+	}
+	warnf("removing stale socket %s", path)
+	if rerr := os.Remove(path); rerr != nil {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// dial connects to addr, giving up after timeout if it is non-zero. See
+// parseAddr for the address format.
+func dial(addr string, timeout time.Duration) (net.Conn, error) {
+	scheme, target, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch scheme {
+	case "npipe":
+		return dialPipe(target, timeout)
+	case "hvsock":
+		return dialHvsock(target, timeout)
+	case "vsock":
+		return dialVsock(target, timeout)
+	}
+	return net.DialTimeout(scheme, target, timeout)
+}
+
+// localTransport is a transport that can be set up entirely within this process,
+// on a private address.
+type localTransport struct {
+	name string
+	// listen creates a listener, and returns a function to dial it along with a
+	// function to clean up any resources once the listener is closed.
+	listen func() (l net.Listener, dial func() (net.Conn, error), cleanup func(), err error)
+}
+
+// localTransports returns every transport supported on this platform.
+func localTransports() []localTransport {
+	transports := []localTransport{
+		{"inproc", listenInproc},
+		{"tcp", listenLocalTCP},
+		{"unix", listenLocalUnix},
+	}
+	return append(transports, platformTransports()...)
+}
+
+func listenInproc() (net.Listener, func() (net.Conn, error), func(), error) {
+	l := newInprocListener()
+	return l, l.dial, func() {}, nil
+}
+
+func listenLocalTCP() (net.Listener, func() (net.Conn, error), func(), error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	addr := l.Addr().String()
+	return l, func() (net.Conn, error) { return net.Dial("tcp", addr) }, func() {}, nil
+}
+
+func listenLocalUnix() (net.Listener, func() (net.Conn, error), func(), error) {
+	dir, err := os.MkdirTemp("", "ttrpcstress")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	path := filepath.Join(dir, "ttrpcstress.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, nil, err
+	}
+	return l, func() (net.Conn, error) { return net.Dial("unix", path) }, func() { os.RemoveAll(dir) }, nil
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package stress
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+var (
+	errNoPipes  = errors.New("named pipes are only supported on Windows")
+	errNoHvsock = errors.New("hvsock is only supported on Windows; use vsock:// from a Linux guest")
+)
+
+func listenPipe(string, pipeConfig) (net.Listener, error) {
+	return nil, errNoPipes
+}
+
+func dialPipe(string, time.Duration) (net.Conn, error) {
+	return nil, errNoPipes
+}
+
+func listenHvsock(string) (net.Listener, error) {
+	return nil, errNoHvsock
+}
+
+func dialHvsock(string, time.Duration) (net.Conn, error) {
+	return nil, errNoHvsock
+}
+
+func platformTransports() []localTransport {
+	return nil
+}
@@ -0,0 +1,213 @@
+//go:build linux
+
+package stress
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// vsockAddr is the address of an AF_VSOCK socket.
+type vsockAddr struct {
+	cid, port uint32
+}
+
+func (vsockAddr) Network() string { return "vsock" }
+
+func (a vsockAddr) String() string { return fmt.Sprintf("%d:%d", a.cid, a.port) }
+
+// vsockCIDs are the well-known context IDs that may be given by name in a vsock
+// address.
+var vsockCIDs = map[string]uint32{
+	"any":        unix.VMADDR_CID_ANY,
+	"hypervisor": unix.VMADDR_CID_HYPERVISOR,
+	"local":      unix.VMADDR_CID_LOCAL,
+	"host":       unix.VMADDR_CID_HOST,
+}
+
+// parseVsockAddr parses a vsock target of the form CID:PORT. CID is a number, or
+// one of the names in vsockCIDs; a guest dials its Hyper-V host as "host", and
+// listens for it on "any".
+func parseVsockAddr(target string) (vsockAddr, error) {
+	c, p, ok := strings.Cut(target, ":")
+	if !ok {
+		return vsockAddr{}, fmt.Errorf("vsock address %q must be CID:PORT", target)
+	}
+	cid, ok := vsockCIDs[strings.ToLower(c)]
+	if !ok {
+		n, err := strconv.ParseUint(c, 10, 32)
+		if err != nil {
+			return vsockAddr{}, fmt.Errorf("vsock address %q: invalid CID: %w", target, err)
+		}
+		cid = uint32(n)
+	}
+	port, err := strconv.ParseUint(p, 10, 32)
+	if err != nil {
+		return vsockAddr{}, fmt.Errorf("vsock address %q: invalid port: %w", target, err)
+	}
+	return vsockAddr{cid: cid, port: uint32(port)}, nil
+}
+
+// vsockSocket creates a non-blocking vsock socket, wrapped in an os.File so that
+// it uses the runtime's poller and supports deadlines, which the net package
+// does not offer for AF_VSOCK.
+func vsockSocket(addr vsockAddr) (*os.File, syscall.RawConn, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("socket", err)
+	}
+	f := os.NewFile(uintptr(fd), "vsock:"+addr.String())
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, rc, nil
+}
+
+type vsockListener struct {
+	f    *os.File
+	rc   syscall.RawConn
+	addr vsockAddr
+}
+
+func listenVsock(target string) (net.Listener, error) {
+	addr, err := parseVsockAddr(target)
+	if err != nil {
+		return nil, err
+	}
+	f, rc, err := vsockSocket(addr)
+	if err != nil {
+		return nil, err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		if err := unix.Bind(int(fd), &unix.SockaddrVM{CID: addr.cid, Port: addr.port}); err != nil {
+			serr = os.NewSyscallError("bind", err)
+			return
+		}
+		serr = os.NewSyscallError("listen", unix.Listen(int(fd), unix.SOMAXCONN))
+	}); err != nil {
+		serr = err
+	}
+	if serr != nil {
+		f.Close()
+		return nil, &net.OpError{Op: "listen", Net: "vsock", Addr: addr, Err: serr}
+	}
+	return &vsockListener{f: f, rc: rc, addr: addr}, nil
+}
+
+func (l *vsockListener) Accept() (net.Conn, error) {
+	var (
+		nfd  int
+		sa   unix.Sockaddr
+		aerr error
+	)
+	err := l.rc.Read(func(fd uintptr) bool {
+		nfd, sa, aerr = unix.Accept4(int(fd), unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+		return aerr != unix.EAGAIN
+	})
+	if err == nil && aerr != nil {
+		err = os.NewSyscallError("accept", aerr)
+	}
+	if err != nil {
+		return nil, &net.OpError{Op: "accept", Net: "vsock", Addr: l.addr, Err: err}
+	}
+	remote := vsockAddr{}
+	if vm, ok := sa.(*unix.SockaddrVM); ok {
+		remote = vsockAddr{cid: vm.CID, port: vm.Port}
+	}
+	f := os.NewFile(uintptr(nfd), "vsock:"+remote.String())
+	return &vsockConn{File: f, local: l.addr, remote: remote}, nil
+}
+
+// Close closes the listener. Closing the file wakes a blocked Accept.
+func (l *vsockListener) Close() error { return l.f.Close() }
+
+func (l *vsockListener) Addr() net.Addr { return l.addr }
+
+// vsockConn is a connected vsock socket. *os.File provides the reads, writes,
+// and deadlines of a net.Conn.
+type vsockConn struct {
+	*os.File
+	local, remote vsockAddr
+}
+
+func (c *vsockConn) LocalAddr() net.Addr { return c.local }
+
+func (c *vsockConn) RemoteAddr() net.Addr { return c.remote }
+
+func dialVsock(target string, timeout time.Duration) (net.Conn, error) {
+	addr, err := parseVsockAddr(target)
+	if err != nil {
+		return nil, err
+	}
+	f, rc, err := vsockSocket(addr)
+	if err != nil {
+		return nil, err
+	}
+	local, err := connectVsock(f, rc, addr, timeout)
+	if err != nil {
+		f.Close()
+		return nil, &net.OpError{Op: "dial", Net: "vsock", Addr: addr, Err: err}
+	}
+	return &vsockConn{File: f, local: local, remote: addr}, nil
+}
+
+// connectVsock connects the non-blocking socket f to addr, waiting up to timeout
+// if it is non-zero, and returns the local address it was bound to.
+func connectVsock(f *os.File, rc syscall.RawConn, addr vsockAddr, timeout time.Duration) (vsockAddr, error) {
+	if timeout != 0 {
+		f.SetWriteDeadline(time.Now().Add(timeout))
+		defer f.SetWriteDeadline(time.Time{})
+	}
+	var (
+		started bool
+		cerr    error
+	)
+	// The first call starts the connect; once it is in progress, the socket
+	// becomes writable when it completes, and SO_ERROR holds the result.
+	err := rc.Write(func(fd uintptr) bool {
+		if !started {
+			started = true
+			cerr = unix.Connect(int(fd), &unix.SockaddrVM{CID: addr.cid, Port: addr.port})
+			return cerr != unix.EINPROGRESS
+		}
+		n, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ERROR)
+		if err != nil {
+			cerr = err
+			return true
+		}
+		switch e := unix.Errno(n); e {
+		case unix.EINPROGRESS, unix.EALREADY, unix.EINTR:
+			return false
+		case 0:
+			cerr = nil
+		default:
+			cerr = e
+		}
+		return true
+	})
+	if err == nil && cerr != nil {
+		err = os.NewSyscallError("connect", cerr)
+	}
+	if err != nil {
+		return vsockAddr{}, err
+	}
+	var local vsockAddr
+	rc.Control(func(fd uintptr) {
+		if sa, err := unix.Getsockname(int(fd)); err == nil {
+			if vm, ok := sa.(*unix.SockaddrVM); ok {
+				local = vsockAddr{cid: vm.CID, port: vm.Port}
+			}
+		}
+	})
+	return local, nil
+}
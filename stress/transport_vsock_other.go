@@ -0,0 +1,19 @@
+//go:build !linux
+
+package stress
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+var errNoVsock = errors.New("vsock is only supported on Linux; use hvsock:// from a Windows host")
+
+func listenVsock(string) (net.Listener, error) {
+	return nil, errNoVsock
+}
+
+func dialVsock(string, time.Duration) (net.Conn, error) {
+	return nil, errNoVsock
+}
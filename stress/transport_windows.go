@@ -0,0 +1,107 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+	"github.com/Microsoft/go-winio/pkg/guid"
+)
+
+// listenPipe creates a named pipe listener. go-winio creates each instance of
+// the pipe as a client connects to the last, with no limit on their number.
+func listenPipe(path string, pc pipeConfig) (net.Listener, error) {
+	return winio.ListenPipe(path, &winio.PipeConfig{
+		InputBufferSize:    pc.input,
+		OutputBufferSize:   pc.output,
+		MessageMode:        pc.messageMode,
+		SecurityDescriptor: pc.securityDescriptor,
+	})
+}
+
+func dialPipe(path string, timeout time.Duration) (net.Conn, error) {
+	if timeout == 0 {
+		return winio.DialPipe(path, nil)
+	}
+	return winio.DialPipe(path, &timeout)
+}
+
+// hvsockVMIDs are the well-known VM IDs that may be given by name in an hvsock
+// address.
+var hvsockVMIDs = map[string]func() guid.GUID{
+	"wildcard": winio.HvsockGUIDWildcard,
+	"loopback": winio.HvsockGUIDLoopback,
+	"parent":   winio.HvsockGUIDParent,
+	"children": winio.HvsockGUIDChildren,
+	"silohost": winio.HvsockGUIDSiloHost,
+}
+
+// parseHvsockAddr parses a Hyper-V socket target of the form VMID:SERVICE. VMID
+// is a GUID, or one of the names in hvsockVMIDs. SERVICE is a GUID, or a port
+// number for the service that a Linux guest sees as that vsock port.
+func parseHvsockAddr(target string) (*winio.HvsockAddr, error) {
+	vm, service, ok := strings.Cut(target, ":")
+	if !ok {
+		return nil, fmt.Errorf("hvsock address %q must be VMID:SERVICE", target)
+	}
+	addr := &winio.HvsockAddr{}
+	if id, ok := hvsockVMIDs[strings.ToLower(vm)]; ok {
+		addr.VMID = id()
+	} else {
+		g, err := guid.FromString(vm)
+		if err != nil {
+			return nil, fmt.Errorf("hvsock address %q: invalid VM ID: %w", target, err)
+		}
+		addr.VMID = g
+	}
+	if port, err := strconv.ParseUint(service, 10, 32); err == nil {
+		addr.ServiceID = winio.VsockServiceID(uint32(port))
+	} else {
+		g, err := guid.FromString(service)
+		if err != nil {
+			return nil, fmt.Errorf("hvsock address %q: invalid service ID: %w", target, err)
+		}
+		addr.ServiceID = g
+	}
+	return addr, nil
+}
+
+func listenHvsock(target string) (net.Listener, error) {
+	addr, err := parseHvsockAddr(target)
+	if err != nil {
+		return nil, err
+	}
+	return winio.ListenHvsock(addr)
+}
+
+func dialHvsock(target string, timeout time.Duration) (net.Conn, error) {
+	addr, err := parseHvsockAddr(target)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return winio.Dial(ctx, addr)
+}
+
+func platformTransports() []localTransport {
+	return []localTransport{{"npipe", listenLocalPipe}}
+}
+
+func listenLocalPipe() (net.Listener, func() (net.Conn, error), func(), error) {
+	path := fmt.Sprintf(`\\.\pipe\ttrpcstress-%d`, os.Getpid())
+	l, err := listenPipe(path, defaultPipeConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return l, func() (net.Conn, error) { return dialPipe(path, 0) }, func() {}, nil
+}
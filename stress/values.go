@@ -0,0 +1,65 @@
+package stress
+
+import "time"
+
+// randomValue returns the pseudo-random request value for request id under
+// seed. It is a pure function of its inputs, so a run with the same seed sends
+// the same value for every request regardless of how requests are scheduled
+// across workers, and the expected value can be recomputed when verifying, with
+// no state shared between workers.
+func randomValue(seed uint64, id uint32) uint32 {
+	// splitmix64 finalizer.
+	z := seed + uint64(id)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return uint32(z)
+}
+
+// Streams of random decisions drawn with randomFraction. Each decision has its
+// own, so that, for example, which calls are cancelled is independent of how
+// soon they are cancelled.
+const (
+	drawCancel uint64 = iota + 1
+	drawCancelAfter
+	drawOneway
+	drawTrace
+	drawError
+	drawErrorCode
+	drawJitter
+	drawDuplicate
+	drawShutdown
+	drawChurnClose
+	drawChurnCloseAfter
+	drawProxy
+	drawFuzz
+	drawPanic
+)
+
+// randomFraction returns a pseudo-random number in [0, 1) for decision draw
+// about id, usually a request. Like randomValue, it is a pure function of its
+// inputs, so a run repeated with the same seed makes the same decision about
+// each request, however the requests are scheduled.
+func randomFraction(seed, draw uint64, id uint32) float64 {
+	return float64(randomValue(seed^draw<<48, id)) / (1 << 32)
+}
+
+// randomChance reports true with probability rate, for decision draw about id.
+func randomChance(seed, draw uint64, id uint32, rate float64) bool {
+	return rate > 0 && randomFraction(seed, draw, id) < rate
+}
+
+// randomDuration returns a duration in [0, d), for decision draw about id.
+func randomDuration(seed, draw uint64, id uint32, d time.Duration) time.Duration {
+	return time.Duration(randomFraction(seed, draw, id) * float64(d))
+}
+
+// resolveSeed returns seed, or if it is zero, a seed chosen from the clock. The
+// seed used is logged with the connection parameters, so that a failing run
+// can be repeated with -seed.
+func resolveSeed(seed uint64) uint64 {
+	if seed == 0 {
+		return uint64(time.Now().UnixNano())
+	}
+	return seed
+}
@@ -0,0 +1,82 @@
+package stress
+
+import (
+	"math"
+	"testing"
+)
+
+// TestRandomValue pins values for a few seeds, so that a seed logged by one
+// build repeats the same run in another.
+func TestRandomValue(t *testing.T) {
+	tests := []struct {
+		seed uint64
+		id   uint32
+		want uint32
+	}{
+		{0, 0, 0},
+		{0, 1, 2065550767},
+		{1, 0, 269157861},
+		{42, 7, 1161260381},
+		{42, 8, 2661167012},
+	}
+	for _, tt := range tests {
+		if got := randomValue(tt.seed, tt.id); got != tt.want {
+			t.Errorf("randomValue(%d, %d) = %d, want %d", tt.seed, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestRandomFraction(t *testing.T) {
+	const (
+		seed = 42
+		n    = 10000
+	)
+	same := 0
+	for id := uint32(0); id < n; id++ {
+		f := randomFraction(seed, drawCancel, id)
+		if f < 0 || f >= 1 {
+			t.Fatalf("randomFraction(%d, drawCancel, %d) = %v, want it in [0, 1)", seed, id, f)
+		}
+		if again := randomFraction(seed, drawCancel, id); again != f {
+			t.Fatalf("randomFraction(%d, drawCancel, %d) = %v, then %v", seed, id, f, again)
+		}
+		if randomFraction(seed, drawOneway, id) == f {
+			same++
+		}
+	}
+	if same > 0 {
+		t.Errorf("drawCancel and drawOneway made the same draw for %d of %d ids", same, n)
+	}
+}
+
+func TestRandomChance(t *testing.T) {
+	const (
+		seed = 42
+		n    = 10000
+	)
+	tests := []struct {
+		rate float64
+		// tolerance is how far the fraction of ids chosen may stray from rate.
+		tolerance float64
+	}{
+		{rate: 0},
+		{rate: -1},
+		{rate: 0.01, tolerance: 0.005},
+		{rate: 0.25, tolerance: 0.02},
+		{rate: 0.5, tolerance: 0.02},
+		{rate: 1},
+		{rate: 2},
+	}
+	for _, tt := range tests {
+		chosen := 0
+		for id := uint32(0); id < n; id++ {
+			if randomChance(seed, drawTrace, id, tt.rate) {
+				chosen++
+			}
+		}
+		want := math.Max(math.Min(tt.rate, 1), 0)
+		if got := float64(chosen) / n; math.Abs(got-want) > tt.tolerance {
+			t.Errorf("randomChance with rate %v chose %v of ids, want %v ± %v", tt.rate, got, want, tt.tolerance)
+		}
+	}
+}
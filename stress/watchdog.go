@@ -0,0 +1,122 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// progress records the time at which a call last completed.
+type progress struct {
+	last atomic.Int64
+}
+
+func (p *progress) mark() {
+	p.last.Store(time.Now().UnixNano())
+}
+
+func (p *progress) since() time.Duration {
+	return time.Since(time.Unix(0, p.last.Load()))
+}
+
+// watchdog reports a stall if no call completes within timeout while calls are
+// outstanding. On a stall it dumps all goroutine stacks to stderr, followed by the
+// outstanding calls, longest in flight first, as that is usually the stuck one. It
+// reports at most once per stall, and runs until ctx is cancelled. If exit is set,
+// the process exits with exitStalled after the first report.
+func watchdog(ctx context.Context, timeout time.Duration, exit bool, p *progress, inflight *inflightTracker, tl *timeline) {
+	interval := timeout / 4
+	if interval <= 0 {
+		interval = timeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	fired := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		idle := p.since()
+		if idle < timeout {
+			fired = false
+			continue
+		}
+		if fired {
+			continue
+		}
+		calls := inflight.snapshot()
+		if len(calls) == 0 {
+			continue
+		}
+		fired = true
+		tl.record("client", "stall", "no progress for %v, %d calls outstanding", idle.Round(time.Millisecond), len(calls))
+		reportStall(idle, calls)
+		if exit {
+			tl.close()
+			os.Exit(exitStalled)
+		}
+	}
+}
+
+func reportStall(idle time.Duration, calls []inflightCall) {
+	fmt.Fprintf(os.Stderr, "=== STALL: no call completed in %v, %d calls outstanding ===\n", idle.Round(time.Millisecond), len(calls))
+	dumpStacks()
+	fmt.Fprintf(os.Stderr, "=== outstanding calls, longest first ===\n")
+	listInflight(calls)
+}
+
+// serverWatchdog reports a stall if a write to a connection has been blocked for
+// timeout, which means the client has stopped reading responses. A server cannot
+// tell a stuck call from an idle client by its calls alone, as ttrpc writes
+// responses after the handler returns. Otherwise it behaves as watchdog.
+func serverWatchdog(ctx context.Context, timeout time.Duration, exit bool, w *writeWatcher, tl *timeline) {
+	interval := timeout / 4
+	if interval <= 0 {
+		interval = timeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	fired := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		name, blocked, n := w.longest()
+		if blocked < timeout {
+			fired = false
+			continue
+		}
+		if fired {
+			continue
+		}
+		fired = true
+		tl.record(name, "stall", "write blocked for %v, %d connections blocked", blocked.Round(time.Millisecond), n)
+		fmt.Fprintf(os.Stderr, "=== STALL: write to %s blocked for %v, %d connections blocked ===\n", name, blocked.Round(time.Millisecond), n)
+		dumpStacks()
+		if exit {
+			tl.close()
+			os.Exit(exitStalled)
+		}
+	}
+}
+
+// dumpStacks writes the stacks of all goroutines to stderr.
+func dumpStacks() {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	fmt.Fprintf(os.Stderr, "=== goroutine dump ===\n%s\n", buf)
+}
@@ -0,0 +1,55 @@
+package stress
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"math"
+)
+
+func wireHashCommand(fs *flag.FlagSet) func(context.Context) error {
+	return func(context.Context) error {
+		h, err := wireHash()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wire hash: %s\n", h)
+		return nil
+	}
+}
+
+// canonicalPayloads is a fixed set of payloads whose serialized form should never
+// change. It covers the varint encoding boundaries of each field.
+func canonicalPayloads() []*payload {
+	return []*payload{
+		{},
+		{Value: 1},
+		{Value: 127},
+		{Value: 128},
+		{Value: 16384},
+		{Value: math.MaxUint32},
+		{Value: 1, Data: filler(1)},
+		{Value: 1, Data: filler(128)},
+		{Value: 1, Data: filler(128), Checksum: math.MaxUint32},
+		{Value: 1, Worker: 1, Sequence: 1, DataHash: 1},
+		{Value: 1, Worker: math.MaxUint32, Sequence: math.MaxUint64, DataHash: math.MaxUint64},
+	}
+}
+
+// wireHash returns a hash over the serialized bytes of the canonical payloads.
+// A change in the hash means the wire format of payloads has drifted, such as
+// after regenerating the protogo or protogogo types, which would break interop
+// between builds using different variants.
+func wireHash() (string, error) {
+	h := sha256.New()
+	for _, p := range canonicalPayloads() {
+		b, err := marshalPayload(p)
+		if err != nil {
+			return "", err
+		}
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
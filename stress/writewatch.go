@@ -0,0 +1,86 @@
+package stress
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// writeWatcher tracks the writes in progress on every connection accepted
+// through it, so that a write blocked on a peer that has stopped reading can
+// be detected.
+type writeWatcher struct {
+	mu    sync.Mutex
+	n     int
+	conns map[*watchedConn]struct{}
+}
+
+func newWriteWatcher() *writeWatcher {
+	return &writeWatcher{conns: make(map[*watchedConn]struct{})}
+}
+
+func (w *writeWatcher) wrapListener(l net.Listener) net.Listener {
+	return &watchedListener{Listener: l, w: w}
+}
+
+// longest returns the name of the connection whose current write has been
+// blocked the longest and for how long, along with the number of connections
+// with a write in progress.
+func (w *writeWatcher) longest() (name string, blocked time.Duration, n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	for c := range w.conns {
+		start := c.writing.Load()
+		if start == 0 {
+			continue
+		}
+		n++
+		if d := now.Sub(time.Unix(0, start)); d > blocked {
+			name, blocked = c.name, d
+		}
+	}
+	return name, blocked, n
+}
+
+type watchedListener struct {
+	net.Listener
+	w *writeWatcher
+}
+
+func (l *watchedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.w.mu.Lock()
+	defer l.w.mu.Unlock()
+	l.w.n++
+	wc := &watchedConn{Conn: c, w: l.w, name: fmt.Sprintf("conn-%d", l.w.n)}
+	l.w.conns[wc] = struct{}{}
+	return wc, nil
+}
+
+type watchedConn struct {
+	net.Conn
+	w    *writeWatcher
+	name string
+	// writing is the time in Unix nanoseconds at which the write in progress
+	// started, or zero if there is none.
+	writing atomic.Int64
+}
+
+func (c *watchedConn) Write(b []byte) (int, error) {
+	c.writing.Store(time.Now().UnixNano())
+	defer c.writing.Store(0)
+	return c.Conn.Write(b)
+}
+
+func (c *watchedConn) Close() error {
+	c.w.mu.Lock()
+	delete(c.w.conns, c)
+	c.w.mu.Unlock()
+	return c.Conn.Close()
+}